@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiTimeout bounds how long a request waits for the game loop to pick it up.
+const apiTimeout = 2 * time.Second
+
+// apiServer serves the --api-port HTTP control API. Handlers never touch the
+// game themselves: each queues a job that Update runs between steps on the
+// game goroutine, then waits for its result.
+type apiServer struct {
+	jobs chan apiJob
+}
+
+type apiJob struct {
+	run   func(g *Game) (any, error)
+	reply chan apiResult
+}
+
+type apiResult struct {
+	value any
+	err   error
+}
+
+type apiSpawnRequest struct {
+	X        float32 `json:"x"`
+	Y        float32 `json:"y"`
+	VX       float32 `json:"vx"`
+	VY       float32 `json:"vy"`
+	Material string  `json:"material"`
+	Radius   float32 `json:"radius"`
+}
+
+// startAPI listens on port and serves:
+//
+//	POST   /particles        spawn one, body {"x","y","material","radius","vx","vy"}
+//	DELETE /particles        remove every particle
+//	GET    /particles/count  {"count": n}
+//	GET    /settings         the settings as saved in scene files
+func startAPI(port int) (*apiServer, error) {
+	a := &apiServer{jobs: make(chan apiJob, 64)}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /particles", a.spawn)
+	mux.HandleFunc("DELETE /particles", a.clear)
+	mux.HandleFunc("GET /particles/count", a.count)
+	mux.HandleFunc("GET /settings", a.settings)
+	go http.Serve(ln, mux)
+	return a, nil
+}
+
+// run executes the queued jobs. Update calls it once per tick, between steps.
+func (a *apiServer) run(g *Game) {
+	for {
+		select {
+		case job := <-a.jobs:
+			value, err := job.run(g)
+			job.reply <- apiResult{value: value, err: err}
+		default:
+			return
+		}
+	}
+}
+
+// do queues fn for the game loop and writes its result as JSON.
+func (a *apiServer) do(w http.ResponseWriter, fn func(g *Game) (any, error)) {
+	job := apiJob{run: fn, reply: make(chan apiResult, 1)}
+	timeout := time.After(apiTimeout)
+	select {
+	case a.jobs <- job:
+	case <-timeout:
+		writeAPIError(w, http.StatusServiceUnavailable, errors.New("game loop busy"))
+		return
+	}
+	select {
+	case res := <-job.reply:
+		if errors.Is(res.err, errParticleLimit) {
+			writeAPIError(w, http.StatusConflict, res.err)
+			return
+		}
+		if res.err != nil {
+			writeAPIError(w, http.StatusBadRequest, res.err)
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, res.value)
+	case <-timeout:
+		writeAPIError(w, http.StatusServiceUnavailable, errors.New("game loop did not answer"))
+	}
+}
+
+func (a *apiServer) spawn(w http.ResponseWriter, r *http.Request) {
+	var req apiSpawnRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("bad spawn request: %w", err))
+		return
+	}
+	shape, ok := materialShapes[req.Material]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown material %q (want solid, water, gas, static or sand)", req.Material))
+		return
+	}
+	a.do(w, func(g *Game) (any, error) {
+		index, err := g.spawnAt(shape, createPos(req.X, req.Y), req.Radius, Velocity{vx: req.VX, vy: req.VY})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"index": index, "radius": g.balls[index].radius}, nil
+	})
+}
+
+func (a *apiServer) clear(w http.ResponseWriter, r *http.Request) {
+	a.do(w, func(g *Game) (any, error) {
+		g.clearScene()
+		return map[string]int{"count": 0}, nil
+	})
+}
+
+func (a *apiServer) count(w http.ResponseWriter, r *http.Request) {
+	a.do(w, func(g *Game) (any, error) {
+		return map[string]int{"count": len(g.balls)}, nil
+	})
+}
+
+func (a *apiServer) settings(w http.ResponseWriter, r *http.Request) {
+	a.do(w, func(g *Game) (any, error) {
+		return settingsToDTO(g.settings), nil
+	})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Benchmark world size, independent of the display so results are comparable
+// between machines.
+const (
+	benchWidth  = 1280
+	benchHeight = 720
+)
+
+// materialShapes maps the material names accepted by --bench-material and the
+// HTTP API to the shape that spawns them.
+var materialShapes = map[string]ShapeType{
+	"solid":  ShapeCircle,
+	"water":  ShapeWater,
+	"gas":    ShapeGas,
+	"static": ShapeStatic,
+	"sand":   ShapeSand,
+}
+
+// runBenchmark fills a headless Simulation with count particles of material
+// laid out in a grid, runs steps physics steps and prints the throughput.
+func runBenchmark(steps, count int, material string) error {
+	shape, ok := materialShapes[material]
+	if !ok {
+		return fmt.Errorf("unknown material %q (want solid, water, gas, static or sand)", material)
+	}
+	if steps < 1 || count < 1 {
+		return fmt.Errorf("steps and count must be positive")
+	}
+
+	sim := newBenchSimulation(count, shape)
+
+	const dt = float32(1) / simulationTickRate
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < steps; i++ {
+		sim.Step(dt)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	fmt.Printf("%d steps, %d %s particles: %.1f steps/s, %.3f ms/step, %.1f allocs/step, %.1f KB/step\n",
+		steps, count, material, float64(steps)/elapsed.Seconds(), float64(elapsed.Microseconds())/1000/float64(steps),
+		float64(after.Mallocs-before.Mallocs)/float64(steps), float64(after.TotalAlloc-before.TotalAlloc)/1024/float64(steps))
+	return nil
+}
+
+// newBenchSimulation lays out count particles of shape in rows across a
+// benchWidth-wide world. The world is benchHeight tall, or taller when the
+// rows need it, so every particle starts above the floor.
+func newBenchSimulation(count int, shape ShapeType) *Simulation {
+	settings := defaultSettings()
+	radius := settings.spawnRadius(shape, 10)
+	spacing := radius * 2
+	cols := int((benchWidth - 2*screenPadding) / spacing)
+	rows := (count + cols - 1) / cols
+	height := max(benchHeight, 2*screenPadding+float32(rows)*spacing)
+
+	sim := NewSimulation(benchWidth, height)
+	for i := 0; i < count; i++ {
+		x := screenPadding + radius + float32(i%cols)*spacing
+		y := screenPadding + radius + float32(i/cols)*spacing
+		sim.AddParticle(createParticle(shape, createPos(x, y), radius))
+	}
+	return sim
+}
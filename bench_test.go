@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newWaterBlock is a headless simulation holding a square block of n water
+// particles at rest spacing, stepped once so the water caches are filled.
+func newWaterBlock(n int) *Simulation {
+	s := NewSimulation(1280, 720)
+	side := 1
+	for side*side < n {
+		side++
+	}
+	for i := range n {
+		pos := createPos(100+float32(i%side)*waterRestDistance, 100+float32(i/side)*waterRestDistance)
+		s.AddParticle(createParticle(ShapeWater, pos, 2))
+	}
+	s.Step(1.0 / simulationTickRate)
+	return s
+}
+
+func BenchmarkWaterDensity(b *testing.B) {
+	for _, n := range []int{5000, 10000} {
+		s := newWaterBlock(n)
+		b.Run(fmt.Sprintf("serial/%d", n), func(b *testing.B) {
+			for range b.N {
+				s.waterDensityRange(0, len(s.waterIndices), 0, waterInteraction)
+			}
+		})
+		b.Run(fmt.Sprintf("parallel/%d", n), func(b *testing.B) {
+			for range b.N {
+				parallelRange(len(s.waterIndices), func(lo, hi int) {
+					s.waterDensityRange(lo, hi, 0, waterInteraction)
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkStep10k(b *testing.B) {
+	for _, material := range []string{"solid", "water"} {
+		b.Run(material, func(b *testing.B) {
+			sim := newBenchSimulation(10000, materialShapes[material])
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				sim.Step(1.0 / simulationTickRate)
+			}
+		})
+	}
+}
+
+func TestBenchSimulationFitsInWorld(t *testing.T) {
+	for _, count := range []int{1, 2000, 10000} {
+		for name, shape := range materialShapes {
+			s := newBenchSimulation(count, shape)
+			floor := s.height - screenPadding
+			for i := range s.balls {
+				b := &s.balls[i]
+				if b.pos.x-b.radius < 0 || b.pos.x+b.radius > s.width || b.pos.y-b.radius < 0 || b.pos.y+b.radius > floor {
+					t.Fatalf("%d %s particles: particle %d at %v (r %v) is outside the %vx%v world", count, name, i, b.pos, b.radius, s.width, s.height)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkSpatialHashRebuild clears and refills a hash the way Step does
+// every frame; once warm it should not allocate.
+func BenchmarkSpatialHashRebuild(b *testing.B) {
+	sim := newBenchSimulation(10000, ShapeCircle)
+	h := newSpatialHash(maxSpawnRadius * 2)
+	rebuild := func() {
+		h.Clear()
+		for i := range sim.balls {
+			h.insert(i, h.coord(sim.balls[i].pos.x), h.coord(sim.balls[i].pos.y))
+		}
+	}
+	rebuild()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		rebuild()
+	}
+}
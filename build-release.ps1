@@ -32,6 +32,14 @@ Compress-Archive -Path $outputPath -DestinationPath $zipPath -Force
 
 Remove-Item $outputPath
 
+# The updater refuses releases without a matching SHA-256 in checksums.txt.
+Write-Host "Writing checksums.txt" -ForegroundColor Cyan
+$checksums = Get-ChildItem -Path $buildDir -Filter "*.zip" | ForEach-Object {
+    $hash = (Get-FileHash -Algorithm SHA256 -Path $_.FullName).Hash.ToLower()
+    "$hash  $($_.Name)"
+}
+Set-Content -Path "$buildDir\checksums.txt" -Value $checksums -Encoding ascii
+
 Write-Host "`nBuild complete!" -ForegroundColor Green
 Get-ChildItem -Path $buildDir -Filter "*.zip" | ForEach-Object {
     $size = $_.Length / 1MB
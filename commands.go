@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// commandReader feeds --stdin-commands lines to the game. A goroutine reads
+// them; Update runs them between steps. Commands, one per line:
+//
+//	spawn <material> <x> <y> [radius [vx vy]]
+//	clear
+//	set <gravity|max-speed|collision-restitution|air-drag|top-barrier> <value>
+//	wait <ticks>
+//
+// Blank lines and lines starting with # are skipped. A bad line prints a
+// warning to stderr and is otherwise ignored.
+type commandReader struct {
+	lines chan string
+	wait  int // ticks left before the next command runs
+}
+
+func startCommandReader(r io.Reader) *commandReader {
+	c := &commandReader{lines: make(chan string, 256)}
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Stdin commands stopped: %v\n", err)
+		}
+		close(c.lines)
+	}()
+	return c
+}
+
+// run executes the commands that have arrived, stopping early at a wait.
+func (c *commandReader) run(g *Game) {
+	if c.wait > 0 {
+		c.wait--
+		return
+	}
+	for c.lines != nil && c.wait == 0 {
+		select {
+		case line, ok := <-c.lines:
+			if !ok {
+				c.lines = nil
+				return
+			}
+			if err := c.exec(g, line); err != nil {
+				fmt.Fprintf(os.Stderr, "Command %q ignored: %v\n", line, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *commandReader) exec(g *Game, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "spawn":
+		if len(args) != 3 && len(args) != 4 && len(args) != 6 {
+			return fmt.Errorf("want spawn <material> <x> <y> [radius [vx vy]]")
+		}
+		shape, ok := materialShapes[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown material %q (want solid, water, gas, static or sand)", args[0])
+		}
+		nums, err := parseFloats(args[1:])
+		if err != nil {
+			return err
+		}
+		radius := float32(ballsize)
+		if len(nums) > 2 {
+			radius = nums[2]
+		}
+		var v Velocity
+		if len(nums) > 3 {
+			v = Velocity{vx: nums[3], vy: nums[4]}
+		}
+		_, err = g.spawnAt(shape, createPos(nums[0], nums[1]), radius, v)
+		return err
+	case "clear":
+		g.clearScene()
+		return nil
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("want set <name> <value>")
+		}
+		return setSetting(&g.settings, args[0], args[1])
+	case "wait":
+		if len(args) != 1 {
+			return fmt.Errorf("want wait <ticks>")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid tick count %q", args[0])
+		}
+		c.wait = n
+		return nil
+	}
+	return fmt.Errorf("unknown command %q (want spawn, clear, set or wait)", fields[0])
+}
+
+func parseFloats(args []string) ([]float32, error) {
+	nums := make([]float32, len(args))
+	for i, a := range args {
+		v, err := strconv.ParseFloat(a, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", a)
+		}
+		nums[i] = float32(v)
+	}
+	return nums, nil
+}
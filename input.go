@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// inputFrame is the keyboard and mouse state seen by one Update. Game code
+// reads input through keyPressed, mousePressed, cursorPosition and wheel,
+// which answer from frameInput, so a recording can stand in for live input.
+type inputFrame struct {
+	Keys    []ebiten.Key         `json:"keys,omitempty"`
+	Buttons []ebiten.MouseButton `json:"buttons,omitempty"`
+	X       int                  `json:"x"`
+	Y       int                  `json:"y"`
+	WheelX  float64              `json:"wheel_x,omitempty"`
+	WheelY  float64              `json:"wheel_y,omitempty"`
+}
+
+// inputHeader is the first line of an input recording. The scene file holds
+// the world as it was when recording started and Seed is the random seed the
+// run continued with, so replaying the frames on top reproduces the run.
+type inputHeader struct {
+	InputVersion int    `json:"input_version"`
+	AppVersion   string `json:"app_version"`
+	Seed         int64  `json:"seed"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Scene        string `json:"scene"`
+}
+
+const inputRecordVersion = 1
+
+var frameInput inputFrame
+
+var recordedButtons = []ebiten.MouseButton{
+	ebiten.MouseButtonLeft, ebiten.MouseButtonRight, ebiten.MouseButtonMiddle,
+}
+
+// captureInput reads the live keyboard and mouse state.
+func captureInput() inputFrame {
+	var f inputFrame
+	f.Keys = inpututil.AppendPressedKeys(nil)
+	for _, b := range recordedButtons {
+		if ebiten.IsMouseButtonPressed(b) {
+			f.Buttons = append(f.Buttons, b)
+		}
+	}
+	f.X, f.Y = ebiten.CursorPosition()
+	f.WheelX, f.WheelY = ebiten.Wheel()
+	return f
+}
+
+func keyPressed(k ebiten.Key) bool {
+	return slices.Contains(frameInput.Keys, k)
+}
+
+func mousePressed(b ebiten.MouseButton) bool {
+	return slices.Contains(frameInput.Buttons, b)
+}
+
+func cursorPosition() (int, int) {
+	return frameInput.X, frameInput.Y
+}
+
+func wheel() (float64, float64) {
+	return frameInput.WheelX, frameInput.WheelY
+}
+
+// inputRecorder writes one inputFrame per Update to a JSON-lines file.
+type inputRecorder struct {
+	file *os.File
+	enc  *json.Encoder
+	path string
+}
+
+// startInputRecording saves the current scene next to a new recording and
+// reseeds g.rng with a seed stored in the header, so the run can be replayed
+// from this point.
+func startInputRecording(g *Game) (*inputRecorder, error) {
+	stamp := time.Now().Format("20060102-150405")
+	path := fmt.Sprintf("phixgo-input-%s.jsonl", stamp)
+	scenePath := fmt.Sprintf("phixgo-input-%s.scene.json", stamp)
+	if err := saveSceneToFile(scenePath, g); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	seed := time.Now().UnixNano()
+	r := &inputRecorder{file: f, enc: json.NewEncoder(f), path: path}
+	header := inputHeader{
+		InputVersion: inputRecordVersion,
+		AppVersion:   version,
+		Seed:         seed,
+		Width:        int(g.width),
+		Height:       int(g.height),
+		Scene:        scenePath,
+	}
+	if err := r.enc.Encode(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	g.rng = rand.New(rand.NewSource(seed))
+	return r, nil
+}
+
+func (r *inputRecorder) write(f inputFrame) error {
+	return r.enc.Encode(f)
+}
+
+func (r *inputRecorder) Close() error {
+	return r.file.Close()
+}
+
+// inputReplay reads frames back from a recording.
+type inputReplay struct {
+	file *os.File
+	dec  *json.Decoder
+}
+
+// openInputReplay opens a recording and returns it with its header. The
+// scene is looked up next to the recording.
+func openInputReplay(path string) (*inputReplay, inputHeader, error) {
+	var header inputHeader
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, header, fmt.Errorf("failed to open recording: %w", err)
+	}
+	dec := json.NewDecoder(f)
+	if err := dec.Decode(&header); err != nil {
+		f.Close()
+		return nil, header, fmt.Errorf("failed to read recording header: %w", err)
+	}
+	if header.InputVersion != inputRecordVersion {
+		f.Close()
+		return nil, header, fmt.Errorf("unsupported recording version %d", header.InputVersion)
+	}
+	if header.Scene != "" {
+		header.Scene = filepath.Join(filepath.Dir(path), filepath.Base(header.Scene))
+	}
+	return &inputReplay{file: f, dec: dec}, header, nil
+}
+
+// next returns the following frame, or false once the recording is used up.
+func (r *inputReplay) next() (inputFrame, bool, error) {
+	var f inputFrame
+	if err := r.dec.Decode(&f); err != nil {
+		if errors.Is(err, io.EOF) {
+			return f, false, nil
+		}
+		return f, false, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return f, true, nil
+}
+
+func (r *inputReplay) Close() error {
+	return r.file.Close()
+}
+
+// readInput fills frameInput for this Update from the replay, if one is
+// running, or from the live devices, and appends it to the recording.
+func (g *Game) readInput() {
+	frameInput = captureInput()
+	if g.replay != nil {
+		f, ok, err := g.replay.next()
+		switch {
+		case err != nil:
+			g.updateMessage = err.Error()
+			ok = false
+		case !ok:
+			g.updateMessage = "Replay finished"
+		}
+		if ok {
+			frameInput = f
+		} else {
+			g.replay.Close()
+			g.replay = nil
+		}
+	}
+
+	// F7 is read live so a replay can be recorded again, and is never replayed.
+	recordKey := ebiten.IsKeyPressed(ebiten.KeyF7)
+	if recordKey && !g.prevRecordKey {
+		if g.recorder != nil {
+			g.recorder.Close()
+			g.updateMessage = fmt.Sprintf("Saved input: %s", g.recorder.path)
+			g.recorder = nil
+		} else if r, err := startInputRecording(g); err != nil {
+			g.updateMessage = fmt.Sprintf("Recording failed: %v", err)
+		} else {
+			g.recorder = r
+			g.updateMessage = "Recording input (F7 to stop)"
+		}
+	}
+	g.prevRecordKey = recordKey
+
+	if g.recorder != nil {
+		if err := g.recorder.write(frameInput); err != nil {
+			g.recorder.Close()
+			g.recorder = nil
+			g.updateMessage = fmt.Sprintf("Recording failed: %v", err)
+		}
+	}
+}
@@ -2,20 +2,35 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
 	"io"
 	"log"
 	"math"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
 	"github.com/hajimehoshi/ebiten/v2/vector"
 )
@@ -23,18 +38,14 @@ import (
 const (
 	screenPadding      = float32(50.0)
 	minimumSeparation  = float32(0.0001)
-	minSpawnRadius     = float32(4.0) // Minimum radius for spawning balls
+	minSpawnRadius     = float32(1.0) // Floor for every material's spawn range
 	maxSpawnRadius     = float32(120.0)
 	ballSpawnStep      = 0.5
-	maxCollisionSolves = 4 // Reduced max collision solves for performance
 	penetrationSlop    = float32(0.001)
 	waterRestDistance  = float32(12.0)
 	waterInteraction   = waterRestDistance * 1.8
 	waterViscosity     = float32(0.55)
-	waterSpawnClampMin = float32(3.0)
-	waterSpawnClampMax = float32(20.0)
-	waterRestDensity   = float32(4.5)
-	waterPressureStiff = float32(0.32)
+	waterMaxPairForce  = waterRestDistance * 0.25 // Larger pushes overshoot the neighbor spacing in one frame
 	waterNearStiff     = float32(1.1)
 	waterBoundaryPush  = float32(0.22)
 	waterBoundaryDrag  = float32(0.05)
@@ -42,12 +53,18 @@ const (
 	gasInteraction     = gasRestDistance * 1.5
 	gasPressure        = float32(0.12)
 	gasViscosity       = float32(0.08)
-	gasBuoyancy        = float32(0.25)
 	gasDrag            = float32(0.05)
-	gasSpawnClampMin   = float32(4.0)
-	gasSpawnClampMax   = float32(30.0)
 	gasBoundaryPush    = float32(0.12)
 	gasBoundaryDrag    = float32(0.04)
+	sandRestitution    = float32(0.05)
+	sandFriction       = float32(0.9)
+	sandCohesion       = float32(0.35) // Share of separating and sliding motion removed per frame
+	sandContactMargin  = float32(1.15)
+	ambientTemperature = float32(20.0)
+	heatConductivity   = float32(0.05) // Share of a touching pair's temperature gap closed per tick
+	heatContactMargin  = float32(1.05)
+	coldTemperature    = float32(0.0) // Drawn fully blue in the temperature view
+	hotTemperature     = float32(100.0)
 
 	// Update configuration
 	githubOwner = "bencewokk"
@@ -55,15 +72,21 @@ const (
 	version     = "v1.0.1" // Current version
 
 	defaultSceneFileName = "phixgo-scene.json"
+	stateFileName        = "phixgo-state.json"
+	workspaceFileName    = "phixgo-workspace.json"
+	configFileName       = "config.json" // inside the phixgo user config dir
 )
 
+// screenWidth and screenHeight are the screen size at startup, which the
+// first Game is made at. Layout keeps the current size in Game.width/height.
 var (
 	screenWidth, screenHeight = ebiten.ScreenSizeInFullscreen()
 )
 
 // Game settings (modifiable)
 type Settings struct {
-	gravity              float32
+	gravityX             float32
+	gravityY             float32
 	maxSpeed             float32
 	moveAwayDistance     float32
 	moveAwayStrength     float32
@@ -73,11 +96,67 @@ type Settings struct {
 	airDrag              float32
 	groundFriction       float32
 	hasTopBarrier        bool
+	fluidIterations      int
+	updateSound          bool
+	contacts             contactTable
+	bubbleLift           float32
+	renderDownscale      int
+	foamThreshold        float32
+	zoneGravityX         float32
+	zoneGravityY         float32
+	zoneAdditive         bool
+	gifFrameCount        int
+	gifDownscale         int
+	spawnRateLimit       int
+	spawnCooldown        float32
+	collisionSolves      int
+	renderSkip           int
+	maxParticles         int
+	neighborCap          int
+	waterRestDensity     float32
+	waterPressureStiff   float32
+	cannonInterval       float32
+	cannonBurst          int
+	cannonSpeed          float32
+	idleHint             bool
+	colorRamp            ColorRamp
+	spawnMass            float32
+	integration          IntegrationMethod
+	emitterRate          float32
+	emitterSpeed         float32
+	evictOldest          bool
+	spawnTemperature     float32
+	boilTemperature      float32
+	condenseTemperature  float32
+	phaseRate            float32
+	gifFPS               int
+	screenshotFull       bool
+	tps                  int
+	timeScale            float32
+	antialias            bool
+	background           color.RGBA
+	windOn               bool
+	windStrength         float32
+	windAngle            float32 // degrees from blowing toward +x, positive turning toward +y
+	vortexStrength       float32
+	wellStrength         float32
+	waterSurfaceTension  float32
+	sweptCollisions      bool
+	solidRadiusMin       float32
+	solidRadiusMax       float32
+	waterRadiusMin       float32
+	waterRadiusMax       float32
+	gasRadiusMin         float32
+	gasRadiusMax         float32
+	sandRadiusMin        float32
+	sandRadiusMax        float32
+	gasLifetime          int // steps before gas disappears, 0 for never
 }
 
 func defaultSettings() Settings {
 	return Settings{
-		gravity:              0.2,
+		gravityX:             0,
+		gravityY:             0.2,
 		maxSpeed:             10.0,
 		moveAwayDistance:     100.0,
 		moveAwayStrength:     5.0,
@@ -87,50 +166,301 @@ func defaultSettings() Settings {
 		airDrag:              0.02,
 		groundFriction:       0.8,
 		hasTopBarrier:        false,
+		fluidIterations:      1,
+		updateSound:          false,
+		contacts:             defaultContacts(),
+		bubbleLift:           0,
+		renderDownscale:      1,
+		foamThreshold:        6,
+		zoneGravityX:         0,
+		zoneGravityY:         -0.3,
+		zoneAdditive:         false,
+		gifFrameCount:        180,
+		gifDownscale:         2,
+		spawnRateLimit:       600,
+		spawnCooldown:        0.1,
+		collisionSolves:      4,
+		renderSkip:           0,
+		maxParticles:         0,
+		neighborCap:          0,
+		waterRestDensity:     4.5,
+		waterPressureStiff:   0.32,
+		cannonInterval:       1,
+		cannonBurst:          10,
+		cannonSpeed:          8,
+		idleHint:             true,
+		colorRamp:            colorRamps[0].ramp,
+		spawnMass:            1,
+		integration:          IntegrationEuler,
+		emitterRate:          20,
+		emitterSpeed:         2,
+		evictOldest:          false,
+		spawnTemperature:     ambientTemperature,
+		boilTemperature:      100,
+		condenseTemperature:  10,
+		phaseRate:            0.1,
+		gifFPS:               25,
+		screenshotFull:       false,
+		tps:                  60,
+		timeScale:            1,
+		antialias:            false,
+		background:           backgrounds[0].color,
+		windOn:               false,
+		windStrength:         0.05,
+		windAngle:            0,
+		vortexStrength:       1,
+		wellStrength:         20,
+		waterSurfaceTension:  0,
+		sweptCollisions:      false,
+		solidRadiusMin:       4,
+		solidRadiusMax:       maxSpawnRadius,
+		waterRadiusMin:       3,
+		waterRadiusMax:       20,
+		gasRadiusMin:         4,
+		gasRadiusMax:         30,
+		sandRadiusMin:        2,
+		sandRadiusMax:        8,
+		gasLifetime:          0,
 	}
 }
 
+// Profile bundles the performance knobs so a machine can be tuned in one step.
+type Profile struct {
+	name            string
+	collisionSolves int
+	renderSkip      int
+	maxParticles    int
+	neighborCap     int
+	renderDownscale int
+	fluidIterations int
+}
+
+var profiles = []Profile{
+	{name: "Low Power", collisionSolves: 2, renderSkip: 1, maxParticles: 3000, neighborCap: 16, renderDownscale: 2, fluidIterations: 1},
+	{name: "Default", collisionSolves: 4, renderSkip: 0, maxParticles: 0, neighborCap: 0, renderDownscale: 1, fluidIterations: 1},
+	{name: "High Quality", collisionSolves: 8, renderSkip: 0, maxParticles: 0, neighborCap: 0, renderDownscale: 1, fluidIterations: 3},
+}
+
+// profileFlagNames maps --profile values to entries in profiles.
+var profileFlagNames = map[string]int{"low": 0, "default": 1, "high": 2}
+
+// gravityMagnitude and gravityAngle describe the gravity vector the way the
+// menu edits it. The angle is in degrees from straight down, positive toward +x.
+func (s Settings) gravityMagnitude() float32 {
+	return float32(math.Hypot(float64(s.gravityX), float64(s.gravityY)))
+}
+
+func (s Settings) gravityAngle() float32 {
+	if s.gravityX == 0 && s.gravityY == 0 {
+		return 0
+	}
+	return float32(math.Atan2(float64(s.gravityX), float64(s.gravityY)) * 180 / math.Pi)
+}
+
+func (s *Settings) setGravity(magnitude, angle float32) {
+	sin, cos := math.Sincos(float64(angle) * math.Pi / 180)
+	s.gravityX = magnitude * float32(sin)
+	s.gravityY = magnitude * float32(cos)
+}
+
+// upDirection is the unit vector against gravity, or straight up when
+// gravity is off.
+func (s Settings) upDirection() (float32, float32) {
+	nx, ny, _ := normalize(s.gravityX, s.gravityY)
+	if nx == 0 && ny == 0 {
+		return 0, -1
+	}
+	return -nx, -ny
+}
+
+func (s *Settings) applyProfile(p Profile) {
+	s.collisionSolves = p.collisionSolves
+	s.renderSkip = p.renderSkip
+	s.maxParticles = p.maxParticles
+	s.neighborCap = p.neighborCap
+	s.renderDownscale = p.renderDownscale
+	s.fluidIterations = p.fluidIterations
+}
+
+// profileIndex reports which profile the current knobs match, or -1 once any
+// of them has been changed by hand.
+func (s Settings) profileIndex() int {
+	for i, p := range profiles {
+		if s.collisionSolves == p.collisionSolves && s.renderSkip == p.renderSkip &&
+			s.maxParticles == p.maxParticles && s.neighborCap == p.neighborCap &&
+			s.renderDownscale == p.renderDownscale && s.fluidIterations == p.fluidIterations {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s Settings) profileName() string {
+	if i := s.profileIndex(); i >= 0 {
+		return profiles[i].name
+	}
+	return "Custom"
+}
+
 type Game struct {
-	settings          Settings
-	showMenu          bool
-	selectedOption    int
-	prevEscPressed    bool
-	prevUpPressed     bool
-	prevDownPressed   bool
-	prevSavePressed   bool
-	prevLoadPressed   bool
-	prevSlotPressed   [9]bool
-	collider          spatialHash
-	cellCache         []cellCoord
-	spawnClusterCount int
-	waterCollider     spatialHash
-	waterCellCache    []cellCoord
-	waterIndices      []int
-	waterDensity      []float32
-	waterNearDensity  []float32
-	waterIndexMap     map[int]int
-	solidCollider     spatialHash
-	solidIndices      []int
-	gasCollider       spatialHash
-	gasCellCache      []cellCoord
-	gasIndices        []int
-	updateButtonHover bool
-	updateChecking    bool
-	updateAvailable   bool
-	updateMessage     string
+	Simulation
+	showMenu           bool
+	selectedOption     int
+	prevEscPressed     bool
+	prevUpPressed      bool
+	prevDownPressed    bool
+	prevSavePressed    bool
+	prevLoadPressed    bool
+	prevSlotPressed    [9]bool
+	spawnClusterCount  int
+	updateButtonHover  bool
+	updateChecking     bool
+	updateCancel       context.CancelFunc
+	prevButtonClick    bool
+	updateAvailable    bool
+	updateResult       chan updateCheckResult // pending update check, nil when none
+	updateMessage      string
+	selected           int
+	showForces         bool
+	prevMiddlePressed  bool
+	buckets            []Bucket
+	grabbedBucket      int
+	grabOffset         Pos
+	prevBucketPressed  bool
+	showAxes           bool
+	prevAxesPressed    bool
+	lowResImage        *ebiten.Image
+	tutorialStep       int
+	tutorialShape      ShapeType
+	prevSkipPressed    bool
+	rng                *rand.Rand
+	spawnScatter       bool
+	scatterRadius      float32
+	prevReversePressed bool
+	prevScalePressed   bool
+	zoneDragging       bool
+	zoneStart          Pos
+	gifFrames          []*image.Paletted
+	gifRecording       bool
+	fileMessages       chan string // results from background file writers, shown by Update
+	gifTick            int
+	shotPending        bool
+	prevShotPressed    bool
+	gifImage           *ebiten.Image
+	prevGIFPressed     bool
+	spawnTokens        float32
+	spawnCooldownLeft  float32
+	spawnThrottled     bool
+	menuScroll         int
+	menuRows           []menuRow
+	menuHover          int
+	menuDragX          int
+	menuDragging       bool
+	menuDragged        bool
+	prevMenuClick      bool
+	hideHUD            bool
+	prevHUDPressed     bool
+	drawFrame          int
+	polyDrawing        bool
+	wallStart          Pos
+	blockStart         Pos
+	blockDragging      bool
+	wallDragging       bool
+	prevWallPressed    bool
+	prevWallClick      bool
+	polyPoints         []Pos
+	prevPolyPressed    bool
+	prevPolyClick      bool
+	cannons            []Cannon
+	cannonDragging     bool
+	cannonStart        Pos
+	staticOverlaps     []Pos
+	idleHintDismissed  bool
+	restoreWorkspace   bool
+	updateChannel      string
+	// flagSettings are the physics flags given on the command line and
+	// configSettings the settings they overrode; saveConfig writes the
+	// overridden values back so a flag only lasts for its own run.
+	flagSettings       settingsFlags
+	configSettings     Settings
+	paused             bool
+	prevPausePressed   bool
+	prevStepPressed    bool
+	prevClearPressed   bool
+	prevQuickSave      bool
+	prevQuickLoad      bool
+	emitters           []Emitter
+	prevEmitterKey     bool
+	showTemperature    bool
+	prevTempPressed    bool
+	showVelocities     bool
+	prevVelPressed     bool
+	showDensity        bool
+	prevDensityPressed bool
+	gasShading         bool
+	prevGasShadePress  bool
+	spawnLayer         uint8 // layer given to particles painted with the mouse
+	presetChoice       int   // index into scenePresets for the menu's Load Preset row
+	prevLayerPressed   bool
+	gasShade           []float32
+	showGrid           bool
+	prevGridPressed    bool
+	showEnergy         bool
+	prevWindPressed    bool
+	prevWellPressed    bool
+	prevFreezePressed  bool
+	prevEnergyPressed  bool
+	showHistogram      bool
+	prevHistPressed    bool
+	speedBins          [speedHistogramBins]int
+	metaballs          bool
+	prevMetaPressed    bool
+	metaField          []float32
+	metaPixels         []byte
+	metaImage          *ebiten.Image
+	recorder           *inputRecorder
+	lastTick           time.Time
+	stepDebt           float64        // real seconds of physics owed, less than one step
+	renderPrev         []renderSample // positions before the last step, for interpolation
+	renderAlpha        float32        // how far rendering is from renderPrev to the current positions, 0 to 1
+	exactPositions     bool           // draw physics positions as they are, without interpolation
+	prevExactPress     bool
+	replay             *inputReplay
+	prevRecordKey      bool
+	prevForcesPressed  bool
+	stream             *streamServer  // nil unless --ws-port is set
+	api                *apiServer     // nil unless --api-port is set
+	commands           *commandReader // nil unless --stdin-commands is set
+	csv                *csvExporter   // nil unless --csv-out is set
+	steps              int            // steps taken since launch
+
+	// ids finds a particle's index from its seq; spawnHooks and despawnHooks
+	// run as particles are added and removed. addParticle and the remove
+	// helpers keep all three.
+	ids          map[uint64]int
+	spawnHooks   []particleHook
+	despawnHooks []particleHook
 }
 
+// defaultSpawnClusterCount is how many particles one click spawns until the
+// menu changes it.
+const defaultSpawnClusterCount = 3
+
 func NewGame() *Game {
-	return &Game{
-		settings:          defaultSettings(),
+	g := &Game{
+		Simulation:        *NewSimulation(float32(screenWidth), float32(screenHeight)),
 		showMenu:          false,
-		collider:          newSpatialHash(maxSpawnRadius * 2),
-		spawnClusterCount: 3,
-		waterCollider:     newSpatialHash(waterRestDistance * 2),
-		waterIndexMap:     make(map[int]int),
-		solidCollider:     newSpatialHash(maxSpawnRadius * 2),
-		gasCollider:       newSpatialHash(gasRestDistance * 2),
+		spawnClusterCount: defaultSpawnClusterCount,
+		selected:          -1,
+		grabbedBucket:     -1,
+		tutorialStep:      -1,
+		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		scatterRadius:     60,
+		fileMessages:      make(chan string, 4),
+		ids:               make(map[uint64]int),
 	}
+	g.expire = g.removeParticle
+	return g
 }
 
 type Pos struct {
@@ -145,8 +475,50 @@ type Velocity struct {
 	vx, vy float32
 }
 
+// forceProbe accumulates the velocity changes applied to the inspected
+// particle during one frame, split by the part of the solver that caused them.
+type forceProbe struct {
+	gravity   Velocity
+	buoyancy  Velocity
+	pressure  Velocity
+	viscosity Velocity
+	boundary  Velocity
+	tool      Velocity
+	contact   Velocity
+	wind      Velocity
+	tension   Velocity
+}
+
+// probe records a velocity change on component if index is the inspected particle.
+func (s *Simulation) probe(component *Velocity, index int, dvx, dvy float32) {
+	if index != s.probed {
+		return
+	}
+	component.vx += dvx
+	component.vy += dvy
+}
+
 type ShapeType int
 
+// IntegrationMethod selects how Step advances velocities and positions.
+type IntegrationMethod int
+
+const (
+	// IntegrationEuler adds the whole step's gravity, then moves.
+	IntegrationEuler IntegrationMethod = iota
+	// IntegrationVerlet is velocity Verlet: half of last step's gravity,
+	// move, then half of the gravity at the new position. It drifts far less
+	// in energy for the same step size.
+	IntegrationVerlet
+)
+
+func (m IntegrationMethod) String() string {
+	if m == IntegrationVerlet {
+		return "Verlet"
+	}
+	return "Euler"
+}
+
 const (
 	ShapeCircle ShapeType = iota
 	ShapeSquare
@@ -154,6 +526,8 @@ const (
 	ShapeWater
 	ShapeGas
 	ShapeStatic
+	ShapeGrate
+	ShapeSand
 )
 
 type Ball struct {
@@ -162,10 +536,52 @@ type Ball struct {
 	radius   float32
 	shape    ShapeType
 	material MaterialType
+	body     int // 1-based index into Game.buckets, 0 when free
+	// permeable static particles block solids but let water and gas through.
+	permeable bool
+	local     Pos     // offset from the owning bucket's origin before rotation
+	mass      float32 // 0 is immovable
+	// accel is the gravity from the last step, used by Verlet integration.
+	accel       Velocity
+	temperature float32
+	// phase is progress toward boiling or condensing, from 0 to 1.
+	phase float32
+	// frozen particles hold still and act as immovable in collisions.
+	frozen bool
+	// stillSteps counts consecutive steps below sleepSpeed; see asleep.
+	stillSteps int
+	// age counts the steps a gas particle has lived, for the gas lifetime.
+	age int
+	// layer groups particles that only touch each other. Layer 0 is shared
+	// and meets every layer, so walls and old scenes work unchanged.
+	layer uint8
+	// seq is the spawn order AddParticle assigns; a lower seq is older.
+	seq uint64
+}
+
+// spawnLayers is how many layers N cycles the brush through, shared included.
+const spawnLayers = 4
+
+// layersMeet reports whether a and b collide and exchange fluid forces.
+func layersMeet(a, b *Ball) bool {
+	return a.layer == b.layer || a.layer == 0 || b.layer == 0
 }
 
 func createBall(pos Pos, r float32, shape ShapeType) Ball {
-	return Ball{pos: pos, velocity: Velocity{vx: 0, vy: 0}, radius: r, shape: shape, material: MaterialSolid}
+	return Ball{pos: pos, velocity: Velocity{vx: 0, vy: 0}, radius: r, shape: shape, material: MaterialSolid, mass: defaultMass(MaterialSolid), temperature: ambientTemperature}
+}
+
+// defaultMass is the mass a fresh particle of material gets. Solids and water
+// weigh the same, gas a tenth of that and statics never move. Gas is heavier
+// here than its buoyancy density so solids can still push it around.
+func defaultMass(material MaterialType) float32 {
+	switch material {
+	case MaterialGas:
+		return 0.1
+	case MaterialStatic:
+		return 0
+	}
+	return 1
 }
 
 type MaterialType int
@@ -175,273 +591,2644 @@ const (
 	MaterialWater
 	MaterialGas
 	MaterialStatic
+	MaterialSand
+	materialCount // Number of materials; keep last
 )
 
+// contactRule is how a pair of materials bounces and slides when they touch.
+// restitution scales Settings.collisionRestitution unless fixed is set, and
+// skip leaves the pair to its fluid solver.
+type contactRule struct {
+	restitution float32
+	friction    float32
+	fixed       bool
+	skip        bool
+}
+
+// bounce is the restitution the contact solver uses for the pair.
+func (r contactRule) bounce(collisionRestitution float32) float32 {
+	if r.fixed {
+		return r.restitution
+	}
+	return r.restitution * collisionRestitution
+}
+
+// contactTable holds a contactRule for every pair of materials, indexed by
+// both MaterialTypes. set keeps it symmetric.
+type contactTable [materialCount][materialCount]contactRule
+
+func (t *contactTable) set(a, b MaterialType, r contactRule) {
+	t[a][b] = r
+	t[b][a] = r
+}
+
+// defaultContacts is the interaction matrix new settings start with. Later
+// rules win, so water and gas override sand and water-gas overrides both.
+func defaultContacts() contactTable {
+	var t contactTable
+	for a := range materialCount {
+		for b := range materialCount {
+			t[a][b] = contactRule{restitution: 1, friction: 0.5}
+		}
+	}
+	for m := range materialCount {
+		t.set(MaterialSand, m, contactRule{restitution: sandRestitution, friction: sandFriction, fixed: true})
+	}
+	for m := range materialCount {
+		t.set(MaterialGas, m, contactRule{restitution: 0.3, friction: 0.02})
+	}
+	for m := range materialCount {
+		t.set(MaterialWater, m, contactRule{restitution: 0.25, friction: 0.05})
+	}
+	t.set(MaterialWater, MaterialGas, contactRule{restitution: 0.2, friction: 0.04})
+	t.set(MaterialWater, MaterialWater, contactRule{skip: true})
+	t.set(MaterialGas, MaterialGas, contactRule{skip: true})
+	return t
+}
+
+// materialFromName looks up a material by its name in materialNames.
+func materialFromName(name string) (MaterialType, bool) {
+	for m, n := range materialNames {
+		if n == name {
+			return MaterialType(m), true
+		}
+	}
+	return 0, false
+}
+
+// materialTitle is m's name from materialNames, capitalized for the HUD, or
+// "Unknown" for a material without one.
+func materialTitle(m MaterialType) string {
+	if int(m) >= len(materialNames) || materialNames[m] == "" {
+		return "Unknown"
+	}
+	name := materialNames[m]
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
 func createWaterParticle(pos Pos, r float32) Ball {
 	b := createBall(pos, r, ShapeWater)
 	b.material = MaterialWater
+	b.mass = defaultMass(MaterialWater)
 	return b
 }
 
 func createGasParticle(pos Pos, r float32) Ball {
 	b := createBall(pos, r, ShapeGas)
 	b.material = MaterialGas
+	b.mass = defaultMass(MaterialGas)
+	return b
+}
+
+func createSandParticle(pos Pos, r float32) Ball {
+	b := createBall(pos, r, ShapeSand)
+	b.material = MaterialSand
+	b.mass = defaultMass(MaterialSand)
 	return b
 }
 
 func createStaticSolid(pos Pos, r float32, shape ShapeType) Ball {
 	b := createBall(pos, r, shape)
 	b.material = MaterialStatic
+	b.mass = 0
 	return b
 }
 
-type sceneSettingsDTO struct {
-	Gravity              float32 `json:"gravity"`
-	MaxSpeed             float32 `json:"max_speed"`
-	MoveAwayDistance     float32 `json:"move_away_distance"`
-	MoveAwayStrength     float32 `json:"move_away_strength"`
-	MoveAttractStrength  float32 `json:"move_attract_strength"`
-	GroundRestitution    float32 `json:"ground_restitution"`
-	CollisionRestitution float32 `json:"collision_restitution"`
-	AirDrag              float32 `json:"air_drag"`
-	GroundFriction       float32 `json:"ground_friction"`
-	HasTopBarrier        bool    `json:"has_top_barrier"`
+func createGrate(pos Pos, r float32) Ball {
+	b := createStaticSolid(pos, r, ShapeGrate)
+	b.permeable = true
+	return b
 }
 
-type sceneBallDTO struct {
-	X        float32      `json:"x"`
-	Y        float32      `json:"y"`
-	VX       float32      `json:"vx"`
-	VY       float32      `json:"vy"`
-	Radius   float32      `json:"radius"`
-	Shape    ShapeType    `json:"shape"`
-	Material MaterialType `json:"material"`
+// spawnRadius clamps a brush size to the range allowed for shape's material.
+func (s *Settings) spawnRadius(shape ShapeType, size float64) float32 {
+	material := MaterialSolid
+	switch shape {
+	case ShapeWater:
+		material = MaterialWater
+	case ShapeGas:
+		material = MaterialGas
+	case ShapeSand:
+		material = MaterialSand
+	}
+	lo, hi := s.radiusLimits(material)
+	return float32(math.Min(math.Max(size, float64(lo)), float64(hi)))
 }
 
-type sceneDTO struct {
-	SceneVersion        int              `json:"scene_version"`
-	AppVersion          string           `json:"app_version"`
-	Settings            sceneSettingsDTO `json:"settings"`
-	Balls               []sceneBallDTO   `json:"balls"`
-	BallSize            float64          `json:"ball_size"`
-	MoveAttractDistance float64          `json:"move_attract_distance"`
-	SpawnClusterCount   int              `json:"spawn_cluster_count"`
-	CurrentShape        ShapeType        `json:"current_shape"`
+// radiusLimits is the radius range particles of material may spawn with.
+// Statics and grates share the solid range.
+func (s *Settings) radiusLimits(material MaterialType) (lo, hi float32) {
+	pLo, pHi := s.radiusRange(material)
+	return *pLo, *pHi
 }
 
-func settingsToDTO(s Settings) sceneSettingsDTO {
-	return sceneSettingsDTO{
-		Gravity:              s.gravity,
-		MaxSpeed:             s.maxSpeed,
-		MoveAwayDistance:     s.moveAwayDistance,
-		MoveAwayStrength:     s.moveAwayStrength,
-		MoveAttractStrength:  s.moveAttractStrength,
-		GroundRestitution:    s.groundRestitution,
-		CollisionRestitution: s.collisionRestitution,
-		AirDrag:              s.airDrag,
-		GroundFriction:       s.groundFriction,
-		HasTopBarrier:        s.hasTopBarrier,
+// radiusRange points at the settings holding material's spawn radius range.
+func (s *Settings) radiusRange(material MaterialType) (*float32, *float32) {
+	switch material {
+	case MaterialWater:
+		return &s.waterRadiusMin, &s.waterRadiusMax
+	case MaterialGas:
+		return &s.gasRadiusMin, &s.gasRadiusMax
+	case MaterialSand:
+		return &s.sandRadiusMin, &s.sandRadiusMax
 	}
+	return &s.solidRadiusMin, &s.solidRadiusMax
 }
 
-func settingsFromDTO(d sceneSettingsDTO) Settings {
-	return Settings{
-		gravity:              d.Gravity,
-		maxSpeed:             d.MaxSpeed,
-		moveAwayDistance:     d.MoveAwayDistance,
-		moveAwayStrength:     d.MoveAwayStrength,
-		moveAttractStrength:  d.MoveAttractStrength,
-		groundRestitution:    d.GroundRestitution,
-		collisionRestitution: d.CollisionRestitution,
-		airDrag:              d.AirDrag,
-		groundFriction:       d.GroundFriction,
-		hasTopBarrier:        d.HasTopBarrier,
-	}
+// radiusMaterials orders the spawn range rows in the menu, two rows each.
+var radiusMaterials = [...]struct {
+	material MaterialType
+	name     string
+}{
+	{MaterialSolid, "Solid"},
+	{MaterialWater, "Water"},
+	{MaterialGas, "Gas"},
+	{MaterialSand, "Sand"},
 }
 
-func buildScene(g *Game) sceneDTO {
-	ballDTOs := make([]sceneBallDTO, len(balls))
-	for i := range balls {
-		ballDTOs[i] = sceneBallDTO{
-			X:        balls[i].pos.x,
-			Y:        balls[i].pos.y,
-			VX:       balls[i].velocity.vx,
-			VY:       balls[i].velocity.vy,
-			Radius:   balls[i].radius,
-			Shape:    balls[i].shape,
-			Material: balls[i].material,
-		}
-	}
+// clampRadius limits a spawn radius bound to [lo, hi], so a range's minimum
+// never passes its maximum.
+func clampRadius(r, lo, hi float32) float32 {
+	return float32(math.Min(math.Max(float64(r), float64(lo)), float64(hi)))
+}
 
-	return sceneDTO{
-		SceneVersion:        1,
-		AppVersion:          version,
-		Settings:            settingsToDTO(g.settings),
-		Balls:               ballDTOs,
-		BallSize:            ballsize,
-		MoveAttractDistance: moveAttractDistance,
-		SpawnClusterCount:   g.spawnClusterCount,
-		CurrentShape:        currentShape,
+// createParticle builds a particle of the material that shape spawns.
+func createParticle(shape ShapeType, pos Pos, r float32) Ball {
+	switch shape {
+	case ShapeWater:
+		return createWaterParticle(pos, r)
+	case ShapeGas:
+		return createGasParticle(pos, r)
+	case ShapeStatic:
+		return createStaticSolid(pos, r, ShapeStatic)
+	case ShapeGrate:
+		return createGrate(pos, r)
+	case ShapeSand:
+		return createSandParticle(pos, r)
+	default:
+		return createBall(pos, r, shape)
 	}
 }
 
-func applyScene(g *Game, scene sceneDTO) error {
-	if scene.SceneVersion != 1 {
-		return fmt.Errorf("unsupported scene version: %d", scene.SceneVersion)
-	}
+func isFluid(material MaterialType) bool {
+	return material == MaterialWater || material == MaterialGas
+}
 
-	g.settings = settingsFromDTO(scene.Settings)
+// clearScene removes every particle, bucket walls included, and the state
+// that pointed at them. Zones, obstacles and tools stay.
+func (g *Game) clearScene() {
+	g.clearParticles()
+	g.buckets = g.buckets[:0]
+	g.grabbedBucket = -1
+	g.staticOverlaps = nil
+}
 
-	g.spawnClusterCount = scene.SpawnClusterCount
-	if g.spawnClusterCount < 1 {
-		g.spawnClusterCount = 1
+// errParticleLimit is returned by spawnAt when the max-particle cap leaves no
+// room.
+var errParticleLimit = errors.New("particle limit reached")
+
+// spawnAt adds one particle of shape at pos for scripted spawns (the HTTP API
+// and stdin commands), with the radius clamped to the material's range.
+func (g *Game) spawnAt(shape ShapeType, pos Pos, radius float32, v Velocity) (int, error) {
+	if pos.x < 0 || pos.y < 0 || pos.x > g.width || pos.y > g.height {
+		return -1, fmt.Errorf("position (%g, %g) is outside the %gx%g world", pos.x, pos.y, g.width, g.height)
 	}
-	if g.spawnClusterCount > 50 {
-		g.spawnClusterCount = 50
+	if g.makeRoom(1) == 0 {
+		return -1, errParticleLimit
 	}
+	b := createParticle(shape, pos, g.settings.spawnRadius(shape, float64(radius)))
+	b.velocity = v
+	b.temperature = g.settings.spawnTemperature
+	return g.addParticle(b), nil
+}
 
-	ballsize = scene.BallSize
-	ballsize = math.Max(math.Min(ballsize, float64(maxSpawnRadius)), float64(minSpawnRadius))
-
-	moveAttractDistance = scene.MoveAttractDistance
-	if moveAttractDistance < 10 {
-		moveAttractDistance = 10
-	}
+// particleHook is told the index of a particle just added, or about to be
+// removed while balls[i] is still valid.
+type particleHook func(g *Game, i int)
 
-	currentShape = scene.CurrentShape
+// onSpawn registers h to run after every particle is added.
+func (g *Game) onSpawn(h particleHook) {
+	g.spawnHooks = append(g.spawnHooks, h)
+}
 
-	loadedBalls := make([]Ball, 0, len(scene.Balls))
-	for _, b := range scene.Balls {
-		if b.Radius <= 0 {
-			continue
-		}
-		loadedBalls = append(loadedBalls, Ball{
-			pos:      Pos{x: b.X, y: b.Y},
-			velocity: Velocity{vx: b.VX, vy: b.VY},
-			radius:   b.Radius,
-			shape:    b.Shape,
-			material: b.Material,
-		})
-	}
-	balls = loadedBalls
+// onDespawn registers h to run before every particle is removed, including
+// gas that expires during Step.
+func (g *Game) onDespawn(h particleHook) {
+	g.despawnHooks = append(g.despawnHooks, h)
+}
 
-	return nil
+// particleIndex returns the current index of the particle with seq id.
+func (g *Game) particleIndex(id uint64) (int, bool) {
+	i, ok := g.ids[id]
+	return i, ok
 }
 
-func saveSceneToFile(filename string, g *Game) error {
-	if filename == "" {
-		filename = defaultSceneFileName
-	}
-	filename = filepath.Clean(filename)
-	data, err := json.MarshalIndent(buildScene(g), "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to encode scene: %w", err)
+// addParticle adds b to the simulation, records its id and runs the spawn
+// hooks. Every spawn site goes through it.
+func (g *Game) addParticle(b Ball) int {
+	i := g.AddParticle(b)
+	g.ids[g.balls[i].seq] = i
+	for _, h := range g.spawnHooks {
+		h(g, i)
 	}
+	return i
+}
 
-	tmp := filename + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return fmt.Errorf("failed to write temp scene file: %w", err)
-	}
-	_ = os.Remove(filename)
-	if err := os.Rename(tmp, filename); err != nil {
-		return fmt.Errorf("failed to replace scene file: %w", err)
+// despawn runs the despawn hooks for balls[i] and forgets its id.
+func (g *Game) despawn(i int) {
+	for _, h := range g.despawnHooks {
+		h(g, i)
 	}
-	return nil
+	delete(g.ids, g.balls[i].seq)
 }
 
-func sceneSlotFileName(slot int) string {
-	if slot < 1 {
-		slot = 1
+// removeParticle swap-removes balls[i] from the simulation and keeps the
+// inspector selection pointing at the same particle. The last particle takes
+// index i, so callers walking the slice should go from the end.
+func (g *Game) removeParticle(i int) {
+	if i < 0 || i >= len(g.balls) {
+		return
+	}
+	g.despawn(i)
+	last := len(g.balls) - 1
+	g.SwapRemoveParticle(i)
+	if i < last {
+		g.ids[g.balls[i].seq] = i
+	}
+	if i == g.selected {
+		g.selected = -1
+	} else if last == g.selected {
+		g.selected = i
 	}
-	return fmt.Sprintf("phixgo-scene-%d.json", slot)
 }
 
-func loadSceneFromFile(filename string, g *Game) error {
-	if filename == "" {
-		filename = defaultSceneFileName
-	}
-	filename = filepath.Clean(filename)
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("failed to read scene file: %w", err)
+// removeParticleOrdered is removeParticle for callers that rely on index
+// order. Every later particle shifts down, so their ids are renumbered too.
+func (g *Game) removeParticleOrdered(i int) {
+	if i < 0 || i >= len(g.balls) {
+		return
 	}
-	var scene sceneDTO
-	if err := json.Unmarshal(data, &scene); err != nil {
-		return fmt.Errorf("failed to decode scene file: %w", err)
+	g.despawn(i)
+	g.RemoveParticle(i)
+	for j := i; j < len(g.balls); j++ {
+		g.ids[g.balls[j].seq] = j
 	}
-	if err := applyScene(g, scene); err != nil {
-		return err
+	if i == g.selected {
+		g.selected = -1
+	} else if i < g.selected {
+		g.selected--
 	}
-	return nil
 }
 
-// spatialHash accelerates neighbor lookups via a uniform grid.
-type spatialHash struct {
-	cellSize      float32
-	invCellSize   float32
-	invCellSize64 float64
-	buckets       map[int64][]int
-	usedKeys      []int64
+// clearParticles removes every particle, running the despawn hooks for each.
+func (g *Game) clearParticles() {
+	for i := len(g.balls) - 1; i >= 0; i-- {
+		g.despawn(i)
+	}
+	g.ClearParticles()
+	clear(g.ids)
+	g.selected = -1
 }
 
-type cellCoord struct {
-	x int
-	y int
+type sceneSettingsDTO struct {
+	Gravity              float32           `json:"gravity"` // vertical component
+	GravityX             float32           `json:"gravity_x,omitempty"`
+	MaxSpeed             float32           `json:"max_speed"`
+	MoveAwayDistance     float32           `json:"move_away_distance"`
+	MoveAwayStrength     float32           `json:"move_away_strength"`
+	MoveAttractStrength  float32           `json:"move_attract_strength"`
+	GroundRestitution    float32           `json:"ground_restitution"`
+	CollisionRestitution float32           `json:"collision_restitution"`
+	AirDrag              float32           `json:"air_drag"`
+	GroundFriction       float32           `json:"ground_friction"`
+	HasTopBarrier        bool              `json:"has_top_barrier"`
+	FluidIterations      int               `json:"fluid_iterations,omitempty"`
+	UpdateSound          bool              `json:"update_sound,omitempty"`
+	WaterGasRestitution  *float32          `json:"water_gas_restitution,omitempty"` // Read only; contacts replaces it
+	WaterGasFriction     *float32          `json:"water_gas_friction,omitempty"`    // Read only; contacts replaces it
+	Contacts             []sceneContactDTO `json:"contacts,omitempty"`
+	BubbleLift           float32           `json:"bubble_lift,omitempty"`
+	RenderDownscale      int               `json:"render_downscale,omitempty"`
+	FoamThreshold        *float32          `json:"foam_threshold,omitempty"`
+	ZoneGravityX         *float32          `json:"zone_gravity_x,omitempty"`
+	ZoneGravityY         *float32          `json:"zone_gravity_y,omitempty"`
+	ZoneAdditive         bool              `json:"zone_additive,omitempty"`
+	GIFFrameCount        int               `json:"gif_frame_count,omitempty"`
+	GIFDownscale         int               `json:"gif_downscale,omitempty"`
+	SpawnRateLimit       *int              `json:"spawn_rate_limit,omitempty"`
+	SpawnCooldown        *float32          `json:"spawn_cooldown,omitempty"`
+	CollisionSolves      int               `json:"collision_solves,omitempty"`
+	RenderSkip           int               `json:"render_skip,omitempty"`
+	MaxParticles         int               `json:"max_particles,omitempty"`
+	NeighborCap          int               `json:"neighbor_cap,omitempty"`
+	WaterRestDensity     *float32          `json:"water_rest_density,omitempty"`
+	WaterPressureStiff   *float32          `json:"water_pressure_stiff,omitempty"`
+	CannonInterval       float32           `json:"cannon_interval,omitempty"`
+	CannonBurst          int               `json:"cannon_burst,omitempty"`
+	CannonSpeed          float32           `json:"cannon_speed,omitempty"`
+	IdleHint             *bool             `json:"idle_hint,omitempty"`
+	ColorRamp            *sceneRampDTO     `json:"color_ramp,omitempty"`
+	SpawnMass            float32           `json:"spawn_mass,omitempty"`
+	Integration          int               `json:"integration,omitempty"`
+	EmitterRate          float32           `json:"emitter_rate,omitempty"`
+	EmitterSpeed         *float32          `json:"emitter_speed,omitempty"`
+	EvictOldest          bool              `json:"evict_oldest,omitempty"`
+	SpawnTemperature     *float32          `json:"spawn_temperature,omitempty"`
+	BoilTemperature      *float32          `json:"boil_temperature,omitempty"`
+	CondenseTemperature  *float32          `json:"condense_temperature,omitempty"`
+	PhaseRate            float32           `json:"phase_rate,omitempty"`
+	GIFFPS               int               `json:"gif_fps,omitempty"`
+	ScreenshotFull       bool              `json:"screenshot_full,omitempty"`
+	TPS                  int               `json:"tps,omitempty"`
+	TimeScale            float32           `json:"time_scale,omitempty"`
+	Antialias            bool              `json:"antialias,omitempty"`
+	Background           *[3]uint8         `json:"background,omitempty"`
+	WindOn               bool              `json:"wind_on,omitempty"`
+	WindStrength         *float32          `json:"wind_strength,omitempty"`
+	WindAngle            float32           `json:"wind_angle,omitempty"`
+	VortexStrength       *float32          `json:"vortex_strength,omitempty"`
+	WellStrength         *float32          `json:"well_strength,omitempty"`
+	WaterSurfaceTension  float32           `json:"water_surface_tension,omitempty"`
+	SweptCollisions      bool              `json:"swept_collisions,omitempty"`
+	SolidRadiusMin       *float32          `json:"solid_radius_min,omitempty"`
+	SolidRadiusMax       *float32          `json:"solid_radius_max,omitempty"`
+	WaterRadiusMin       *float32          `json:"water_radius_min,omitempty"`
+	WaterRadiusMax       *float32          `json:"water_radius_max,omitempty"`
+	GasRadiusMin         *float32          `json:"gas_radius_min,omitempty"`
+	GasRadiusMax         *float32          `json:"gas_radius_max,omitempty"`
+	SandRadiusMin        *float32          `json:"sand_radius_min,omitempty"`
+	SandRadiusMax        *float32          `json:"sand_radius_max,omitempty"`
+	GasLifetime          int               `json:"gas_lifetime,omitempty"`
 }
 
-func newSpatialHash(cellSize float32) spatialHash {
-	if cellSize <= 0 {
-		cellSize = 1
-	}
-	inv := 1 / cellSize
-	return spatialHash{
-		cellSize:      cellSize,
-		invCellSize:   inv,
-		invCellSize64: float64(inv),
-		buckets:       make(map[int64][]int),
-	}
+// Bucket is a kinematic container built from static particles. Its particles
+// follow the bucket's pose every frame, so it can be carried and tipped over.
+type Bucket struct {
+	pos   Pos
+	angle float32
 }
 
-func (h *spatialHash) Clear() {
-	for _, key := range h.usedKeys {
-		h.buckets[key] = h.buckets[key][:0]
+const (
+	bucketWidth        = float32(120)
+	bucketHeight       = float32(100)
+	bucketWallRadius   = float32(6)
+	bucketWallLayers   = 2
+	bucketTiltPerFrame = float32(0.03)
+)
+
+// spawnBucket appends a U-shaped bucket centred on pos. Walls are built from
+// overlapping particles in several layers so fluid cannot slip between them.
+func (g *Game) spawnBucket(pos Pos) {
+	g.buckets = append(g.buckets, Bucket{pos: pos})
+	body := len(g.buckets)
+	spacing := bucketWallRadius
+	halfW := bucketWidth / 2
+	halfH := bucketHeight / 2
+	addWall := func(lx, ly float32) {
+		b := createStaticSolid(Pos{x: pos.x + lx, y: pos.y + ly}, bucketWallRadius, ShapeStatic)
+		b.body = body
+		b.local = Pos{x: lx, y: ly}
+		g.addParticle(b)
+	}
+	for layer := 0; layer < bucketWallLayers; layer++ {
+		inset := float32(layer) * spacing
+		for y := -halfH; y <= halfH-inset; y += spacing {
+			addWall(-halfW-inset, y)
+			addWall(halfW+inset, y)
+		}
+		for x := -halfW - inset + spacing; x < halfW+inset; x += spacing {
+			addWall(x, halfH+inset)
+		}
 	}
-	h.usedKeys = h.usedKeys[:0]
 }
 
-func (h *spatialHash) insert(index, ix, iy int) {
-	key := hashKey(ix, iy)
-	bucket := h.buckets[key]
-	if bucket == nil {
-		bucket = make([]int, 0, 8)
+// updateBuckets moves bucket particles to their bucket's pose and gives them
+// the matching velocity so contacts push fluid along with the container.
+func (g *Game) updateBuckets() {
+	if len(g.buckets) == 0 {
+		return
 	}
-	if len(bucket) == 0 {
-		h.usedKeys = append(h.usedKeys, key)
+	for i := range g.balls {
+		if g.balls[i].body == 0 || g.balls[i].body > len(g.buckets) {
+			continue
+		}
+		bucket := &g.buckets[g.balls[i].body-1]
+		sin, cos := math.Sincos(float64(bucket.angle))
+		lx, ly := g.balls[i].local.x, g.balls[i].local.y
+		x := bucket.pos.x + lx*float32(cos) - ly*float32(sin)
+		y := bucket.pos.y + lx*float32(sin) + ly*float32(cos)
+		g.balls[i].velocity = Velocity{vx: x - g.balls[i].pos.x, vy: y - g.balls[i].pos.y}
+		g.balls[i].pos = Pos{x: x, y: y}
 	}
-	bucket = append(bucket, index)
-	h.buckets[key] = bucket
 }
 
-func (h *spatialHash) cell(ix, iy int) []int {
-	key := hashKey(ix, iy)
-	return h.buckets[key]
+// GravityZone replaces (or adds to) the global gravity for particles inside
+// its rectangle.
+type GravityZone struct {
+	min, max Pos
+	gx, gy   float32
 }
 
-func (h *spatialHash) coord(value float32) int {
-	return int(math.Floor(float64(value) * h.invCellSize64))
+func (z *GravityZone) contains(p Pos) bool {
+	return p.x >= z.min.x && p.x <= z.max.x && p.y >= z.min.y && p.y <= z.max.y
 }
 
-func hashKey(ix, iy int) int64 {
-	return (int64(uint32(ix)) << 32) | int64(uint32(iy))
+// GravityWell is a point attractor. Particles within wellRange are pulled
+// toward it with an acceleration of strength/distance per tick.
+type GravityWell struct {
+	pos      Pos
+	strength float32
 }
 
-var neighborOffsets = [...]struct{ dx, dy int }{
-	{0, 0}, {1, 0}, {-1, 0}, {0, 1}, {0, -1},
-	{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
-}
+const (
+	wellRange       = float32(400) // Particles farther away feel nothing
+	wellMinDistance = float32(20)  // Closer particles are pulled as if this far, so the pull stays finite
+	wellPickRadius  = float32(15)
+)
 
-func (b *Ball) speed() float32 {
-	return float32(math.Sqrt(float64(b.velocity.vx*b.velocity.vx + b.velocity.vy*b.velocity.vy)))
+// applyWells accelerates dynamic particles toward every gravity well in range.
+func (s *Simulation) applyWells(ticks float32) {
+	for _, w := range s.wells {
+		for _, i := range s.QueryRadius(w.pos, wellRange) {
+			b := &s.balls[i]
+			if b.material == MaterialStatic || b.frozen {
+				continue
+			}
+			nx, ny, dist := normalize(w.pos.x-b.pos.x, w.pos.y-b.pos.y)
+			pull := w.strength / max(dist, wellMinDistance) * ticks
+			b.velocity.vx += nx * pull
+			b.velocity.vy += ny * pull
+			s.probe(&s.forces.gravity, i, nx*pull, ny*pull)
+		}
+	}
+	// The query index now holds positions from before this step's motion.
+	s.queryDirty = true
+}
+
+// paintStatic turns the dynamic particles under the brush at (x, y) into
+// statics, or statics back into solid circles when toStatic is false. The
+// brush reaches as far as Ctrl-click erasing. Like phaseTransition it
+// replaces particles in place, and the fluid passes pick up the new
+// materials when they rebuild their index lists next step. Bucket walls are
+// never converted.
+func (g *Game) paintStatic(x, y float32, toStatic bool) {
+	for i := range g.balls {
+		b := &g.balls[i]
+		dx, dy := b.pos.x-x, b.pos.y-y
+		reach := b.radius + 15
+		if b.body != 0 || dx*dx+dy*dy >= reach*reach {
+			continue
+		}
+		var to ShapeType
+		switch {
+		case toStatic && b.material != MaterialStatic:
+			to = ShapeStatic
+		case !toStatic && b.material == MaterialStatic:
+			to = ShapeCircle
+		default:
+			continue
+		}
+		changed := createParticle(to, b.pos, g.settings.spawnRadius(to, float64(b.radius)))
+		changed.temperature = b.temperature
+		changed.layer = b.layer
+		changed.seq = b.seq
+		*b = changed
+	}
+}
+
+// updateFreezeTool handles F: particles within moveAttractDistance of the
+// cursor are frozen in place with their velocity cleared, or, if all of them
+// are frozen already, released. Statics and bucket walls are left alone.
+func (g *Game) updateFreezeTool() {
+	freezePressed := keyPressed(ebiten.KeyF)
+	if freezePressed && !g.prevFreezePressed {
+		x, y := cursorPosition()
+		reachSq := float32(moveAttractDistance * moveAttractDistance)
+		var inRange []int
+		freeze := false
+		for i := range g.balls {
+			b := &g.balls[i]
+			dx, dy := b.pos.x-float32(x), b.pos.y-float32(y)
+			if b.material == MaterialStatic || b.body != 0 || dx*dx+dy*dy >= reachSq {
+				continue
+			}
+			inRange = append(inRange, i)
+			freeze = freeze || !b.frozen
+		}
+		for _, i := range inRange {
+			g.balls[i].frozen = freeze
+			g.balls[i].velocity = Velocity{}
+		}
+		if len(inRange) > 0 {
+			verb := "Released"
+			if freeze {
+				verb = "Froze"
+			}
+			g.updateMessage = fmt.Sprintf("%s %d particles", verb, len(inRange))
+		}
+	}
+	g.prevFreezePressed = freezePressed
+}
+
+// updateWellTool places a gravity well at the cursor with the strength from
+// the menu when G is pressed. Shift+G removes the well under the cursor.
+func (g *Game) updateWellTool() {
+	wellPressed := keyPressed(ebiten.KeyG)
+	if wellPressed && !g.prevWellPressed {
+		x, y := cursorPosition()
+		cursor := createPos(float32(x), float32(y))
+		if keyPressed(ebiten.KeyShift) {
+			for i := len(g.wells) - 1; i >= 0; i-- {
+				dx, dy := g.wells[i].pos.x-cursor.x, g.wells[i].pos.y-cursor.y
+				if dx*dx+dy*dy < wellPickRadius*wellPickRadius {
+					g.wells = append(g.wells[:i], g.wells[i+1:]...)
+					break
+				}
+			}
+		} else {
+			g.wells = append(g.wells, GravityWell{pos: cursor, strength: g.settings.wellStrength})
+		}
+	}
+	g.prevWellPressed = wellPressed
+}
+
+func (g *Game) drawWells(screen *ebiten.Image) {
+	col := color.RGBA{R: 200, G: 120, B: 255, A: 230}
+	for _, w := range g.wells {
+		vector.StrokeCircle(screen, w.pos.x, w.pos.y, 8, 2, col, false)
+		vector.StrokeLine(screen, w.pos.x-4, w.pos.y, w.pos.x+4, w.pos.y, 1, col, false)
+		vector.StrokeLine(screen, w.pos.x, w.pos.y-4, w.pos.x, w.pos.y+4, 1, col, false)
+	}
+}
+
+// gravityAt returns the gravity acting at p. Outside every zone this is the
+// global gravity; inside, the last matching zone wins unless zones are additive.
+func (s *Simulation) gravityAt(p Pos) (float32, float32) {
+	gx, gy := s.settings.gravityX, s.settings.gravityY
+	for i := range s.zones {
+		if !s.zones[i].contains(p) {
+			continue
+		}
+		if s.settings.zoneAdditive {
+			gx += s.zones[i].gx
+			gy += s.zones[i].gy
+		} else {
+			gx, gy = s.zones[i].gx, s.zones[i].gy
+		}
+	}
+	return gx, gy
+}
+
+// updateZoneTool handles Z+drag to create a zone and Shift+Z+click to remove one.
+// It reports whether the zone tool owns the left mouse button this frame.
+func (g *Game) updateZoneTool() bool {
+	zoneKey := keyPressed(ebiten.KeyZ)
+	leftPressed := mousePressed(ebiten.MouseButtonLeft)
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+
+	if g.zoneDragging {
+		if leftPressed {
+			return true
+		}
+		g.zoneDragging = false
+		zone := GravityZone{
+			min: Pos{x: float32(math.Min(float64(g.zoneStart.x), float64(cursor.x))), y: float32(math.Min(float64(g.zoneStart.y), float64(cursor.y)))},
+			max: Pos{x: float32(math.Max(float64(g.zoneStart.x), float64(cursor.x))), y: float32(math.Max(float64(g.zoneStart.y), float64(cursor.y)))},
+			gx:  g.settings.zoneGravityX,
+			gy:  g.settings.zoneGravityY,
+		}
+		if zone.max.x-zone.min.x > 5 && zone.max.y-zone.min.y > 5 {
+			g.zones = append(g.zones, zone)
+		}
+		return true
+	}
+	if !zoneKey || !leftPressed {
+		return zoneKey
+	}
+	if keyPressed(ebiten.KeyShift) {
+		for i := len(g.zones) - 1; i >= 0; i-- {
+			if g.zones[i].contains(cursor) {
+				g.zones = append(g.zones[:i], g.zones[i+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	g.zoneDragging = true
+	g.zoneStart = cursor
+	return true
+}
+
+func (g *Game) drawZones(screen *ebiten.Image) {
+	for i := range g.zones {
+		z := &g.zones[i]
+		w := z.max.x - z.min.x
+		h := z.max.y - z.min.y
+		// Upward gravity tints green, downward red, sideways blue.
+		tint := color.RGBA{60, 60, 200, 40}
+		if z.gy < 0 && -z.gy >= float32(math.Abs(float64(z.gx))) {
+			tint = color.RGBA{60, 200, 60, 40}
+		} else if z.gy > 0 && z.gy >= float32(math.Abs(float64(z.gx))) {
+			tint = color.RGBA{200, 60, 60, 40}
+		}
+		vector.DrawFilledRect(screen, z.min.x, z.min.y, w, h, tint, false)
+		vector.StrokeRect(screen, z.min.x, z.min.y, w, h, 1, color.RGBA{tint.R, tint.G, tint.B, 160}, false)
+		if z.gx != 0 || z.gy != 0 {
+			nx, ny, _ := normalize(z.gx, z.gy)
+			length := float32(math.Min(float64(w), float64(h))) * 0.3
+			drawArrow(screen, z.min.x+w/2, z.min.y+h/2, nx*length, ny*length, color.RGBA{tint.R, tint.G, tint.B, 200})
+		}
+	}
+	if g.zoneDragging {
+		x, y := cursorPosition()
+		vector.StrokeRect(screen, g.zoneStart.x, g.zoneStart.y, float32(x)-g.zoneStart.x, float32(y)-g.zoneStart.y, 1, color.RGBA{200, 200, 200, 200}, false)
+	}
+}
+
+// Cannon fires a burst of particles along its aim every interval seconds.
+// Shape, size and firing parameters are captured when it is placed.
+type Cannon struct {
+	pos        Pos
+	dirX, dirY float32 // unit aim direction
+	shape      ShapeType
+	radius     float32
+	interval   float32
+	burst      int
+	speed      float32
+	timer      float32
+}
+
+const (
+	maxCannonBurst  = 50
+	cannonMarkerLen = float32(24)
+)
+
+func clampCannonInterval(s float32) float32 {
+	return float32(math.Min(math.Max(float64(s), 0.1), 10))
+}
+
+func clampCannonBurst(n int) int {
+	return max(1, min(maxCannonBurst, n))
+}
+
+// updateCannons advances every cannon's timer and fires the ones that are due.
+func (g *Game) updateCannons() {
+	dt := g.stepDT()
+	for i := range g.cannons {
+		c := &g.cannons[i]
+		c.timer -= dt
+		if c.timer > 0 {
+			continue
+		}
+		c.timer += c.interval
+		g.fireCannon(c)
+	}
+}
+
+// fireCannon launches one burst as a short column across the muzzle, with a
+// little jitter so the particles do not stack into a single line.
+func (g *Game) fireCannon(c *Cannon) {
+	count := g.makeRoom(c.burst)
+	muzzleX := c.pos.x + c.dirX*(cannonMarkerLen+c.radius)
+	muzzleY := c.pos.y + c.dirY*(cannonMarkerLen+c.radius)
+	perpX, perpY := -c.dirY, c.dirX
+	for n := 0; n < count; n++ {
+		across := (float32(n%3) - 1) * c.radius * 2
+		along := float32(n/3) * c.radius * 2
+		pos := createPos(muzzleX+perpX*across+c.dirX*along, muzzleY+perpY*across+c.dirY*along)
+		b := createParticle(c.shape, pos, c.radius)
+		if b.material == MaterialStatic {
+			g.addParticle(b)
+			continue
+		}
+		jitter := float32(g.rng.Float64()-0.5) * c.speed * 0.1
+		b.velocity = Velocity{vx: c.dirX*c.speed + perpX*jitter, vy: c.dirY*c.speed + perpY*jitter}
+		g.addParticle(b)
+	}
+}
+
+// updateCannonTool handles C+drag to place a cannon aimed along the drag and
+// Shift+C+click to remove one. It reports whether the tool owns the left
+// mouse button this frame.
+func (g *Game) updateCannonTool() bool {
+	cannonKey := keyPressed(ebiten.KeyC)
+	leftPressed := mousePressed(ebiten.MouseButtonLeft)
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+
+	if g.cannonDragging {
+		if leftPressed {
+			return true
+		}
+		g.cannonDragging = false
+		dirX, dirY, length := normalize(cursor.x-g.cannonStart.x, cursor.y-g.cannonStart.y)
+		if length < 5 {
+			dirX, dirY = 1, 0
+		}
+		g.cannons = append(g.cannons, Cannon{
+			pos:      g.cannonStart,
+			dirX:     dirX,
+			dirY:     dirY,
+			shape:    currentShape,
+			radius:   g.settings.spawnRadius(currentShape, ballsize),
+			interval: g.settings.cannonInterval,
+			burst:    g.settings.cannonBurst,
+			speed:    g.settings.cannonSpeed,
+		})
+		return true
+	}
+	if !cannonKey || !leftPressed {
+		return cannonKey
+	}
+	if keyPressed(ebiten.KeyShift) {
+		for i := len(g.cannons) - 1; i >= 0; i-- {
+			dx := g.cannons[i].pos.x - cursor.x
+			dy := g.cannons[i].pos.y - cursor.y
+			if dx*dx+dy*dy < cannonMarkerLen*cannonMarkerLen {
+				g.cannons = append(g.cannons[:i], g.cannons[i+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	g.cannonDragging = true
+	g.cannonStart = cursor
+	return true
+}
+
+func (g *Game) drawCannons(screen *ebiten.Image) {
+	col := color.RGBA{230, 160, 40, 255}
+	for i := range g.cannons {
+		c := &g.cannons[i]
+		vector.DrawFilledCircle(screen, c.pos.x, c.pos.y, 6, col, false)
+		drawArrow(screen, c.pos.x, c.pos.y, c.dirX*cannonMarkerLen, c.dirY*cannonMarkerLen, col)
+	}
+	if g.cannonDragging {
+		x, y := cursorPosition()
+		vector.StrokeLine(screen, g.cannonStart.x, g.cannonStart.y, float32(x), float32(y), 1, color.RGBA{200, 200, 200, 200}, false)
+	}
+}
+
+// makeRoom returns how many of count new particles fit under the max-particle
+// cap. With evictOldest set it removes the oldest dynamic particles to make
+// space instead; statics and bucket walls are never evicted. Age is the spawn
+// order in seq, since swap-removes leave index order shuffled.
+func (g *Game) makeRoom(count int) int {
+	limit := g.settings.maxParticles
+	if limit <= 0 || len(g.balls)+count <= limit {
+		return count
+	}
+	if !g.settings.evictOldest {
+		return max(0, limit-len(g.balls))
+	}
+	need := len(g.balls) + count - limit
+	var evict []int
+	for i := range g.balls {
+		if g.balls[i].material != MaterialStatic && g.balls[i].body == 0 {
+			evict = append(evict, i)
+		}
+	}
+	if len(evict) > need {
+		sort.Slice(evict, func(a, b int) bool { return g.balls[evict[a]].seq < g.balls[evict[b]].seq })
+		evict = evict[:need]
+		sort.Ints(evict)
+	}
+	for n := len(evict) - 1; n >= 0; n-- {
+		g.removeParticleOrdered(evict[n])
+	}
+	return max(0, min(count, limit-len(g.balls)))
+}
+
+// Emitter spawns a steady stream of particles at one point, like a faucet or a
+// smoke vent. Shape, size, rate and velocity are captured when it is placed.
+type Emitter struct {
+	pos      Pos
+	shape    ShapeType
+	radius   float32
+	rate     float32 // particles per second
+	velocity Velocity
+	pending  float32 // fractional particles carried over to the next frame
+}
+
+const (
+	maxEmitterRate      = 200
+	maxEmitterSpeed     = 20
+	emitterMarkerRadius = float32(8)
+)
+
+func clampEmitterRate(r float32) float32 {
+	return float32(math.Min(math.Max(float64(r), 1), maxEmitterRate))
+}
+
+func clampEmitterSpeed(v float32) float32 {
+	return float32(math.Min(math.Max(float64(v), 0), maxEmitterSpeed))
+}
+
+// updateEmitters spawns each emitter's share of particles for this frame. They
+// stop adding once the max-particle cap is reached and resume when there is room.
+func (g *Game) updateEmitters() {
+	dt := g.stepDT()
+	for i := range g.emitters {
+		e := &g.emitters[i]
+		e.pending += e.rate * dt
+		count := int(e.pending)
+		e.pending -= float32(count)
+		count = g.makeRoom(count)
+		for n := 0; n < count; n++ {
+			// Spread the spawn point a little so a fast stream doesn't stack up.
+			jitterX := float32(g.rng.Float64()-0.5) * e.radius
+			jitterY := float32(g.rng.Float64()-0.5) * e.radius
+			pos := createPos(e.pos.x+jitterX, e.pos.y+jitterY)
+			b := createParticle(e.shape, pos, e.radius)
+			if b.material != MaterialStatic {
+				b.velocity = e.velocity
+			}
+			g.addParticle(b)
+		}
+	}
+}
+
+// updateEmitterTool drops an emitter at the cursor when E is pressed, or
+// removes the one under the cursor with Shift+E. Water and solids are aimed
+// along gravity and gas against it. E is left to the bucket while one is held.
+func (g *Game) updateEmitterTool() {
+	emitterKey := keyPressed(ebiten.KeyE) && g.grabbedBucket < 0
+	pressed := emitterKey && !g.prevEmitterKey
+	g.prevEmitterKey = emitterKey
+	if !pressed {
+		return
+	}
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+
+	if keyPressed(ebiten.KeyShift) {
+		for i := len(g.emitters) - 1; i >= 0; i-- {
+			dx := g.emitters[i].pos.x - cursor.x
+			dy := g.emitters[i].pos.y - cursor.y
+			if dx*dx+dy*dy < emitterMarkerRadius*emitterMarkerRadius*4 {
+				g.emitters = append(g.emitters[:i], g.emitters[i+1:]...)
+				break
+			}
+		}
+		return
+	}
+
+	upX, upY := g.settings.upDirection()
+	if currentShape != ShapeGas {
+		upX, upY = -upX, -upY
+	}
+	g.emitters = append(g.emitters, Emitter{
+		pos:      cursor,
+		shape:    currentShape,
+		radius:   g.settings.spawnRadius(currentShape, ballsize),
+		rate:     g.settings.emitterRate,
+		velocity: Velocity{vx: upX * g.settings.emitterSpeed, vy: upY * g.settings.emitterSpeed},
+	})
+}
+
+func (g *Game) drawEmitters(screen *ebiten.Image) {
+	col := color.RGBA{80, 200, 220, 255}
+	for i := range g.emitters {
+		e := &g.emitters[i]
+		vector.StrokeCircle(screen, e.pos.x, e.pos.y, emitterMarkerRadius, 2, col, false)
+		if e.velocity.vx != 0 || e.velocity.vy != 0 {
+			nx, ny, _ := normalize(e.velocity.vx, e.velocity.vy)
+			drawArrow(screen, e.pos.x, e.pos.y, nx*emitterMarkerRadius*2, ny*emitterMarkerRadius*2, col)
+		}
+	}
+}
+
+// Polygon is a convex static obstacle. Points are stored in hull order with
+// the outward unit normal of the edge that starts at each point.
+type Polygon struct {
+	points   []Pos
+	normals  []Pos
+	min, max Pos
+}
+
+// newPolygon builds an obstacle from the convex hull of points. It reports
+// false when the hull is degenerate.
+func newPolygon(points []Pos) (Polygon, bool) {
+	hull := convexHull(points)
+	if len(hull) < 3 {
+		return Polygon{}, false
+	}
+	p := Polygon{points: hull, normals: make([]Pos, len(hull)), min: hull[0], max: hull[0]}
+	var cx, cy float32
+	for _, pt := range hull {
+		cx += pt.x
+		cy += pt.y
+		p.min.x = float32(math.Min(float64(p.min.x), float64(pt.x)))
+		p.min.y = float32(math.Min(float64(p.min.y), float64(pt.y)))
+		p.max.x = float32(math.Max(float64(p.max.x), float64(pt.x)))
+		p.max.y = float32(math.Max(float64(p.max.y), float64(pt.y)))
+	}
+	cx /= float32(len(hull))
+	cy /= float32(len(hull))
+	for i, a := range hull {
+		b := hull[(i+1)%len(hull)]
+		nx, ny, _ := normalize(b.y-a.y, a.x-b.x)
+		if (a.x-cx)*nx+(a.y-cy)*ny < 0 {
+			nx, ny = -nx, -ny
+		}
+		p.normals[i] = Pos{x: nx, y: ny}
+	}
+	return p, true
+}
+
+// convexHull returns the hull of points using the monotone chain algorithm.
+func convexHull(points []Pos) []Pos {
+	pts := append([]Pos(nil), points...)
+	sort.Slice(pts, func(i, j int) bool {
+		if pts[i].x != pts[j].x {
+			return pts[i].x < pts[j].x
+		}
+		return pts[i].y < pts[j].y
+	})
+	if len(pts) < 3 {
+		return pts
+	}
+	cross := func(o, a, b Pos) float32 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	hull := make([]Pos, 0, 2*len(pts))
+	for _, pt := range pts {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], pt) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, pt)
+	}
+	lower := len(hull) + 1
+	for i := len(pts) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], pts[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, pts[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+// surface returns the outward normal at the boundary point nearest to pt and
+// the signed distance to it, negative when pt is inside the polygon.
+func (p *Polygon) surface(pt Pos) (nx, ny, dist float32) {
+	best := float32(-math.MaxFloat32)
+	bestEdge := 0
+	for i, a := range p.points {
+		d := (pt.x-a.x)*p.normals[i].x + (pt.y-a.y)*p.normals[i].y
+		if d > best {
+			best, bestEdge = d, i
+		}
+	}
+	if best <= 0 {
+		return p.normals[bestEdge].x, p.normals[bestEdge].y, best
+	}
+	minDistSq := float32(math.MaxFloat32)
+	var cx, cy float32
+	for i, a := range p.points {
+		b := p.points[(i+1)%len(p.points)]
+		ex, ey := b.x-a.x, b.y-a.y
+		t := ((pt.x-a.x)*ex + (pt.y-a.y)*ey) / (ex*ex + ey*ey)
+		t = float32(math.Min(1, math.Max(0, float64(t))))
+		qx, qy := a.x+ex*t, a.y+ey*t
+		if d := (pt.x-qx)*(pt.x-qx) + (pt.y-qy)*(pt.y-qy); d < minDistSq {
+			minDistSq, cx, cy = d, qx, qy
+		}
+	}
+	return normalize(pt.x-cx, pt.y-cy)
+}
+
+func (p *Polygon) near(pt Pos, margin float32) bool {
+	return pt.x >= p.min.x-margin && pt.x <= p.max.x+margin && pt.y >= p.min.y-margin && pt.y <= p.max.y+margin
+}
+
+// Wall is a static line segment obstacle with rounded ends, thickness wide.
+type Wall struct {
+	a, b      Pos
+	thickness float32
+}
+
+// newWall builds a wall between two points. It reports false when the points
+// are too close together to make a segment.
+func newWall(a, b Pos, thickness float32) (Wall, bool) {
+	if dx, dy := b.x-a.x, b.y-a.y; dx*dx+dy*dy < 1 {
+		return Wall{}, false
+	}
+	return Wall{a: a, b: b, thickness: thickness}, true
+}
+
+// surface returns the outward normal at the wall point nearest to pt and the
+// signed distance to the wall's surface, negative when pt is inside it.
+func (w *Wall) surface(pt Pos) (nx, ny, dist float32) {
+	ex, ey := w.b.x-w.a.x, w.b.y-w.a.y
+	t := ((pt.x-w.a.x)*ex + (pt.y-w.a.y)*ey) / (ex*ex + ey*ey)
+	t = min(1, max(0, t))
+	nx, ny, dist = normalize(pt.x-(w.a.x+ex*t), pt.y-(w.a.y+ey*t))
+	if nx == 0 && ny == 0 {
+		// pt lies on the center line; push out to one side.
+		nx, ny, _ = normalize(-ey, ex)
+		dist = 0
+	}
+	return nx, ny, dist - w.thickness/2
+}
+
+func (w *Wall) near(pt Pos, margin float32) bool {
+	margin += w.thickness / 2
+	return pt.x >= min(w.a.x, w.b.x)-margin && pt.x <= max(w.a.x, w.b.x)+margin &&
+		pt.y >= min(w.a.y, w.b.y)-margin && pt.y <= max(w.a.y, w.b.y)+margin
+}
+
+// obstacle is a static shape particles collide with: a polygon or a wall.
+type obstacle interface {
+	surface(pt Pos) (nx, ny, dist float32)
+	near(pt Pos, margin float32) bool
+}
+
+// obstacles lists the polygons and walls.
+func (s *Simulation) obstacles() []obstacle {
+	list := make([]obstacle, 0, len(s.polygons)+len(s.walls))
+	for i := range s.polygons {
+		list = append(list, &s.polygons[i])
+	}
+	for i := range s.walls {
+		list = append(list, &s.walls[i])
+	}
+	return list
+}
+
+// resolveObstacleContacts pushes dynamic particles out of polygons and walls
+// and reflects the normal part of their velocity. Fluids bounce far less.
+func (s *Simulation) resolveObstacleContacts() {
+	for _, o := range s.obstacles() {
+		for i := range s.balls {
+			b := &s.balls[i]
+			if b.material == MaterialStatic || b.frozen || !o.near(b.pos, b.radius) {
+				continue
+			}
+			nx, ny, dist := o.surface(b.pos)
+			if dist >= b.radius {
+				continue
+			}
+			penetration := b.radius - dist
+			b.pos.x += nx * penetration
+			b.pos.y += ny * penetration
+			vn := b.velocity.vx*nx + b.velocity.vy*ny
+			if vn >= 0 {
+				continue
+			}
+			restitution := s.settings.groundRestitution
+			if isFluid(b.material) {
+				restitution *= 0.25
+			}
+			tx, ty := b.velocity.vx-vn*nx, b.velocity.vy-vn*ny
+			b.velocity.vx = tx*s.settings.groundFriction - restitution*vn*nx
+			b.velocity.vy = ty*s.settings.groundFriction - restitution*vn*ny
+		}
+	}
+}
+
+// pushFluidFromObstacles applies the same soft boundary push the fluid passes
+// use around solid particles, so fluids flow along polygon faces and walls.
+func (s *Simulation) pushFluidFromObstacles(indices []int, restDistance, strength float32) {
+	for _, o := range s.obstacles() {
+		for _, idx := range indices {
+			b := &s.balls[idx]
+			reach := b.radius + restDistance
+			if !o.near(b.pos, reach) {
+				continue
+			}
+			nx, ny, dist := o.surface(b.pos)
+			if dist >= reach {
+				continue
+			}
+			push := (reach - dist) * strength
+			b.velocity.vx += nx * push
+			b.velocity.vy += ny * push
+			s.probe(&s.forces.boundary, idx, nx*push, ny*push)
+		}
+	}
+}
+
+// updatePolygonTool handles P to start, finish or cancel a polygon and left
+// clicks to add vertices. Shift+P removes the polygon under the cursor. It
+// reports whether the tool owns the left mouse button this frame.
+func (g *Game) updatePolygonTool() bool {
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+	polyPressed := keyPressed(ebiten.KeyP)
+	if polyPressed && !g.prevPolyPressed {
+		switch {
+		case keyPressed(ebiten.KeyShift):
+			for i := len(g.polygons) - 1; i >= 0; i-- {
+				if _, _, d := g.polygons[i].surface(cursor); d < 0 {
+					g.polygons = append(g.polygons[:i], g.polygons[i+1:]...)
+					break
+				}
+			}
+		case !g.polyDrawing:
+			g.polyDrawing = true
+			g.polyPoints = g.polyPoints[:0]
+		default:
+			g.polyDrawing = false
+			if poly, ok := newPolygon(g.polyPoints); ok {
+				g.polygons = append(g.polygons, poly)
+			}
+		}
+	}
+	g.prevPolyPressed = polyPressed
+
+	click := mousePressed(ebiten.MouseButtonLeft)
+	if g.polyDrawing && click && !g.prevPolyClick {
+		g.polyPoints = append(g.polyPoints, cursor)
+	}
+	g.prevPolyClick = click
+	return g.polyDrawing
+}
+
+// updateBlockTool drags out solid rectangular blocks while B is held, like
+// the zone tool. A block is stored as a four-sided polygon, so it collides,
+// saves and is removed like one. Shift+B and click removes the polygon under
+// the cursor. It reports whether the tool owns the left mouse button.
+func (g *Game) updateBlockTool() bool {
+	blockKey := keyPressed(ebiten.KeyB)
+	leftPressed := mousePressed(ebiten.MouseButtonLeft)
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+
+	if g.blockDragging {
+		if leftPressed {
+			return true
+		}
+		g.blockDragging = false
+		a, b := g.blockStart, cursor
+		if math.Abs(float64(b.x-a.x)) > 5 && math.Abs(float64(b.y-a.y)) > 5 {
+			corners := []Pos{a, {x: b.x, y: a.y}, b, {x: a.x, y: b.y}}
+			if poly, ok := newPolygon(corners); ok {
+				g.polygons = append(g.polygons, poly)
+			}
+		}
+		return true
+	}
+	if !blockKey || !leftPressed {
+		return blockKey
+	}
+	if keyPressed(ebiten.KeyShift) {
+		for i := len(g.polygons) - 1; i >= 0; i-- {
+			if _, _, d := g.polygons[i].surface(cursor); d < 0 {
+				g.polygons = append(g.polygons[:i], g.polygons[i+1:]...)
+				break
+			}
+		}
+		return true
+	}
+	g.blockDragging = true
+	g.blockStart = cursor
+	return true
+}
+
+// applyVortex pushes particles within moveAttractDistance of center around
+// it, clockwise on screen or counter-clockwise when reverse is set. The push
+// is perpendicular to the line from the center, so particles circle instead
+// of being drawn in or thrown out.
+func (g *Game) applyVortex(center Pos, reverse bool) {
+	strength := g.settings.vortexStrength
+	if reverse {
+		strength = -strength
+	}
+	reachSq := float32(moveAttractDistance * moveAttractDistance)
+	for i := range g.balls {
+		dx := g.balls[i].pos.x - center.x
+		dy := g.balls[i].pos.y - center.y
+		if dx*dx+dy*dy >= reachSq {
+			continue
+		}
+		nx, ny, _ := normalize(dx, dy)
+		tx, ty := -ny*strength, nx*strength
+		g.balls[i].velocity.vx += tx
+		g.balls[i].velocity.vy += ty
+		g.probe(&g.forces.tool, i, tx, ty)
+	}
+}
+
+// wallPickMargin is how close to a wall Shift+L has to be to remove it.
+const wallPickMargin = 6
+
+// updateWallTool lays walls while L is held: press the left button at one end
+// and release it at the other. The wall is as thick as the current particle
+// radius. Shift+L removes the wall under the cursor. It reports whether the
+// tool owns the left mouse button this frame.
+func (g *Game) updateWallTool() bool {
+	x, y := cursorPosition()
+	cursor := createPos(float32(x), float32(y))
+	wallPressed := keyPressed(ebiten.KeyL)
+	if wallPressed && !g.prevWallPressed && keyPressed(ebiten.KeyShift) {
+		for i := len(g.walls) - 1; i >= 0; i-- {
+			if _, _, d := g.walls[i].surface(cursor); d < wallPickMargin {
+				g.walls = append(g.walls[:i], g.walls[i+1:]...)
+				break
+			}
+		}
+	}
+	g.prevWallPressed = wallPressed
+
+	click := mousePressed(ebiten.MouseButtonLeft)
+	switch {
+	case wallPressed && click && !g.prevWallClick:
+		g.wallDragging = true
+		g.wallStart = cursor
+	case g.wallDragging && !click:
+		g.wallDragging = false
+		if wall, ok := newWall(g.wallStart, cursor, float32(ballsize)); ok && wallPressed {
+			g.walls = append(g.walls, wall)
+		}
+	}
+	g.prevWallClick = click
+	return wallPressed || g.wallDragging
+}
+
+func (g *Game) drawWalls(screen *ebiten.Image) {
+	fill := color.RGBA{R: 180, G: 180, B: 195, A: 240}
+	for _, w := range g.walls {
+		vector.StrokeLine(screen, w.a.x, w.a.y, w.b.x, w.b.y, w.thickness, fill, g.settings.antialias)
+		vector.DrawFilledCircle(screen, w.a.x, w.a.y, w.thickness/2, fill, g.settings.antialias)
+		vector.DrawFilledCircle(screen, w.b.x, w.b.y, w.thickness/2, fill, g.settings.antialias)
+	}
+	if g.wallDragging {
+		x, y := cursorPosition()
+		vector.StrokeLine(screen, g.wallStart.x, g.wallStart.y, float32(x), float32(y), float32(ballsize), color.RGBA{255, 220, 120, 160}, false)
+	}
+}
+
+func (g *Game) drawPolygons(screen *ebiten.Image) {
+	fill := color.RGBA{R: 180, G: 180, B: 195, A: 240}
+	for pi := range g.polygons {
+		poly := &g.polygons[pi]
+		path := vector.Path{}
+		path.MoveTo(poly.points[0].x, poly.points[0].y)
+		for _, pt := range poly.points[1:] {
+			path.LineTo(pt.x, pt.y)
+		}
+		path.Close()
+		vertices, indices := path.AppendVerticesAndIndicesForFilling(nil, nil)
+		for i := range vertices {
+			vertices[i].ColorR = float32(fill.R) / 255
+			vertices[i].ColorG = float32(fill.G) / 255
+			vertices[i].ColorB = float32(fill.B) / 255
+			vertices[i].ColorA = float32(fill.A) / 255
+		}
+		screen.DrawTriangles(vertices, indices, emptyImage, &ebiten.DrawTrianglesOptions{})
+	}
+	if g.blockDragging {
+		x, y := cursorPosition()
+		minX, minY := min(g.blockStart.x, float32(x)), min(g.blockStart.y, float32(y))
+		w, h := float32(math.Abs(float64(float32(x)-g.blockStart.x))), float32(math.Abs(float64(float32(y)-g.blockStart.y)))
+		vector.DrawFilledRect(screen, minX, minY, w, h, color.RGBA{R: 90, G: 90, B: 98, A: 120}, false)
+		vector.StrokeRect(screen, minX, minY, w, h, 1, color.RGBA{255, 220, 120, 255}, false)
+	}
+	if !g.polyDrawing {
+		return
+	}
+	edge := color.RGBA{255, 220, 120, 255}
+	for i, pt := range g.polyPoints {
+		vector.DrawFilledCircle(screen, pt.x, pt.y, 3, edge, false)
+		if i > 0 {
+			prev := g.polyPoints[i-1]
+			vector.StrokeLine(screen, prev.x, prev.y, pt.x, pt.y, 1, edge, false)
+		}
+	}
+	if n := len(g.polyPoints); n > 0 {
+		x, y := cursorPosition()
+		vector.StrokeLine(screen, g.polyPoints[n-1].x, g.polyPoints[n-1].y, float32(x), float32(y), 1, edge, false)
+	}
+	ebitenutil.DebugPrintAt(screen, "Polygon: click to add vertices, P to finish", 10, 60)
+}
+
+type scenePointDTO struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+type sceneWellDTO struct {
+	X        float32 `json:"x"`
+	Y        float32 `json:"y"`
+	Strength float32 `json:"strength"`
+}
+
+type sceneZoneDTO struct {
+	MinX float32 `json:"min_x"`
+	MinY float32 `json:"min_y"`
+	MaxX float32 `json:"max_x"`
+	MaxY float32 `json:"max_y"`
+	GX   float32 `json:"gx"`
+	GY   float32 `json:"gy"`
+}
+
+type sceneWallDTO struct {
+	X1        float32 `json:"x1"`
+	Y1        float32 `json:"y1"`
+	X2        float32 `json:"x2"`
+	Y2        float32 `json:"y2"`
+	Thickness float32 `json:"thickness"`
+}
+
+type sceneCannonDTO struct {
+	X        float32   `json:"x"`
+	Y        float32   `json:"y"`
+	DirX     float32   `json:"dir_x"`
+	DirY     float32   `json:"dir_y"`
+	Shape    ShapeType `json:"shape"`
+	Radius   float32   `json:"radius"`
+	Interval float32   `json:"interval"`
+	Burst    int       `json:"burst"`
+	Speed    float32   `json:"speed"`
+}
+
+type sceneEmitterDTO struct {
+	X      float32   `json:"x"`
+	Y      float32   `json:"y"`
+	Shape  ShapeType `json:"shape"`
+	Radius float32   `json:"radius"`
+	Rate   float32   `json:"rate"`
+	VX     float32   `json:"vx"`
+	VY     float32   `json:"vy"`
+}
+
+type sceneRampDTO struct {
+	Cold [3]uint8 `json:"cold"`
+	Hot  [3]uint8 `json:"hot"`
+}
+
+type sceneBucketDTO struct {
+	X     float32 `json:"x"`
+	Y     float32 `json:"y"`
+	Angle float32 `json:"angle"`
+}
+
+type sceneBallDTO struct {
+	X         float32      `json:"x"`
+	Y         float32      `json:"y"`
+	VX        float32      `json:"vx"`
+	VY        float32      `json:"vy"`
+	Radius    float32      `json:"radius"`
+	Shape     ShapeType    `json:"shape"`
+	Material  MaterialType `json:"material"`
+	Permeable bool         `json:"permeable,omitempty"`
+	Body      int          `json:"body,omitempty"`
+	LocalX    float32      `json:"local_x,omitempty"`
+	LocalY    float32      `json:"local_y,omitempty"`
+	Mass      *float32     `json:"mass,omitempty"`
+	Temp      *float32     `json:"temperature,omitempty"`
+	Frozen    bool         `json:"frozen,omitempty"`
+	Age       int          `json:"age,omitempty"`
+	Layer     uint8        `json:"layer,omitempty"`
+}
+
+type sceneDTO struct {
+	SceneVersion        int               `json:"scene_version"`
+	AppVersion          string            `json:"app_version"`
+	Settings            sceneSettingsDTO  `json:"settings"`
+	Balls               []sceneBallDTO    `json:"balls"`
+	BallSize            float64           `json:"ball_size"`
+	MoveAttractDistance float64           `json:"move_attract_distance"`
+	SpawnClusterCount   int               `json:"spawn_cluster_count"`
+	CurrentShape        ShapeType         `json:"current_shape"`
+	SpawnScatter        bool              `json:"spawn_scatter,omitempty"`
+	ScatterRadius       float32           `json:"scatter_radius,omitempty"`
+	Buckets             []sceneBucketDTO  `json:"buckets,omitempty"`
+	Zones               []sceneZoneDTO    `json:"zones,omitempty"`
+	Polygons            [][]scenePointDTO `json:"polygons,omitempty"`
+	Cannons             []sceneCannonDTO  `json:"cannons,omitempty"`
+	Emitters            []sceneEmitterDTO `json:"emitters,omitempty"`
+	Walls               []sceneWallDTO    `json:"walls,omitempty"`
+	Wells               []sceneWellDTO    `json:"wells,omitempty"`
+}
+
+func settingsToDTO(s Settings) sceneSettingsDTO {
+	return sceneSettingsDTO{
+		Gravity:              s.gravityY,
+		GravityX:             s.gravityX,
+		MaxSpeed:             s.maxSpeed,
+		MoveAwayDistance:     s.moveAwayDistance,
+		MoveAwayStrength:     s.moveAwayStrength,
+		MoveAttractStrength:  s.moveAttractStrength,
+		GroundRestitution:    s.groundRestitution,
+		CollisionRestitution: s.collisionRestitution,
+		AirDrag:              s.airDrag,
+		GroundFriction:       s.groundFriction,
+		HasTopBarrier:        s.hasTopBarrier,
+		FluidIterations:      s.fluidIterations,
+		UpdateSound:          s.updateSound,
+		Contacts:             contactsToDTO(s.contacts),
+		BubbleLift:           s.bubbleLift,
+		RenderDownscale:      s.renderDownscale,
+		FoamThreshold:        &s.foamThreshold,
+		ZoneGravityX:         &s.zoneGravityX,
+		ZoneGravityY:         &s.zoneGravityY,
+		ZoneAdditive:         s.zoneAdditive,
+		GIFFrameCount:        s.gifFrameCount,
+		GIFDownscale:         s.gifDownscale,
+		SpawnRateLimit:       &s.spawnRateLimit,
+		SpawnCooldown:        &s.spawnCooldown,
+		CollisionSolves:      s.collisionSolves,
+		RenderSkip:           s.renderSkip,
+		MaxParticles:         s.maxParticles,
+		NeighborCap:          s.neighborCap,
+		WaterRestDensity:     &s.waterRestDensity,
+		WaterPressureStiff:   &s.waterPressureStiff,
+		CannonInterval:       s.cannonInterval,
+		CannonBurst:          s.cannonBurst,
+		CannonSpeed:          s.cannonSpeed,
+		IdleHint:             &s.idleHint,
+		ColorRamp: &sceneRampDTO{
+			Cold: [3]uint8{s.colorRamp.Cold.R, s.colorRamp.Cold.G, s.colorRamp.Cold.B},
+			Hot:  [3]uint8{s.colorRamp.Hot.R, s.colorRamp.Hot.G, s.colorRamp.Hot.B},
+		},
+		SpawnMass:           s.spawnMass,
+		Integration:         int(s.integration),
+		EmitterRate:         s.emitterRate,
+		EmitterSpeed:        &s.emitterSpeed,
+		EvictOldest:         s.evictOldest,
+		SpawnTemperature:    &s.spawnTemperature,
+		BoilTemperature:     &s.boilTemperature,
+		CondenseTemperature: &s.condenseTemperature,
+		PhaseRate:           s.phaseRate,
+		GIFFPS:              s.gifFPS,
+		ScreenshotFull:      s.screenshotFull,
+		TPS:                 s.tps,
+		TimeScale:           s.timeScale,
+		Antialias:           s.antialias,
+		Background:          &[3]uint8{s.background.R, s.background.G, s.background.B},
+		WindOn:              s.windOn,
+		WindStrength:        &s.windStrength,
+		WindAngle:           s.windAngle,
+		VortexStrength:      &s.vortexStrength,
+		WellStrength:        &s.wellStrength,
+		WaterSurfaceTension: s.waterSurfaceTension,
+		SweptCollisions:     s.sweptCollisions,
+		SolidRadiusMin:      &s.solidRadiusMin,
+		SolidRadiusMax:      &s.solidRadiusMax,
+		WaterRadiusMin:      &s.waterRadiusMin,
+		WaterRadiusMax:      &s.waterRadiusMax,
+		GasRadiusMin:        &s.gasRadiusMin,
+		GasRadiusMax:        &s.gasRadiusMax,
+		SandRadiusMin:       &s.sandRadiusMin,
+		SandRadiusMax:       &s.sandRadiusMax,
+		GasLifetime:         s.gasLifetime,
+	}
+}
+
+// sceneContactDTO is one entry of the material-pair table in a scene file.
+// Pairs left out keep their default.
+type sceneContactDTO struct {
+	A           string  `json:"a"`
+	B           string  `json:"b"`
+	Restitution float32 `json:"restitution"`
+	Friction    float32 `json:"friction"`
+	Fixed       bool    `json:"fixed,omitempty"`
+	Skip        bool    `json:"skip,omitempty"`
+}
+
+// contactsToDTO lists the pairs of t that differ from the defaults.
+func contactsToDTO(t contactTable) []sceneContactDTO {
+	defaults := defaultContacts()
+	var out []sceneContactDTO
+	for a := range materialCount {
+		for b := a; b < materialCount; b++ {
+			r := t[a][b]
+			if r == defaults[a][b] {
+				continue
+			}
+			out = append(out, sceneContactDTO{
+				A:           materialNames[a],
+				B:           materialNames[b],
+				Restitution: r.restitution,
+				Friction:    r.friction,
+				Fixed:       r.fixed,
+				Skip:        r.skip,
+			})
+		}
+	}
+	return out
+}
+
+func settingsFromDTO(d sceneSettingsDTO) Settings {
+	defaults := defaultSettings()
+	waterGas := defaults.contacts[MaterialWater][MaterialGas]
+	if d.WaterGasRestitution != nil {
+		waterGas.restitution = *d.WaterGasRestitution
+	}
+	if d.WaterGasFriction != nil {
+		waterGas.friction = *d.WaterGasFriction
+	}
+	defaults.contacts.set(MaterialWater, MaterialGas, waterGas)
+	for _, c := range d.Contacts {
+		a, okA := materialFromName(c.A)
+		b, okB := materialFromName(c.B)
+		if !okA || !okB {
+			continue
+		}
+		defaults.contacts.set(a, b, contactRule{
+			restitution: float32(math.Min(1, math.Max(0, float64(c.Restitution)))),
+			friction:    float32(math.Min(1, math.Max(0, float64(c.Friction)))),
+			fixed:       c.Fixed,
+			skip:        c.Skip,
+		})
+	}
+	if d.FoamThreshold != nil {
+		defaults.foamThreshold = *d.FoamThreshold
+	}
+	if d.ZoneGravityX != nil {
+		defaults.zoneGravityX = *d.ZoneGravityX
+	}
+	if d.ZoneGravityY != nil {
+		defaults.zoneGravityY = *d.ZoneGravityY
+	}
+	if d.SpawnRateLimit != nil && *d.SpawnRateLimit >= 0 {
+		defaults.spawnRateLimit = *d.SpawnRateLimit
+	}
+	if d.SpawnCooldown != nil && *d.SpawnCooldown >= 0 {
+		defaults.spawnCooldown = *d.SpawnCooldown
+	}
+	if d.WaterRestDensity != nil && *d.WaterRestDensity > 0 {
+		defaults.waterRestDensity = *d.WaterRestDensity
+	}
+	if d.WaterPressureStiff != nil && *d.WaterPressureStiff >= 0 {
+		defaults.waterPressureStiff = *d.WaterPressureStiff
+	}
+	if d.CannonInterval > 0 {
+		defaults.cannonInterval = clampCannonInterval(d.CannonInterval)
+	}
+	if d.CannonBurst > 0 {
+		defaults.cannonBurst = clampCannonBurst(d.CannonBurst)
+	}
+	if d.CannonSpeed > 0 {
+		defaults.cannonSpeed = d.CannonSpeed
+	}
+	if d.IdleHint != nil {
+		defaults.idleHint = *d.IdleHint
+	}
+	if d.SpawnMass > 0 {
+		defaults.spawnMass = clampSpawnMass(d.SpawnMass)
+	}
+	if IntegrationMethod(d.Integration) == IntegrationVerlet {
+		defaults.integration = IntegrationVerlet
+	}
+	if d.EmitterRate > 0 {
+		defaults.emitterRate = clampEmitterRate(d.EmitterRate)
+	}
+	if d.EmitterSpeed != nil {
+		defaults.emitterSpeed = clampEmitterSpeed(*d.EmitterSpeed)
+	}
+	if d.SpawnTemperature != nil {
+		defaults.spawnTemperature = clampTemperature(*d.SpawnTemperature)
+	}
+	if d.BoilTemperature != nil {
+		defaults.boilTemperature = clampTemperature(*d.BoilTemperature)
+	}
+	if d.CondenseTemperature != nil {
+		defaults.condenseTemperature = clampTemperature(*d.CondenseTemperature)
+	}
+	if d.TPS > 0 {
+		defaults.tps = clampTPS(d.TPS)
+	}
+	if d.TimeScale > 0 {
+		defaults.timeScale = clampTimeScale(d.TimeScale)
+	}
+	if d.GIFFPS > 0 {
+		defaults.gifFPS = clampGIFFPS(d.GIFFPS)
+	}
+	if d.PhaseRate > 0 {
+		defaults.phaseRate = clampPhaseRate(d.PhaseRate)
+	}
+	for _, r := range []struct {
+		lo, hi       *float32
+		setLo, setHi *float32
+	}{
+		{d.SolidRadiusMin, d.SolidRadiusMax, &defaults.solidRadiusMin, &defaults.solidRadiusMax},
+		{d.WaterRadiusMin, d.WaterRadiusMax, &defaults.waterRadiusMin, &defaults.waterRadiusMax},
+		{d.GasRadiusMin, d.GasRadiusMax, &defaults.gasRadiusMin, &defaults.gasRadiusMax},
+		{d.SandRadiusMin, d.SandRadiusMax, &defaults.sandRadiusMin, &defaults.sandRadiusMax},
+	} {
+		if r.lo != nil && r.hi != nil {
+			*r.setLo = clampRadius(*r.lo, minSpawnRadius, maxSpawnRadius)
+			*r.setHi = clampRadius(*r.hi, *r.setLo, maxSpawnRadius)
+		}
+	}
+	if d.WellStrength != nil {
+		defaults.wellStrength = clampWellStrength(*d.WellStrength)
+	}
+	if d.VortexStrength != nil {
+		defaults.vortexStrength = clampVortexStrength(*d.VortexStrength)
+	}
+	if d.WindStrength != nil {
+		defaults.windStrength = clampWindStrength(*d.WindStrength)
+	}
+	if d.Background != nil {
+		defaults.background = color.RGBA{d.Background[0], d.Background[1], d.Background[2], 255}
+	}
+	if d.ColorRamp != nil {
+		defaults.colorRamp = ColorRamp{
+			Cold: color.RGBA{d.ColorRamp.Cold[0], d.ColorRamp.Cold[1], d.ColorRamp.Cold[2], 255},
+			Hot:  color.RGBA{d.ColorRamp.Hot[0], d.ColorRamp.Hot[1], d.ColorRamp.Hot[2], 255},
+		}
+	}
+	return Settings{
+		gravityX:             d.GravityX,
+		gravityY:             d.Gravity,
+		maxSpeed:             d.MaxSpeed,
+		moveAwayDistance:     d.MoveAwayDistance,
+		moveAwayStrength:     d.MoveAwayStrength,
+		moveAttractStrength:  d.MoveAttractStrength,
+		groundRestitution:    d.GroundRestitution,
+		collisionRestitution: d.CollisionRestitution,
+		airDrag:              d.AirDrag,
+		groundFriction:       d.GroundFriction,
+		hasTopBarrier:        d.HasTopBarrier,
+		fluidIterations:      clampFluidIterations(d.FluidIterations),
+		updateSound:          d.UpdateSound,
+		contacts:             defaults.contacts,
+		bubbleLift:           d.BubbleLift,
+		renderDownscale:      clampRenderDownscale(d.RenderDownscale),
+		foamThreshold:        defaults.foamThreshold,
+		zoneGravityX:         defaults.zoneGravityX,
+		zoneGravityY:         defaults.zoneGravityY,
+		zoneAdditive:         d.ZoneAdditive,
+		gifFrameCount:        clampGIFFrameCount(d.GIFFrameCount),
+		gifDownscale:         clampRenderDownscale(d.GIFDownscale),
+		spawnRateLimit:       defaults.spawnRateLimit,
+		spawnCooldown:        defaults.spawnCooldown,
+		collisionSolves:      clampCollisionSolves(d.CollisionSolves),
+		renderSkip:           clampRenderSkip(d.RenderSkip),
+		maxParticles:         max(0, d.MaxParticles),
+		neighborCap:          max(0, d.NeighborCap),
+		waterRestDensity:     defaults.waterRestDensity,
+		waterPressureStiff:   defaults.waterPressureStiff,
+		cannonInterval:       defaults.cannonInterval,
+		cannonBurst:          defaults.cannonBurst,
+		cannonSpeed:          defaults.cannonSpeed,
+		idleHint:             defaults.idleHint,
+		colorRamp:            defaults.colorRamp,
+		spawnMass:            defaults.spawnMass,
+		integration:          defaults.integration,
+		emitterRate:          defaults.emitterRate,
+		emitterSpeed:         defaults.emitterSpeed,
+		evictOldest:          d.EvictOldest,
+		spawnTemperature:     defaults.spawnTemperature,
+		boilTemperature:      defaults.boilTemperature,
+		condenseTemperature:  defaults.condenseTemperature,
+		phaseRate:            defaults.phaseRate,
+		gifFPS:               defaults.gifFPS,
+		screenshotFull:       d.ScreenshotFull,
+		tps:                  defaults.tps,
+		timeScale:            defaults.timeScale,
+		antialias:            d.Antialias,
+		background:           defaults.background,
+		windOn:               d.WindOn,
+		windStrength:         defaults.windStrength,
+		windAngle:            wrapAngle(d.WindAngle),
+		vortexStrength:       defaults.vortexStrength,
+		wellStrength:         defaults.wellStrength,
+		waterSurfaceTension:  clampSurfaceTension(d.WaterSurfaceTension),
+		sweptCollisions:      d.SweptCollisions,
+		solidRadiusMin:       defaults.solidRadiusMin,
+		solidRadiusMax:       defaults.solidRadiusMax,
+		waterRadiusMin:       defaults.waterRadiusMin,
+		waterRadiusMax:       defaults.waterRadiusMax,
+		gasRadiusMin:         defaults.gasRadiusMin,
+		gasRadiusMax:         defaults.gasRadiusMax,
+		sandRadiusMin:        defaults.sandRadiusMin,
+		sandRadiusMax:        defaults.sandRadiusMax,
+		gasLifetime:          clampGasLifetime(d.GasLifetime),
+	}
+}
+
+// maxFluidIterations bounds the density+pressure passes run per frame.
+const maxFluidIterations = 8
+
+func clampScatterRadius(r float32) float32 {
+	return float32(math.Min(math.Max(float64(r), 5), 500))
+}
+
+// maxGIFFrames bounds a GIF capture, which is held in memory until encoded.
+const maxGIFFrames = 600
+
+const (
+	minTPS = 10
+	maxTPS = 240
+)
+
+// maxGasLifetime caps the gas lifetime at ten minutes of default-rate steps.
+const maxGasLifetime = 36000
+
+func clampGasLifetime(steps int) int {
+	return max(0, min(maxGasLifetime, steps))
+}
+
+func clampTPS(tps int) int {
+	return max(minTPS, min(maxTPS, tps))
+}
+
+func clampWindStrength(w float32) float32 {
+	return float32(math.Min(math.Max(float64(w), 0), 1))
+}
+
+func clampVortexStrength(v float32) float32 {
+	return float32(math.Min(math.Max(float64(v), 0), 10))
+}
+
+func clampSurfaceTension(t float32) float32 {
+	return float32(math.Min(math.Max(float64(t), 0), 1))
+}
+
+func clampWellStrength(w float32) float32 {
+	return float32(math.Min(math.Max(float64(w), 0), 200))
+}
+
+// wrapAngle maps an angle in degrees into [-180, 180).
+func wrapAngle(angle float32) float32 {
+	return float32(math.Mod(math.Mod(float64(angle)+180, 360)+360, 360) - 180)
+}
+
+// Wind pushes light particles hardest: gas drifts with it, water is nudged and
+// solids barely move.
+const (
+	windOnGas   = float32(1)
+	windOnWater = float32(0.3)
+	windOnSolid = float32(0.1)
+)
+
+// windFor is the wind acceleration per tick on a particle of material, or
+// zero when wind is off.
+func (s Settings) windFor(material MaterialType) (float32, float32) {
+	if !s.windOn {
+		return 0, 0
+	}
+	response := windOnSolid
+	switch material {
+	case MaterialGas:
+		response = windOnGas
+	case MaterialWater:
+		response = windOnWater
+	}
+	sin, cos := math.Sincos(float64(s.windAngle) * math.Pi / 180)
+	strength := s.windStrength * response
+	return strength * float32(cos), strength * float32(sin)
+}
+
+// clampTimeScale bounds the slow-motion multiplier; 1 is real time.
+func clampTimeScale(s float32) float32 {
+	return float32(math.Min(math.Max(float64(s), 0.05), 1))
+}
+
+// stepDT is the simulated time one tick advances: a real tick at the current
+// TPS, shortened by the slow-motion multiplier. Slow motion takes smaller
+// steps rather than skipping them, so motion stays smooth.
+func (g *Game) stepDT() float32 {
+	return g.settings.timeScale / float32(ebiten.TPS())
+}
+
+// maxCatchUpSteps caps the physics steps one tick may run to catch up with
+// real time. A machine slower than that falls behind instead of spending ever
+// longer per tick catching up.
+const maxCatchUpSteps = 4
+
+// maxTickGap is the longest pause between ticks still made up in steps.
+// Longer ones (the menu being open, the window being dragged) count as one.
+const maxTickGap = 250 * time.Millisecond
+
+// physicsSteps returns how many steps of stepDT are due this tick. Steps are
+// spaced a real 1/TPS apart, so the simulation keeps pace with the clock on
+// machines that can't hold the tick rate. Single-stepping, recording and
+// replays take exactly one step per tick so input lines up with the physics.
+func (g *Game) physicsSteps(advance bool) int {
+	now := time.Now()
+	elapsed := now.Sub(g.lastTick)
+	g.lastTick = now
+	if !advance {
+		g.stepDebt = 0
+		g.renderAlpha = 1
+		return 0
+	}
+	period := 1 / float64(ebiten.TPS())
+	if g.paused || g.recorder != nil || g.replay != nil || elapsed > maxTickGap || period <= 0 {
+		g.stepDebt = 0
+		g.renderAlpha = 1
+		return 1
+	}
+	g.stepDebt += elapsed.Seconds()
+	steps := int(g.stepDebt / period)
+	g.stepDebt -= float64(steps) * period
+	if steps > maxCatchUpSteps {
+		steps = maxCatchUpSteps
+		g.stepDebt = 0
+	}
+	g.renderAlpha = float32(g.stepDebt / period)
+	return steps
+}
+
+// maxGIFFPS is the fastest capture rate; GIF delays are in 1/100 s and most
+// viewers slow down anything shorter than 2.
+const maxGIFFPS = 50
+
+func clampGIFFPS(fps int) int {
+	return max(1, min(maxGIFFPS, fps))
+}
+
+func clampGIFFrameCount(n int) int {
+	if n < 1 {
+		return defaultSettings().gifFrameCount
+	}
+	if n > maxGIFFrames {
+		return maxGIFFrames
+	}
+	return n
+}
+
+// maxRenderDownscale bounds how much smaller the offscreen particle buffer can be.
+const maxRenderDownscale = 8
+
+func clampRenderDownscale(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > maxRenderDownscale {
+		return maxRenderDownscale
+	}
+	return n
+}
+
+// maxCollisionSolves bounds the positional collision passes run per frame.
+const maxCollisionSolves = 16
+
+func clampCollisionSolves(n int) int {
+	if n < 1 {
+		return defaultSettings().collisionSolves
+	}
+	if n > maxCollisionSolves {
+		return maxCollisionSolves
+	}
+	return n
+}
+
+// maxRenderSkip bounds how many frames in a row can go undrawn.
+const maxRenderSkip = 4
+
+func clampRenderSkip(n int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > maxRenderSkip {
+		return maxRenderSkip
+	}
+	return n
+}
+
+// clampTemperature keeps temperatures in a range the temperature view and
+// later phase rules can make sense of.
+func clampTemperature(t float32) float32 {
+	return float32(math.Min(math.Max(float64(t), -100), 1000))
+}
+
+// clampPhaseRate bounds how much of a phase change happens per tick; at 1 a
+// particle changes on the first tick past its threshold.
+func clampPhaseRate(r float32) float32 {
+	return float32(math.Min(math.Max(float64(r), 0.01), 1))
+}
+
+func clampSpawnMass(m float32) float32 {
+	return float32(math.Min(math.Max(float64(m), 0.1), 100))
+}
+
+func clampFluidIterations(n int) int {
+	if n < 1 {
+		return 1
+	}
+	if n > maxFluidIterations {
+		return maxFluidIterations
+	}
+	return n
+}
+
+func buildScene(g *Game) sceneDTO {
+	ballDTOs := make([]sceneBallDTO, len(g.balls))
+	for i := range g.balls {
+		ballDTOs[i] = sceneBallDTO{
+			X:         g.balls[i].pos.x,
+			Y:         g.balls[i].pos.y,
+			VX:        g.balls[i].velocity.vx,
+			VY:        g.balls[i].velocity.vy,
+			Radius:    g.balls[i].radius,
+			Shape:     g.balls[i].shape,
+			Material:  g.balls[i].material,
+			Permeable: g.balls[i].permeable,
+			Body:      g.balls[i].body,
+			LocalX:    g.balls[i].local.x,
+			LocalY:    g.balls[i].local.y,
+			Mass:      &g.balls[i].mass,
+			Temp:      &g.balls[i].temperature,
+			Frozen:    g.balls[i].frozen,
+			Age:       g.balls[i].age,
+			Layer:     g.balls[i].layer,
+		}
+	}
+	zoneDTOs := make([]sceneZoneDTO, len(g.zones))
+	for i, z := range g.zones {
+		zoneDTOs[i] = sceneZoneDTO{MinX: z.min.x, MinY: z.min.y, MaxX: z.max.x, MaxY: z.max.y, GX: z.gx, GY: z.gy}
+	}
+	polygonDTOs := make([][]scenePointDTO, len(g.polygons))
+	for i, poly := range g.polygons {
+		for _, pt := range poly.points {
+			polygonDTOs[i] = append(polygonDTOs[i], scenePointDTO{X: pt.x, Y: pt.y})
+		}
+	}
+	cannonDTOs := make([]sceneCannonDTO, len(g.cannons))
+	for i, c := range g.cannons {
+		cannonDTOs[i] = sceneCannonDTO{X: c.pos.x, Y: c.pos.y, DirX: c.dirX, DirY: c.dirY, Shape: c.shape, Radius: c.radius, Interval: c.interval, Burst: c.burst, Speed: c.speed}
+	}
+	emitterDTOs := make([]sceneEmitterDTO, len(g.emitters))
+	for i, e := range g.emitters {
+		emitterDTOs[i] = sceneEmitterDTO{X: e.pos.x, Y: e.pos.y, Shape: e.shape, Radius: e.radius, Rate: e.rate, VX: e.velocity.vx, VY: e.velocity.vy}
+	}
+	wellDTOs := make([]sceneWellDTO, len(g.wells))
+	for i, w := range g.wells {
+		wellDTOs[i] = sceneWellDTO{X: w.pos.x, Y: w.pos.y, Strength: w.strength}
+	}
+	wallDTOs := make([]sceneWallDTO, len(g.walls))
+	for i, w := range g.walls {
+		wallDTOs[i] = sceneWallDTO{X1: w.a.x, Y1: w.a.y, X2: w.b.x, Y2: w.b.y, Thickness: w.thickness}
+	}
+	bucketDTOs := make([]sceneBucketDTO, len(g.buckets))
+	for i, b := range g.buckets {
+		bucketDTOs[i] = sceneBucketDTO{X: b.pos.x, Y: b.pos.y, Angle: b.angle}
+	}
+
+	return sceneDTO{
+		SceneVersion:        1,
+		AppVersion:          version,
+		Settings:            settingsToDTO(g.settings),
+		Balls:               ballDTOs,
+		BallSize:            ballsize,
+		MoveAttractDistance: moveAttractDistance,
+		SpawnClusterCount:   g.spawnClusterCount,
+		CurrentShape:        currentShape,
+		SpawnScatter:        g.spawnScatter,
+		ScatterRadius:       g.scatterRadius,
+		Buckets:             bucketDTOs,
+		Zones:               zoneDTOs,
+		Polygons:            polygonDTOs,
+		Cannons:             cannonDTOs,
+		Emitters:            emitterDTOs,
+		Walls:               wallDTOs,
+		Wells:               wellDTOs,
+	}
+}
+
+func applyScene(g *Game, scene sceneDTO) error {
+	if scene.SceneVersion != 1 {
+		return fmt.Errorf("unsupported scene version: %d", scene.SceneVersion)
+	}
+
+	g.settings = settingsFromDTO(scene.Settings)
+
+	g.spawnClusterCount = scene.SpawnClusterCount
+	if g.spawnClusterCount < 1 {
+		g.spawnClusterCount = 1
+	}
+	if g.spawnClusterCount > 50 {
+		g.spawnClusterCount = 50
+	}
+
+	ballsize = scene.BallSize
+	ballsize = math.Max(math.Min(ballsize, float64(maxSpawnRadius)), float64(minSpawnRadius))
+
+	moveAttractDistance = math.Max(scene.MoveAttractDistance, minAttractDistance)
+
+	currentShape = scene.CurrentShape
+
+	g.spawnScatter = scene.SpawnScatter
+	if scene.ScatterRadius > 0 {
+		g.scatterRadius = clampScatterRadius(scene.ScatterRadius)
+	}
+
+	g.buckets = g.buckets[:0]
+	for _, b := range scene.Buckets {
+		g.buckets = append(g.buckets, Bucket{pos: Pos{x: b.X, y: b.Y}, angle: b.Angle})
+	}
+	g.grabbedBucket = -1
+
+	g.zones = g.zones[:0]
+	for _, z := range scene.Zones {
+		g.zones = append(g.zones, GravityZone{min: Pos{x: z.MinX, y: z.MinY}, max: Pos{x: z.MaxX, y: z.MaxY}, gx: z.GX, gy: z.GY})
+	}
+
+	g.polygons = g.polygons[:0]
+	for _, pts := range scene.Polygons {
+		points := make([]Pos, len(pts))
+		for i, pt := range pts {
+			points[i] = Pos{x: pt.X, y: pt.Y}
+		}
+		if poly, ok := newPolygon(points); ok {
+			g.polygons = append(g.polygons, poly)
+		}
+	}
+
+	g.wells = g.wells[:0]
+	for _, w := range scene.Wells {
+		g.wells = append(g.wells, GravityWell{pos: Pos{x: w.X, y: w.Y}, strength: clampWellStrength(w.Strength)})
+	}
+
+	g.walls = g.walls[:0]
+	for _, w := range scene.Walls {
+		thickness := float32(math.Min(math.Max(float64(w.Thickness), float64(minSpawnRadius)), float64(maxSpawnRadius)))
+		if wall, ok := newWall(Pos{x: w.X1, y: w.Y1}, Pos{x: w.X2, y: w.Y2}, thickness); ok {
+			g.walls = append(g.walls, wall)
+		}
+	}
+
+	g.cannons = g.cannons[:0]
+	for _, c := range scene.Cannons {
+		dirX, dirY, length := normalize(c.DirX, c.DirY)
+		if length == 0 || c.Radius <= 0 {
+			continue
+		}
+		g.cannons = append(g.cannons, Cannon{
+			pos:      Pos{x: c.X, y: c.Y},
+			dirX:     dirX,
+			dirY:     dirY,
+			shape:    c.Shape,
+			radius:   c.Radius,
+			interval: clampCannonInterval(c.Interval),
+			burst:    clampCannonBurst(c.Burst),
+			speed:    c.Speed,
+		})
+	}
+	g.cannonDragging = false
+
+	g.emitters = g.emitters[:0]
+	for _, e := range scene.Emitters {
+		if e.Radius <= 0 || e.Rate <= 0 {
+			continue
+		}
+		g.emitters = append(g.emitters, Emitter{
+			pos:      Pos{x: e.X, y: e.Y},
+			shape:    e.Shape,
+			radius:   e.Radius,
+			rate:     clampEmitterRate(e.Rate),
+			velocity: Velocity{vx: e.VX, vy: e.VY},
+		})
+	}
+
+	loadedBalls := make([]Ball, 0, len(scene.Balls))
+	for _, b := range scene.Balls {
+		if !validSceneBall(b) {
+			continue
+		}
+		body := b.Body
+		if body < 0 || body > len(g.buckets) {
+			body = 0
+		}
+		mass := defaultMass(b.Material)
+		if b.Mass != nil && *b.Mass > 0 && b.Material != MaterialStatic {
+			mass = *b.Mass
+		}
+		temperature := ambientTemperature
+		if b.Temp != nil {
+			temperature = clampTemperature(*b.Temp)
+		}
+		loadedBalls = append(loadedBalls, Ball{
+			pos:         Pos{x: b.X, y: b.Y},
+			velocity:    Velocity{vx: b.VX, vy: b.VY},
+			radius:      b.Radius,
+			shape:       b.Shape,
+			material:    b.Material,
+			body:        body,
+			local:       Pos{x: b.LocalX, y: b.LocalY},
+			permeable:   b.Permeable && b.Material == MaterialStatic,
+			mass:        mass,
+			temperature: temperature,
+			frozen:      b.Frozen,
+			age:         max(0, b.Age),
+			layer:       min(b.Layer, spawnLayers-1),
+		})
+	}
+	g.clearParticles()
+	for _, b := range loadedBalls {
+		g.addParticle(b)
+	}
+	g.staticOverlaps = nil
+
+	return nil
+}
+
+// validSceneBall reports whether a saved particle has a known material and
+// shape and a radius within [minSpawnRadius, maxSpawnRadius]. The tunable
+// spawn ranges only limit new particles, so a scene saved under wider ones
+// still loads. Anything else is dropped instead of being fed to the solver.
+func validSceneBall(b sceneBallDTO) bool {
+	if b.Material < MaterialSolid || b.Material > MaterialSand {
+		return false
+	}
+	if b.Shape < ShapeCircle || b.Shape > ShapeSand {
+		return false
+	}
+	return b.Radius >= minSpawnRadius && b.Radius <= maxSpawnRadius
+}
+
+// SaveScene writes the particles, settings and tools to path as JSON.
+func (g *Game) SaveScene(path string) error {
+	return saveSceneToFile(path, g)
+}
+
+// LoadScene replaces the current scene with the one stored at path.
+func (g *Game) LoadScene(path string) error {
+	return loadSceneFromFile(path, g)
+}
+
+func saveSceneToFile(filename string, g *Game) error {
+	if filename == "" {
+		filename = defaultSceneFileName
+	}
+	filename = filepath.Clean(filename)
+	data, err := json.MarshalIndent(buildScene(g), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode scene: %w", err)
+	}
+
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write temp scene file: %w", err)
+	}
+	_ = os.Remove(filename)
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to replace scene file: %w", err)
+	}
+	return nil
+}
+
+func sceneSlotFileName(slot int) string {
+	if slot < 1 {
+		slot = 1
+	}
+	return fmt.Sprintf("phixgo-scene-%d.json", slot)
+}
+
+func loadSceneFromFile(filename string, g *Game) error {
+	if filename == "" {
+		filename = defaultSceneFileName
+	}
+	filename = filepath.Clean(filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read scene file: %w", err)
+	}
+	var scene sceneDTO
+	if err := json.Unmarshal(data, &scene); err != nil {
+		return fmt.Errorf("failed to decode scene file: %w", err)
+	}
+	if err := applyScene(g, scene); err != nil {
+		return err
+	}
+	return nil
+}
+
+// appState holds small bits of state that persist between launches.
+type appState struct {
+	TutorialSeen     bool   `json:"tutorial_seen"`
+	RestoreWorkspace bool   `json:"restore_workspace,omitempty"`
+	Channel          string `json:"channel,omitempty"` // update channel; empty means stable
+}
+
+func loadAppState() (appState, error) {
+	var state appState
+	data, err := os.ReadFile(stateFileName)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to decode state file: %w", err)
+	}
+	return state, nil
+}
+
+func saveAppState(state appState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.WriteFile(stateFileName, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// workspaceDTO is the tool and view state that carries over between sessions.
+// Unlike a scene it holds no particles or obstacles.
+type workspaceDTO struct {
+	Settings            sceneSettingsDTO `json:"settings"`
+	CurrentShape        ShapeType        `json:"current_shape"`
+	BallSize            float64          `json:"ball_size"`
+	MoveAttractDistance float64          `json:"move_attract_distance"`
+	SpawnClusterCount   int              `json:"spawn_cluster_count"`
+	SpawnScatter        bool             `json:"spawn_scatter"`
+	ScatterRadius       float32          `json:"scatter_radius"`
+	ShowAxes            bool             `json:"show_axes"`
+	HideHUD             bool             `json:"hide_hud"`
+	SelectedOption      int              `json:"selected_option"`
+}
+
+func buildWorkspace(g *Game) workspaceDTO {
+	return workspaceDTO{
+		Settings:            settingsToDTO(g.settings),
+		CurrentShape:        currentShape,
+		BallSize:            ballsize,
+		MoveAttractDistance: moveAttractDistance,
+		SpawnClusterCount:   g.spawnClusterCount,
+		SpawnScatter:        g.spawnScatter,
+		ScatterRadius:       g.scatterRadius,
+		ShowAxes:            g.showAxes,
+		HideHUD:             g.hideHUD,
+		SelectedOption:      g.selectedOption,
+	}
+}
+
+func saveWorkspace(filename string, g *Game) error {
+	data, err := json.MarshalIndent(buildWorkspace(g), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode workspace: %w", err)
+	}
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write temp workspace file: %w", err)
+	}
+	_ = os.Remove(filename)
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to replace workspace file: %w", err)
+	}
+	return nil
+}
+
+// loadWorkspace restores a saved workspace. Decoding starts from the current
+// defaults, so fields missing from the file keep their default value, and
+// everything read is clamped to the same ranges the UI allows.
+func loadWorkspace(filename string, g *Game) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read workspace file: %w", err)
+	}
+	ws := workspaceDTO{
+		Settings:            settingsToDTO(defaultSettings()),
+		CurrentShape:        ShapeCircle,
+		BallSize:            10,
+		MoveAttractDistance: 200,
+		SpawnClusterCount:   3,
+		ScatterRadius:       60,
+	}
+	if err := json.Unmarshal(data, &ws); err != nil {
+		return fmt.Errorf("failed to decode workspace file: %w", err)
+	}
+
+	g.settings = settingsFromDTO(ws.Settings)
+	if ws.CurrentShape >= ShapeCircle && ws.CurrentShape <= ShapeSand {
+		currentShape = ws.CurrentShape
+	}
+	if ws.BallSize > 0 {
+		ballsize = math.Max(math.Min(ws.BallSize, float64(maxSpawnRadius)), float64(minSpawnRadius))
+	}
+	if ws.MoveAttractDistance >= minAttractDistance {
+		moveAttractDistance = ws.MoveAttractDistance
+	}
+	g.spawnClusterCount = max(1, min(50, ws.SpawnClusterCount))
+	g.spawnScatter = ws.SpawnScatter
+	g.scatterRadius = clampScatterRadius(ws.ScatterRadius)
+	g.showAxes = ws.ShowAxes
+	g.hideHUD = ws.HideHUD
+	if ws.SelectedOption >= 0 && ws.SelectedOption < menuOptionCount {
+		g.selectedOption = ws.SelectedOption
+	}
+	return nil
+}
+
+// configDTO is the part of the game the player tunes, saved to the user
+// config dir whenever the menu closes and on exit.
+type configDTO struct {
+	Settings          sceneSettingsDTO `json:"settings"`
+	CurrentShape      ShapeType        `json:"current_shape"`
+	BallSize          float64          `json:"ball_size"`
+	SpawnClusterCount int              `json:"spawn_cluster_count"`
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no config dir: %w", err)
+	}
+	return filepath.Join(dir, "phixgo", configFileName), nil
+}
+
+func saveConfig(g *Game) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	settings := g.settings
+	g.flagSettings.restore(&settings, g.configSettings)
+	cfg := configDTO{
+		Settings:          settingsToDTO(settings),
+		CurrentShape:      currentShape,
+		BallSize:          ballsize,
+		SpawnClusterCount: g.spawnClusterCount,
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write temp config file: %w", err)
+	}
+	_ = os.Remove(path)
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace config file: %w", err)
+	}
+	return nil
+}
+
+// loadConfig applies the saved config. Like loadWorkspace it decodes on top of
+// the defaults and clamps what it reads; on any error g is left untouched.
+func loadConfig(g *Game) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	cfg := configDTO{
+		Settings:          settingsToDTO(defaultSettings()),
+		CurrentShape:      ShapeCircle,
+		BallSize:          10,
+		SpawnClusterCount: defaultSpawnClusterCount,
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to decode config file: %w", err)
+	}
+
+	g.settings = settingsFromDTO(cfg.Settings)
+	if cfg.CurrentShape >= ShapeCircle && cfg.CurrentShape <= ShapeSand {
+		currentShape = cfg.CurrentShape
+	}
+	if cfg.BallSize > 0 {
+		ballsize = math.Max(math.Min(cfg.BallSize, float64(maxSpawnRadius)), float64(minSpawnRadius))
+	}
+	g.spawnClusterCount = max(1, min(50, cfg.SpawnClusterCount))
+	return nil
+}
+
+// mouseHelp sums up the mouse bindings in the corner of the HUD.
+const mouseHelp = "L: spawn  Ctrl+L: erase  Alt+L: paint static  R: push  Shift+R: pull  Ctrl+R: swirl  wheel: size  Shift+wheel: pull radius"
+
+// tutorialSteps are shown in order on first launch; each one advances once
+// the player has tried what it describes.
+var tutorialSteps = []string{
+	"Left-click to spawn particles at the cursor",
+	"Press 1-8 to switch material: 1-3 solids, 4 water, 5 gas, 6 static, 7 grate, 8 sand",
+	"Scroll the mouse wheel to change the particle size",
+	"Right-click to push particles away, Shift+Right-click to pull them in",
+	"Press ESC to open the settings menu",
+}
+
+func (g *Game) updateTutorial() {
+	skipPressed := keyPressed(ebiten.KeyEnter)
+	skip := skipPressed && !g.prevSkipPressed
+	g.prevSkipPressed = skipPressed
+
+	done := false
+	switch g.tutorialStep {
+	case 0:
+		done = mousePressed(ebiten.MouseButtonLeft) && !g.updateButtonHover
+		g.tutorialShape = currentShape
+	case 1:
+		done = currentShape != g.tutorialShape
+	case 2:
+		_, wy := wheel()
+		done = wy != 0
+	case 3:
+		done = mousePressed(ebiten.MouseButtonRight)
+	case 4:
+		done = g.showMenu
+	}
+	if done {
+		g.tutorialStep++
+	}
+	if skip || g.tutorialStep >= len(tutorialSteps) {
+		g.tutorialStep = -1
+		state, _ := loadAppState()
+		state.TutorialSeen = true
+		if err := saveAppState(state); err != nil {
+			g.updateMessage = fmt.Sprintf("Save state failed: %v", err)
+		}
+	}
+}
+
+func (g *Game) drawTutorial(screen *ebiten.Image) {
+	text := fmt.Sprintf("Tutorial %d/%d: %s  (Enter to skip)", g.tutorialStep+1, len(tutorialSteps), tutorialSteps[g.tutorialStep])
+	drawHintBox(screen, text)
+}
+
+const idleHintText = "Left-click to spawn | 1-8 pick a shape | ESC for menu  (Enter to dismiss)"
+
+// drawHintBox draws a one-line boxed message centred near the bottom of the screen.
+func drawHintBox(screen *ebiten.Image, text string) {
+	width := float32(len(text)*6 + 20)
+	x := (float32(screen.Bounds().Dx()) - width) / 2
+	y := float32(screen.Bounds().Dy()) - 120
+	vector.DrawFilledRect(screen, x, y, width, 30, color.RGBA{40, 40, 70, 220}, false)
+	vector.StrokeRect(screen, x, y, width, 30, 2, color.RGBA{150, 150, 220, 255}, false)
+	ebitenutil.DebugPrintAt(screen, text, int(x+10), int(y+8))
+}
+
+// spatialHash accelerates neighbor lookups via a uniform grid. Only occupied
+// cells are kept in buckets; Clear hands their slices to spare so the next
+// build reuses them instead of allocating.
+type spatialHash struct {
+	cellSize      float32
+	invCellSize   float32
+	invCellSize64 float64
+	buckets       map[int64][]int
+	usedKeys      []int64
+	spare         [][]int
+}
+
+type cellCoord struct {
+	x int
+	y int
+}
+
+func newSpatialHash(cellSize float32) spatialHash {
+	if cellSize <= 0 {
+		cellSize = 1
+	}
+	inv := 1 / cellSize
+	return spatialHash{
+		cellSize:      cellSize,
+		invCellSize:   inv,
+		invCellSize64: float64(inv),
+		buckets:       make(map[int64][]int),
+	}
+}
+
+func (h *spatialHash) Clear() {
+	for _, key := range h.usedKeys {
+		h.spare = append(h.spare, h.buckets[key][:0])
+		delete(h.buckets, key)
+	}
+	h.usedKeys = h.usedKeys[:0]
+}
+
+func (h *spatialHash) insert(index, ix, iy int) {
+	key := hashKey(ix, iy)
+	bucket, ok := h.buckets[key]
+	if !ok {
+		if n := len(h.spare); n > 0 {
+			bucket = h.spare[n-1]
+			h.spare = h.spare[:n-1]
+		} else {
+			bucket = make([]int, 0, 8)
+		}
+		h.usedKeys = append(h.usedKeys, key)
+	}
+	bucket = append(bucket, index)
+	h.buckets[key] = bucket
+}
+
+// move re-files index from one cell to another. Order within a cell does not
+// matter, so it is swap-removed from the old one.
+func (h *spatialHash) move(index int, from, to cellCoord) {
+	key := hashKey(from.x, from.y)
+	bucket := h.buckets[key]
+	for k, v := range bucket {
+		if v == index {
+			bucket[k] = bucket[len(bucket)-1]
+			h.buckets[key] = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	h.insert(index, to.x, to.y)
+}
+
+func (h *spatialHash) cell(ix, iy int) []int {
+	key := hashKey(ix, iy)
+	return h.buckets[key]
+}
+
+// coord returns the cell index for value, clamped to the range hashKey can
+// pack. Far-out (or NaN) positions pile into the edge cell rather than wrapping
+// around onto some other cell.
+func (h *spatialHash) coord(value float32) int {
+	c := math.Floor(float64(value) * h.invCellSize64)
+	if !(c >= math.MinInt32) {
+		return math.MinInt32
+	}
+	if c > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int(c)
+}
+
+// hashKey packs a cell into one key. It is collision-free for ix and iy within
+// int32, which coord guarantees; wider values would wrap.
+func hashKey(ix, iy int) int64 {
+	return (int64(uint32(ix)) << 32) | int64(uint32(iy))
+}
+
+// keyCell is the inverse of hashKey.
+func keyCell(key int64) (int, int) {
+	return int(int32(key >> 32)), int(int32(key))
+}
+
+// maxRadius returns the largest particle radius in list.
+func maxRadius(list []Ball) float32 {
+	largest := float32(0)
+	for i := range list {
+		if list[i].radius > largest {
+			largest = list[i].radius
+		}
+	}
+	return largest
+}
+
+// calibrateCellSize times the broadphase neighbor loop over a copy of
+// particles for several cell sizes and returns the fastest size with its cost
+// per pass and the number of overlapping pairs a pass finds. Cells never
+// shrink below the largest diameter, since the 3x3 neighborhood would
+// otherwise miss overlapping pairs.
+func calibrateCellSize(particles []Ball) (float32, time.Duration, int) {
+	snapshot := append([]Ball(nil), particles...)
+	minSize := 2 * maxRadius(snapshot)
+	if minSize < 2*minSpawnRadius {
+		minSize = 2 * minSpawnRadius
+	}
+	if len(snapshot) < 2 {
+		return minSize, 0, 0
+	}
+
+	const passes = 5
+	best := minSize
+	bestCost := time.Duration(math.MaxInt64)
+	overlaps := 0
+	cells := make([]cellCoord, len(snapshot))
+	for _, factor := range []float32{1, 1.25, 1.5, 2, 3, 4} {
+		size := minSize * factor
+		hash := newSpatialHash(size)
+		tests := 0
+		start := time.Now()
+		for pass := 0; pass < passes; pass++ {
+			hash.Clear()
+			for i := range snapshot {
+				cells[i] = cellCoord{x: hash.coord(snapshot[i].pos.x), y: hash.coord(snapshot[i].pos.y)}
+				hash.insert(i, cells[i].x, cells[i].y)
+			}
+			for i := range snapshot {
+				for _, offset := range neighborOffsets {
+					for _, j := range hash.cell(cells[i].x+offset.dx, cells[i].y+offset.dy) {
+						if j <= i {
+							continue
+						}
+						dx := snapshot[j].pos.x - snapshot[i].pos.x
+						dy := snapshot[j].pos.y - snapshot[i].pos.y
+						r := snapshot[i].radius + snapshot[j].radius
+						if dx*dx+dy*dy < r*r {
+							tests++
+						}
+					}
+				}
+			}
+		}
+		cost := time.Since(start) / passes
+		if cost < bestCost {
+			best, bestCost = size, cost
+		}
+		overlaps = tests / passes
+	}
+	return best, bestCost, overlaps
+}
+
+var neighborOffsets = [...]struct{ dx, dy int }{
+	{0, 0}, {1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{1, 1}, {1, -1}, {-1, 1}, {-1, -1},
+}
+
+// asleep reports whether b has rested long enough for the solver to skip it.
+func (b *Ball) asleep() bool {
+	return b.stillSteps >= sleepSteps
+}
+
+func (b *Ball) speed() float32 {
+	return float32(math.Sqrt(float64(b.velocity.vx*b.velocity.vx + b.velocity.vy*b.velocity.vy)))
 }
 
 func (b *Ball) speedSquared() float32 {
@@ -457,15 +3244,37 @@ func normalize(dx, dy float32) (nx, ny, distance float32) {
 	return dx / distance, dy / distance, distance
 }
 
-func mobilityFor(material MaterialType) float32 {
-	if material == MaterialStatic {
+// inverseMass is 1/mass, or 0 for statics, frozen, sleeping and massless
+// particles, which collisions treat as immovable.
+func inverseMass(b *Ball) float32 {
+	if b.material == MaterialStatic || b.frozen || b.asleep() || b.mass <= 0 {
 		return 0
 	}
-	return 1
+	return 1 / b.mass
+}
+
+// resolveShapes picks the contact test for the pair's shapes. Squares against
+// squares collide as boxes and triangles against round particles use the
+// real triangle; every other pair collides as two circles.
+func resolveShapes(b1, b2 *Ball, collisionRestitution, friction float32) bool {
+	switch {
+	case b1.shape == ShapeSquare && b2.shape == ShapeSquare:
+		return resolveBoxCollision(b1, b2, collisionRestitution, friction)
+	case b1.shape == ShapeTriangle && isRound(b2.shape):
+		return resolveTriangleCollision(b1, b2, collisionRestitution, friction)
+	case b2.shape == ShapeTriangle && isRound(b1.shape):
+		return resolveTriangleCollision(b2, b1, collisionRestitution, friction)
+	}
+	return resolveCollisionCustom(b1, b2, collisionRestitution, friction)
 }
 
-func resolveCollision(b1, b2 *Ball, collisionRestitution float32) bool {
-	return resolveCollisionCustom(b1, b2, collisionRestitution, 0.5)
+// isRound reports whether a shape is drawn, and collides, as a circle.
+func isRound(shape ShapeType) bool {
+	switch shape {
+	case ShapeCircle, ShapeWater, ShapeGas, ShapeStatic, ShapeGrate:
+		return true
+	}
+	return false
 }
 
 func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32) bool {
@@ -478,23 +3287,114 @@ func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32
 		return false
 	}
 
-	if distSq < minimumSeparation*minimumSeparation {
-		distSq = minimumSeparation * minimumSeparation
+	if distSq < minimumSeparation*minimumSeparation {
+		distSq = minimumSeparation * minimumSeparation
+	}
+
+	distance := float32(math.Sqrt(float64(distSq)))
+	nx := dx / distance
+	ny := dy / distance
+	if nx == 0 && ny == 0 {
+		nx = 1
+	}
+	overlap := combinedRadius - distance
+	if overlap <= 0 {
+		return false
+	}
+	applyContact(b1, b2, nx, ny, overlap, collisionRestitution, friction)
+	return true
+}
+
+// resolveBoxCollision resolves two axis-aligned squares, using radius as the
+// half-extent. They are pushed apart along the axis of least overlap, which
+// lets stacked squares rest flat instead of rolling off each other's corners.
+func resolveBoxCollision(b1, b2 *Ball, collisionRestitution, friction float32) bool {
+	dx := b2.pos.x - b1.pos.x
+	dy := b2.pos.y - b1.pos.y
+	extent := b1.radius + b2.radius
+	overlapX := extent - float32(math.Abs(float64(dx)))
+	overlapY := extent - float32(math.Abs(float64(dy)))
+	if overlapX <= 0 || overlapY <= 0 {
+		return false
+	}
+	if overlapX < overlapY {
+		nx := float32(1)
+		if dx < 0 {
+			nx = -1
+		}
+		applyContact(b1, b2, nx, 0, overlapX, collisionRestitution, friction)
+	} else {
+		ny := float32(1)
+		if dy < 0 {
+			ny = -1
+		}
+		applyContact(b1, b2, 0, ny, overlapY, collisionRestitution, friction)
+	}
+	return true
+}
+
+// triangleVertices returns the corners of the equilateral triangle drawShape
+// draws for a triangle particle: top, bottom left, bottom right.
+func triangleVertices(b *Ball) [3]Pos {
+	height := b.radius * 1.732 // sqrt(3)
+	return [3]Pos{
+		{x: b.pos.x, y: b.pos.y - height*0.67},
+		{x: b.pos.x - b.radius, y: b.pos.y + height*0.33},
+		{x: b.pos.x + b.radius, y: b.pos.y + height*0.33},
 	}
+}
 
-	distance := float32(math.Sqrt(float64(distSq)))
-	nx := dx / distance
-	ny := dy / distance
-	if nx == 0 && ny == 0 {
-		nx = 1
+// resolveTriangleCollision resolves a triangle against a round particle. The
+// normal runs from the closest point on the triangle's outline to the
+// circle's center, so circles slide along the faces; a center that has
+// sunk inside is pushed out through the nearest edge.
+func resolveTriangleCollision(tri, ball *Ball, collisionRestitution, friction float32) bool {
+	v := triangleVertices(tri)
+	px, py := ball.pos.x, ball.pos.y
+	bestSq := float32(math.MaxFloat32)
+	var cx, cy float32
+	inside := true
+	for k := range v {
+		a, b := v[k], v[(k+1)%3]
+		ex, ey := b.x-a.x, b.y-a.y
+		// With y pointing down the vertices wind so that points on the inner
+		// side of every edge have a negative cross product.
+		if ex*(py-a.y)-ey*(px-a.x) > 0 {
+			inside = false
+		}
+		t := ((px-a.x)*ex + (py-a.y)*ey) / (ex*ex + ey*ey)
+		t = min(1, max(0, t))
+		qx, qy := a.x+ex*t, a.y+ey*t
+		if d := (px-qx)*(px-qx) + (py-qy)*(py-qy); d < bestSq {
+			bestSq, cx, cy = d, qx, qy
+		}
 	}
-	overlap := combinedRadius - distance
-	if overlap <= 0 {
+
+	dist := float32(math.Sqrt(float64(bestSq)))
+	if !inside && dist >= ball.radius {
 		return false
 	}
+	var nx, ny float32
+	if dist < minimumSeparation {
+		nx, ny, _ = normalize(px-tri.pos.x, py-tri.pos.y)
+	} else {
+		nx, ny = (px-cx)/dist, (py-cy)/dist
+	}
+	overlap := ball.radius - dist
+	if inside {
+		nx, ny = -nx, -ny
+		overlap = ball.radius + dist
+	}
+	applyContact(tri, ball, nx, ny, overlap, collisionRestitution, friction)
+	return true
+}
 
-	mob1 := mobilityFor(b1.material)
-	mob2 := mobilityFor(b2.material)
+// applyContact separates two overlapping particles along the unit normal
+// (nx, ny), pointing from b1 to b2, and applies the bounce and friction
+// impulses.
+func applyContact(b1, b2 *Ball, nx, ny, overlap, collisionRestitution, friction float32) {
+	mob1 := inverseMass(b1)
+	mob2 := inverseMass(b2)
 
 	// Add a small slop to keep shapes from sinking into each other when resting.
 	separation := overlap + penetrationSlop
@@ -502,7 +3402,7 @@ func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32
 	weight2 := mob2
 	weightSum := weight1 + weight2
 	if weightSum == 0 {
-		return true
+		return
 	}
 	shift1 := separation * (weight1 / weightSum)
 	shift2 := separation * (weight2 / weightSum)
@@ -520,7 +3420,7 @@ func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32
 	rvy := b2.velocity.vy - b1.velocity.vy
 	velAlongNormal := rvx*nx + rvy*ny
 	if velAlongNormal > 0 {
-		return true
+		return
 	}
 
 	restitution := collisionRestitution
@@ -528,7 +3428,7 @@ func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32
 	invMass2 := mob2
 	massSum := invMass1 + invMass2
 	if massSum == 0 {
-		return true
+		return
 	}
 	impulseScalar := -(1 + restitution) * velAlongNormal / massSum
 	impulseX := impulseScalar * nx
@@ -561,27 +3461,166 @@ func resolveCollisionCustom(b1, b2 *Ball, collisionRestitution, friction float32
 			b2.velocity.vy -= fy * invMass2
 		}
 	}
-	return true
 }
 
-func velocityToColor(velocity float32, maxSpeed float32) color.Color {
-	normalizedSpeed := velocity / maxSpeed
+// holdSandPair damps the separating and sliding motion of two touching sand
+// grains. The collision only brakes grains moving together, so without this a
+// pile slumps flat; with it the pile keeps an angle of repose.
+func holdSandPair(a, b *Ball) {
+	dx := b.pos.x - a.pos.x
+	dy := b.pos.y - a.pos.y
+	reach := (a.radius + b.radius) * sandContactMargin
+	if dx*dx+dy*dy >= reach*reach {
+		return
+	}
+	invA := inverseMass(a)
+	invB := inverseMass(b)
+	invSum := invA + invB
+	if invSum == 0 {
+		return
+	}
+	nx, ny, _ := normalize(dx, dy)
+	rvx := b.velocity.vx - a.velocity.vx
+	rvy := b.velocity.vy - a.velocity.vy
+	// Grains moving together are left to the collision response.
+	if along := rvx*nx + rvy*ny; along < 0 {
+		rvx -= along * nx
+		rvy -= along * ny
+	}
+	ix := rvx * sandCohesion / invSum
+	iy := rvy * sandCohesion / invSum
+	a.velocity.vx += ix * invA
+	a.velocity.vy += iy * invA
+	b.velocity.vx -= ix * invB
+	b.velocity.vy -= iy * invB
+}
+
+// ColorRamp is the gradient particles are drawn with, from Cold at rest to
+// Hot at maxSpeed.
+type ColorRamp struct {
+	Cold color.RGBA
+	Hot  color.RGBA
+}
+
+// colorRamps are the gradients offered in the menu. The first is the default.
+var colorRamps = []struct {
+	name string
+	ramp ColorRamp
+}{
+	{"Green-Red", ColorRamp{Cold: color.RGBA{0, 255, 0, 255}, Hot: color.RGBA{255, 0, 0, 255}}},
+	{"Blue-Red", ColorRamp{Cold: color.RGBA{40, 80, 255, 255}, Hot: color.RGBA{255, 40, 40, 255}}},
+	{"Ice-White", ColorRamp{Cold: color.RGBA{20, 40, 120, 255}, Hot: color.RGBA{230, 245, 255, 255}}},
+	{"Purple-Yellow", ColorRamp{Cold: color.RGBA{90, 20, 140, 255}, Hot: color.RGBA{255, 220, 40, 255}}},
+}
+
+// colorRampIndex returns the position of ramp in colorRamps, or -1 for a
+// custom gradient loaded from a scene.
+// backgrounds are the screen colors offered in the menu. The first is the
+// default.
+var backgrounds = []struct {
+	name  string
+	color color.RGBA
+}{
+	{"Black", color.RGBA{0, 0, 0, 255}},
+	{"Dark Blue", color.RGBA{12, 22, 48, 255}},
+	{"White", color.RGBA{255, 255, 255, 255}},
+}
+
+func backgroundIndex(c color.RGBA) int {
+	for i, b := range backgrounds {
+		if b.color == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// isLight reports whether white debug text would be hard to read on c.
+func isLight(c color.RGBA) bool {
+	return 299*int(c.R)+587*int(c.G)+114*int(c.B) > 128*1000
+}
+
+// printHUD prints a line of HUD text, on a dark strip when the background is
+// too light for the debug font's white text.
+func (g *Game) printHUD(screen *ebiten.Image, text string, x, y int) {
+	if isLight(g.settings.background) {
+		vector.DrawFilledRect(screen, float32(x), float32(y), float32(len(text)*6+2), 16, color.RGBA{0, 0, 0, 170}, false)
+	}
+	ebitenutil.DebugPrintAt(screen, text, x, y)
+}
+
+func colorRampIndex(ramp ColorRamp) int {
+	for i, r := range colorRamps {
+		if r.ramp == ramp {
+			return i
+		}
+	}
+	return -1
+}
+
+func velocityToColor(speed, maxSpeed float32, ramp ColorRamp) color.Color {
+	normalizedSpeed := speed / maxSpeed
 	if normalizedSpeed > 1 {
 		normalizedSpeed = 1
 	}
 
-	g := uint8(normalizedSpeed * 255)
-	b := uint8((1 - normalizedSpeed) * 255)
+	lerp := func(cold, hot uint8) uint8 {
+		return uint8(float32(cold) + (float32(hot)-float32(cold))*normalizedSpeed)
+	}
+	return color.RGBA{
+		R: lerp(ramp.Cold.R, ramp.Hot.R),
+		G: lerp(ramp.Cold.G, ramp.Hot.G),
+		B: lerp(ramp.Cold.B, ramp.Hot.B),
+		A: 255,
+	}
+}
+
+// temperatureRamp runs from blue at coldTemperature to red at hotTemperature.
+var temperatureRamp = ColorRamp{Cold: color.RGBA{40, 90, 255, 255}, Hot: color.RGBA{255, 50, 30, 255}}
+
+func temperatureColor(t float32) color.Color {
+	return velocityToColor(max(0, t-coldTemperature), hotTemperature-coldTemperature, temperatureRamp)
+}
+
+var (
+	waterCalmColor = color.RGBA{R: 45, G: 134, B: 255, A: 200}
+	waterFoamColor = color.RGBA{R: 235, G: 245, B: 255, A: 230}
+)
 
-	return color.RGBA{R: g, G: b, B: 0, A: 255}
+// waterColor blends from calm blue toward white foam as speed rises from
+// foamThreshold to maxSpeed.
+func waterColor(speed, foamThreshold, maxSpeed float32) color.RGBA {
+	if speed <= foamThreshold || maxSpeed <= foamThreshold {
+		return waterCalmColor
+	}
+	t := (speed - foamThreshold) / (maxSpeed - foamThreshold)
+	if t > 1 {
+		t = 1
+	}
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float32(a) + (float32(b)-float32(a))*t)
+	}
+	return color.RGBA{
+		R: lerp(waterCalmColor.R, waterFoamColor.R),
+		G: lerp(waterCalmColor.G, waterFoamColor.G),
+		B: lerp(waterCalmColor.B, waterFoamColor.B),
+		A: lerp(waterCalmColor.A, waterFoamColor.A),
+	}
 }
 
-func drawShape(screen *ebiten.Image, shape ShapeType, x, y, radius float32, col color.Color) {
+func drawShape(screen *ebiten.Image, shape ShapeType, x, y, radius float32, col color.Color, antialias bool) {
 	switch shape {
 	case ShapeCircle:
-		vector.DrawFilledCircle(screen, x, y, radius, col, false)
+		vector.DrawFilledCircle(screen, x, y, radius, col, antialias)
 	case ShapeSquare:
-		vector.DrawFilledRect(screen, x-radius, y-radius, radius*2, radius*2, col, false)
+		vector.DrawFilledRect(screen, x-radius, y-radius, radius*2, radius*2, col, antialias)
 	case ShapeTriangle:
 		// Draw equilateral triangle
 		height := radius * 1.732 // sqrt(3)
@@ -599,41 +3638,458 @@ func drawShape(screen *ebiten.Image, shape ShapeType, x, y, radius float32, col
 			vertices[i].ColorA = float32(col.(color.RGBA).A) / 255
 		}
 		screen.DrawTriangles(vertices, indices, emptyImage, &ebiten.DrawTrianglesOptions{
-			AntiAlias: false,
+			AntiAlias: antialias,
 		})
 	case ShapeWater:
-		vector.DrawFilledCircle(screen, x, y, radius, col, false)
+		vector.DrawFilledCircle(screen, x, y, radius, col, antialias)
 	case ShapeGas:
-		vector.DrawFilledCircle(screen, x, y, radius, col, false)
+		vector.DrawFilledCircle(screen, x, y, radius, col, antialias)
 	case ShapeStatic:
-		vector.DrawFilledCircle(screen, x, y, radius, col, false)
+		vector.DrawFilledCircle(screen, x, y, radius, col, antialias)
+	case ShapeGrate:
+		vector.StrokeCircle(screen, x, y, radius-1, 2, col, antialias)
+	case ShapeSand:
+		vector.DrawFilledRect(screen, x-radius, y-radius, radius*2, radius*2, col, antialias)
 	}
 }
 
 var emptyImage = ebiten.NewImage(3, 3)
 
-const menuOptionCount = 12
+const menuOptionCount = 79
+
+// minAttractDistance is the smallest radius the pull tool can be given.
+const minAttractDistance = 10
 
 var (
 	ballsize            float64 = 10
 	moveAttractDistance float64 = 200.0
-	balls               []Ball
 	ballSpawnTimer      int
 	currentShape        ShapeType = ShapeCircle
 )
 
+// adjustMenuOption changes the selected setting by my wheel steps. Toggles and
+// actions only look at the sign; the Exit row returns ebiten.Termination.
+func (g *Game) adjustMenuOption(my float64) error {
+	if my == 0 {
+		return nil
+	}
+	changeAmount := float32(0.01)
+	if keyPressed(ebiten.KeyShift) {
+		changeAmount = 0.1
+	}
+	change := float32(my) * changeAmount
+	switch g.selectedOption {
+	case 0: // Gravity
+		magnitude := float32(math.Max(0, float64(g.settings.gravityMagnitude()+change)))
+		g.settings.setGravity(magnitude, g.settings.gravityAngle())
+	case 1: // Max Speed
+		g.settings.maxSpeed = float32(math.Max(0.1, float64(g.settings.maxSpeed+change)))
+	case 2: // Move Away Distance
+		g.settings.moveAwayDistance = float32(math.Max(10, float64(g.settings.moveAwayDistance+change*10)))
+	case 3: // Move Away Strength
+		g.settings.moveAwayStrength = float32(math.Max(0.1, float64(g.settings.moveAwayStrength+change)))
+	case 4: // Move Attract Strength
+		g.settings.moveAttractStrength = float32(math.Max(0.1, float64(g.settings.moveAttractStrength+change)))
+	case 5: // Ground Restitution
+		g.settings.groundRestitution = float32(math.Min(1, math.Max(0, float64(g.settings.groundRestitution+change))))
+	case 6: // Collision Restitution
+		g.settings.collisionRestitution = float32(math.Min(1, math.Max(0, float64(g.settings.collisionRestitution+change))))
+	case 7: // Air Drag
+		g.settings.airDrag = float32(math.Min(1, math.Max(0, float64(g.settings.airDrag+change))))
+	case 8: // Ground Friction
+		g.settings.groundFriction = float32(math.Min(1, math.Max(0, float64(g.settings.groundFriction+change))))
+	case 9: // Spawn Count
+		delta := int(my)
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 5
+		}
+		g.spawnClusterCount += delta
+		if g.spawnClusterCount < 1 {
+			g.spawnClusterCount = 1
+		}
+		if g.spawnClusterCount > 50 {
+			g.spawnClusterCount = 50
+		}
+	case 10: // Top Barrier
+		if my != 0 {
+			g.settings.hasTopBarrier = !g.settings.hasTopBarrier
+		}
+	case 11: // Fluid Iterations
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		g.settings.fluidIterations = clampFluidIterations(g.settings.fluidIterations + delta)
+	case 12: // Update Sound
+		g.settings.updateSound = !g.settings.updateSound
+	case 13: // Calibrate Cell Size
+		if my > 0 {
+			size, cost, overlaps := calibrateCellSize(g.balls)
+			g.collider = newSpatialHash(size)
+			g.updateMessage = fmt.Sprintf("Cell size: %.0f (%v/pass, %d overlapping pairs)", size, cost, overlaps)
+		}
+	case 14: // Water-Gas Restitution
+		r := g.settings.contacts[MaterialWater][MaterialGas]
+		r.restitution = float32(math.Min(1, math.Max(0, float64(r.restitution+change))))
+		g.settings.contacts.set(MaterialWater, MaterialGas, r)
+	case 15: // Water-Gas Friction
+		r := g.settings.contacts[MaterialWater][MaterialGas]
+		r.friction = float32(math.Min(1, math.Max(0, float64(r.friction+change))))
+		g.settings.contacts.set(MaterialWater, MaterialGas, r)
+	case 16: // Bubble Lift
+		g.settings.bubbleLift = float32(math.Min(2, math.Max(0, float64(g.settings.bubbleLift+change))))
+	case 17: // Render Downscale
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		g.settings.renderDownscale = clampRenderDownscale(g.settings.renderDownscale + delta)
+	case 18: // Compact Memory
+		if my > 0 {
+			g.updateMessage = g.compact()
+		}
+	case 19: // Foam Threshold
+		g.settings.foamThreshold = float32(math.Max(0, float64(g.settings.foamThreshold+change*10)))
+	case 20: // Spawn Mode
+		g.spawnScatter = !g.spawnScatter
+	case 21: // Scatter Radius
+		g.scatterRadius = clampScatterRadius(g.scatterRadius + change*100)
+	case 22: // Zone Gravity X
+		g.settings.zoneGravityX += change
+	case 23: // Zone Gravity Y
+		g.settings.zoneGravityY += change
+	case 24: // Zone Mode
+		g.settings.zoneAdditive = !g.settings.zoneAdditive
+	case 25: // GIF Frames
+		delta := int(my) * 10
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 5
+		}
+		g.settings.gifFrameCount = clampGIFFrameCount(g.settings.gifFrameCount + delta)
+	case 26: // GIF Downscale
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		g.settings.gifDownscale = clampRenderDownscale(g.settings.gifDownscale + delta)
+	case 27: // Spawn Rate Limit
+		delta := int(my) * 50
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 5
+		}
+		g.settings.spawnRateLimit += delta
+		if g.settings.spawnRateLimit < 0 {
+			g.settings.spawnRateLimit = 0
+		}
+	case 28: // Large Batch Cooldown
+		g.settings.spawnCooldown = float32(math.Min(2, math.Max(0, float64(g.settings.spawnCooldown+change))))
+	case 29: // Profile
+		i := g.settings.profileIndex()
+		if i < 0 {
+			i = profileFlagNames["default"]
+		} else if my > 0 {
+			i = (i + 1) % len(profiles)
+		} else {
+			i = (i + len(profiles) - 1) % len(profiles)
+		}
+		g.settings.applyProfile(profiles[i])
+	case 30: // Collision Solves
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		g.settings.collisionSolves = max(1, min(maxCollisionSolves, g.settings.collisionSolves+delta))
+	case 31: // Render Frame Skip
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		g.settings.renderSkip = clampRenderSkip(g.settings.renderSkip + delta)
+	case 32: // Max Particles
+		delta := int(my) * 500
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 5
+		}
+		g.settings.maxParticles = max(0, g.settings.maxParticles+delta)
+	case 33: // Neighbor Cap
+		delta := int(my) * 4
+		g.settings.neighborCap = max(0, g.settings.neighborCap+delta)
+	case 34: // Water Rest Density
+		g.settings.waterRestDensity = float32(math.Min(20, math.Max(0.5, float64(g.settings.waterRestDensity+change*10))))
+	case 35: // Water Stiffness
+		g.settings.waterPressureStiff = float32(math.Min(2, math.Max(0, float64(g.settings.waterPressureStiff+change))))
+	case 36: // Cannon Interval
+		g.settings.cannonInterval = clampCannonInterval(g.settings.cannonInterval + change*10)
+	case 37: // Cannon Burst
+		g.settings.cannonBurst = clampCannonBurst(g.settings.cannonBurst + int(my))
+	case 38: // Cannon Speed
+		g.settings.cannonSpeed = float32(math.Min(40, math.Max(0, float64(g.settings.cannonSpeed+change*10))))
+	case 39: // Check Static Overlaps
+		if my > 0 {
+			g.staticOverlaps = findStaticOverlaps(g.balls)
+			g.updateMessage = describeOverlaps(g.staticOverlaps)
+		}
+	case 40: // Idle Hint
+		g.settings.idleHint = !g.settings.idleHint
+	case 41: // Restore Workspace
+		g.restoreWorkspace = !g.restoreWorkspace
+		state, _ := loadAppState()
+		state.RestoreWorkspace = g.restoreWorkspace
+		if err := saveAppState(state); err != nil {
+			g.updateMessage = fmt.Sprintf("Save state failed: %v", err)
+		}
+	case 42: // Color Ramp
+		i := colorRampIndex(g.settings.colorRamp)
+		if my > 0 {
+			i = (i + 1) % len(colorRamps)
+		} else {
+			i = (max(i, 0) + len(colorRamps) - 1) % len(colorRamps)
+		}
+		g.settings.colorRamp = colorRamps[i].ramp
+	case 43: // Spawn Mass
+		g.settings.spawnMass = clampSpawnMass(g.settings.spawnMass + change*10)
+	case 44: // Gravity Angle
+		angle := g.settings.gravityAngle() + float32(my)*5
+		if keyPressed(ebiten.KeyShift) {
+			angle += float32(my) * 10
+		}
+		angle = float32(math.Mod(float64(angle)+540, 360) - 180)
+		g.settings.setGravity(g.settings.gravityMagnitude(), angle)
+	case 45: // Integration
+		if g.settings.integration == IntegrationEuler {
+			g.settings.integration = IntegrationVerlet
+		} else {
+			g.settings.integration = IntegrationEuler
+		}
+	case 46: // Emitter Rate
+		g.settings.emitterRate = clampEmitterRate(g.settings.emitterRate + change*100)
+	case 47: // Emitter Speed
+		g.settings.emitterSpeed = clampEmitterSpeed(g.settings.emitterSpeed + change*10)
+	case 48: // At Particle Cap
+		g.settings.evictOldest = !g.settings.evictOldest
+	case 49: // Spawn Temperature
+		g.settings.spawnTemperature = clampTemperature(g.settings.spawnTemperature + change*100)
+	case 50: // Boil Temperature
+		g.settings.boilTemperature = clampTemperature(g.settings.boilTemperature + change*100)
+	case 51: // Condense Temperature
+		g.settings.condenseTemperature = clampTemperature(g.settings.condenseTemperature + change*100)
+	case 52: // Phase Change Rate
+		g.settings.phaseRate = clampPhaseRate(g.settings.phaseRate + change)
+	case 53: // GIF Capture FPS
+		delta := 1
+		if my < 0 {
+			delta = -1
+		}
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 5
+		}
+		g.settings.gifFPS = clampGIFFPS(g.settings.gifFPS + delta)
+	case 54: // Screenshot
+		g.settings.screenshotFull = !g.settings.screenshotFull
+	case 55: // Ticks Per Second
+		delta := 5
+		if my < 0 {
+			delta = -5
+		}
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 4
+		}
+		g.settings.tps = clampTPS(g.settings.tps + delta)
+	case 56: // Slow Motion
+		g.settings.timeScale = clampTimeScale(g.settings.timeScale + change*5)
+	case 57: // Update Channel
+		if channelName(g.updateChannel) == channelBeta {
+			g.updateChannel = channelStable
+		} else {
+			g.updateChannel = channelBeta
+		}
+		state, _ := loadAppState()
+		state.Channel = g.updateChannel
+		if err := saveAppState(state); err != nil {
+			g.updateMessage = fmt.Sprintf("Save state failed: %v", err)
+		}
+		g.updateAvailable = false
+	case 58: // Antialiasing
+		g.settings.antialias = !g.settings.antialias
+	case 59: // Background
+		i := backgroundIndex(g.settings.background)
+		if my > 0 {
+			i = (i + 1) % len(backgrounds)
+		} else {
+			i = (max(i, 0) + len(backgrounds) - 1) % len(backgrounds)
+		}
+		g.settings.background = backgrounds[i].color
+	case 60: // Wind Strength
+		g.settings.windStrength = clampWindStrength(g.settings.windStrength + change)
+	case 61: // Wind Angle
+		step := float32(my) * 5
+		if keyPressed(ebiten.KeyShift) {
+			step *= 3
+		}
+		g.settings.windAngle = wrapAngle(g.settings.windAngle + step)
+	case 62: // Vortex Strength
+		g.settings.vortexStrength = clampVortexStrength(g.settings.vortexStrength + change*10)
+	case 63: // Well Strength
+		g.settings.wellStrength = clampWellStrength(g.settings.wellStrength + change*100)
+	case 64: // Water Surface Tension
+		g.settings.waterSurfaceTension = clampSurfaceTension(g.settings.waterSurfaceTension + change)
+	case 65: // Swept Collisions
+		g.settings.sweptCollisions = !g.settings.sweptCollisions
+	case 66, 67, 68, 69, 70, 71, 72, 73: // Solid/Water/Gas/Sand Radius Min and Max
+		row := g.selectedOption - 66
+		lo, hi := g.settings.radiusRange(radiusMaterials[row/2].material)
+		if row%2 == 0 {
+			*lo = clampRadius(*lo+change*10, minSpawnRadius, *hi)
+		} else {
+			*hi = clampRadius(*hi+change*10, *lo, maxSpawnRadius)
+		}
+	case 74: // Move Attract Distance
+		moveAttractDistance = math.Max(minAttractDistance, moveAttractDistance+float64(change)*10)
+	case 75: // Gas Lifetime
+		delta := 30
+		if my < 0 {
+			delta = -30
+		}
+		if keyPressed(ebiten.KeyShift) {
+			delta *= 10
+		}
+		g.settings.gasLifetime = clampGasLifetime(g.settings.gasLifetime + delta)
+	case 76: // Load Preset
+		if my < 0 {
+			g.presetChoice = (g.presetChoice + 1) % len(scenePresets)
+			break
+		}
+		p := scenePresets[g.presetChoice]
+		if err := g.loadPreset(p.name); err != nil {
+			g.updateMessage = fmt.Sprintf("Preset not loaded: %v", err)
+		} else {
+			g.updateMessage = fmt.Sprintf("Loaded the %s preset", p.label)
+		}
+	case 77: // Reset Settings
+		if my > 0 {
+			g.settings = defaultSettings()
+			g.spawnClusterCount = defaultSpawnClusterCount
+			g.updateMessage = "Settings reset to defaults"
+		}
+	case 78: // Exit
+		if my > 0 {
+			return ebiten.Termination
+		}
+	}
+	return nil
+}
+
+// menuRow is where Draw put one menu option on screen.
+type menuRow struct {
+	option int
+	rect   image.Rectangle
+}
+
+// menuDragStep is how far a drag on a menu row travels for one wheel step.
+const menuDragStep = 8
+
+// menuOptionClickable reports whether a plain click triggers the option.
+// These rows are toggles, cycles and actions; the rest are adjusted by
+// dragging.
+func menuOptionClickable(option int) bool {
+	switch option {
+	case 10, 12, 13, 18, 20, 24, 29, 39, 40, 41, 42, 45, 48, 54, 57, 58, 59, 65, 76, 77, 78:
+		return true
+	}
+	return false
+}
+
+// updateMenuMouse lets the mouse drive the menu. Pressing on a row selects
+// it, dragging left or right adjusts it like the wheel, and releasing a click
+// that did not drag triggers toggle and action rows.
+func (g *Game) updateMenuMouse() error {
+	x, y := cursorPosition()
+	g.menuHover = -1
+	for _, row := range g.menuRows {
+		if image.Pt(x, y).In(row.rect) {
+			g.menuHover = row.option
+			break
+		}
+	}
+
+	click := mousePressed(ebiten.MouseButtonLeft)
+	wasClick := g.prevMenuClick
+	g.prevMenuClick = click
+	switch {
+	case click && !wasClick:
+		g.menuDragging = g.menuHover >= 0
+		if g.menuDragging {
+			g.selectedOption = g.menuHover
+			g.menuDragX = x
+			g.menuDragged = false
+		}
+	case click && g.menuDragging:
+		if menuOptionClickable(g.selectedOption) {
+			return nil
+		}
+		if steps := (x - g.menuDragX) / menuDragStep; steps != 0 {
+			g.menuDragX += steps * menuDragStep
+			g.menuDragged = true
+			return g.adjustMenuOption(float64(steps))
+		}
+	case !click && g.menuDragging:
+		g.menuDragging = false
+		if !g.menuDragged && g.menuHover == g.selectedOption && menuOptionClickable(g.selectedOption) {
+			return g.adjustMenuOption(1)
+		}
+	}
+	return nil
+}
+
 func (g *Game) Update() error {
+	g.readInput()
+	// API requests and stdin commands run here, between steps, even while the
+	// menu pauses physics.
+	if g.api != nil {
+		g.api.run(g)
+	}
+	if g.commands != nil {
+		g.commands.run(g)
+	}
+
 	// Toggle menu with ESC
-	escPressed := ebiten.IsKeyPressed(ebiten.KeyEscape)
+	escPressed := keyPressed(ebiten.KeyEscape)
 	if escPressed && !g.prevEscPressed {
 		g.showMenu = !g.showMenu
+		if !g.showMenu {
+			if err := saveConfig(g); err != nil {
+				g.updateMessage = fmt.Sprintf("Config not saved: %v", err)
+			}
+		}
 	}
 	g.prevEscPressed = escPressed
 
+	// Tab hides every overlay for a clean view of the particles
+	hudPressed := keyPressed(ebiten.KeyTab)
+	if hudPressed && !g.prevHUDPressed {
+		g.hideHUD = !g.hideHUD
+	}
+	g.prevHUDPressed = hudPressed
+
+	ebiten.SetScreenClearedEveryFrame(g.settings.renderSkip == 0)
+	if ebiten.TPS() != g.settings.tps {
+		ebiten.SetTPS(g.settings.tps)
+	}
+
+	if g.tutorialStep >= 0 {
+		g.updateTutorial()
+	} else if len(g.balls) == 0 {
+		dismissPressed := keyPressed(ebiten.KeyEnter)
+		if dismissPressed && !g.prevSkipPressed {
+			g.idleHintDismissed = true
+		}
+		g.prevSkipPressed = dismissPressed
+	}
+
 	// Handle menu navigation
 	if g.showMenu {
-		upPressed := ebiten.IsKeyPressed(ebiten.KeyUp)
-		downPressed := ebiten.IsKeyPressed(ebiten.KeyDown)
+		upPressed := keyPressed(ebiten.KeyUp)
+		downPressed := keyPressed(ebiten.KeyDown)
 
 		if upPressed && !g.prevUpPressed {
 			g.selectedOption--
@@ -651,132 +4107,305 @@ func (g *Game) Update() error {
 		g.prevUpPressed = upPressed
 		g.prevDownPressed = downPressed
 
+		if err := g.updateMenuMouse(); err != nil {
+			return err
+		}
+
 		// Adjust selected setting
-		_, my := ebiten.Wheel()
-		changeAmount := float32(0.01)
-		if ebiten.IsKeyPressed(ebiten.KeyShift) {
-			changeAmount = 0.1
+		_, my := wheel()
+		if err := g.adjustMenuOption(my); err != nil {
+			return err
 		}
 
-		if my != 0 {
-			change := float32(my) * changeAmount
-			switch g.selectedOption {
-			case 0: // Gravity
-				g.settings.gravity = float32(math.Max(0, float64(g.settings.gravity+change)))
-			case 1: // Max Speed
-				g.settings.maxSpeed = float32(math.Max(0.1, float64(g.settings.maxSpeed+change)))
-			case 2: // Move Away Distance
-				g.settings.moveAwayDistance = float32(math.Max(10, float64(g.settings.moveAwayDistance+change*10)))
-			case 3: // Move Away Strength
-				g.settings.moveAwayStrength = float32(math.Max(0.1, float64(g.settings.moveAwayStrength+change)))
-			case 4: // Move Attract Strength
-				g.settings.moveAttractStrength = float32(math.Max(0.1, float64(g.settings.moveAttractStrength+change)))
-			case 5: // Ground Restitution
-				g.settings.groundRestitution = float32(math.Min(1, math.Max(0, float64(g.settings.groundRestitution+change))))
-			case 6: // Collision Restitution
-				g.settings.collisionRestitution = float32(math.Min(1, math.Max(0, float64(g.settings.collisionRestitution+change))))
-			case 7: // Air Drag
-				g.settings.airDrag = float32(math.Min(1, math.Max(0, float64(g.settings.airDrag+change))))
-			case 8: // Ground Friction
-				g.settings.groundFriction = float32(math.Min(1, math.Max(0, float64(g.settings.groundFriction+change))))
-			case 9: // Spawn Count
-				delta := int(my)
-				if ebiten.IsKeyPressed(ebiten.KeyShift) {
-					delta *= 5
-				}
-				g.spawnClusterCount += delta
-				if g.spawnClusterCount < 1 {
-					g.spawnClusterCount = 1
-				}
-				if g.spawnClusterCount > 50 {
-					g.spawnClusterCount = 50
-				}
-			case 10: // Top Barrier
-				if my != 0 {
-					g.settings.hasTopBarrier = !g.settings.hasTopBarrier
+		return nil // Don't update physics when menu is open
+	}
+
+	// Save/Load scene (no file dialog; uses working directory)
+	ctrlDown := keyPressed(ebiten.KeyControl) || keyPressed(ebiten.KeyMeta)
+	shiftDown := keyPressed(ebiten.KeyShift)
+	savePressed := ctrlDown && keyPressed(ebiten.KeyS)
+	loadPressed := ctrlDown && keyPressed(ebiten.KeyO)
+
+	if savePressed && !g.prevSavePressed {
+		if err := saveSceneToFile(defaultSceneFileName, g); err != nil {
+			g.updateMessage = fmt.Sprintf("Save failed: %v", err)
+		} else {
+			g.updateMessage = fmt.Sprintf("Saved: %s", defaultSceneFileName)
+		}
+	}
+	if loadPressed && !g.prevLoadPressed {
+		if err := loadSceneFromFile(defaultSceneFileName, g); err != nil {
+			g.updateMessage = fmt.Sprintf("Load failed: %v", err)
+		} else {
+			g.updateMessage = fmt.Sprintf("Loaded: %s", defaultSceneFileName)
+		}
+	}
+	g.prevSavePressed = savePressed
+	g.prevLoadPressed = loadPressed
+
+	// F5 quick-saves and F9 quick-loads the same file
+	quickSavePressed := keyPressed(ebiten.KeyF5)
+	quickLoadPressed := keyPressed(ebiten.KeyF9)
+	if quickSavePressed && !g.prevQuickSave {
+		if err := g.SaveScene(defaultSceneFileName); err != nil {
+			g.updateMessage = fmt.Sprintf("Save failed: %v", err)
+		} else {
+			g.updateMessage = fmt.Sprintf("Saved: %s", defaultSceneFileName)
+		}
+	}
+	if quickLoadPressed && !g.prevQuickLoad {
+		if err := g.LoadScene(defaultSceneFileName); err != nil {
+			g.updateMessage = fmt.Sprintf("Load failed: %v", err)
+		} else {
+			g.updateMessage = fmt.Sprintf("Loaded: %s", defaultSceneFileName)
+		}
+	}
+	g.prevQuickSave = quickSavePressed
+	g.prevQuickLoad = quickLoadPressed
+
+	// Slots: Ctrl+1..9 loads; Ctrl+Shift+1..9 saves
+	slotKeys := [...]ebiten.Key{
+		ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4, ebiten.Key5,
+		ebiten.Key6, ebiten.Key7, ebiten.Key8, ebiten.Key9,
+	}
+	for i, key := range slotKeys {
+		pressed := ctrlDown && keyPressed(key)
+		if pressed && !g.prevSlotPressed[i] {
+			slot := i + 1
+			filename := sceneSlotFileName(slot)
+			if shiftDown {
+				if err := saveSceneToFile(filename, g); err != nil {
+					g.updateMessage = fmt.Sprintf("Save slot %d failed: %v", slot, err)
+				} else {
+					g.updateMessage = fmt.Sprintf("Saved slot %d", slot)
 				}
-			case 11: // Exit
-				if my > 0 {
-					return ebiten.Termination
+			} else {
+				if err := loadSceneFromFile(filename, g); err != nil {
+					g.updateMessage = fmt.Sprintf("Load slot %d failed: %v", slot, err)
+				} else {
+					g.updateMessage = fmt.Sprintf("Loaded slot %d", slot)
 				}
 			}
 		}
+		g.prevSlotPressed[i] = pressed
+	}
+
+	// Inspector: middle click selects the particle under the cursor, I toggles force arrows
+	middlePressed := mousePressed(ebiten.MouseButtonMiddle)
+	if middlePressed && !g.prevMiddlePressed {
+		x, y := cursorPosition()
+		g.selected = g.pickBall(float32(x), float32(y))
+		if g.selected >= 0 && g.balls[g.selected].body > 0 {
+			g.grabbedBucket = g.balls[g.selected].body - 1
+			bucket := g.buckets[g.grabbedBucket]
+			g.grabOffset = Pos{x: float32(x) - bucket.pos.x, y: float32(y) - bucket.pos.y}
+		}
+	}
+	if !middlePressed {
+		g.grabbedBucket = -1
+	}
+	g.prevMiddlePressed = middlePressed
 
-		return nil // Don't update physics when menu is open
+	// Buckets: U places one at the cursor, middle-drag carries it, Q/E tilt it while held
+	bucketPressed := keyPressed(ebiten.KeyU)
+	if bucketPressed && !g.prevBucketPressed {
+		x, y := cursorPosition()
+		g.spawnBucket(createPos(float32(x), float32(y)))
+	}
+	g.prevBucketPressed = bucketPressed
+	if g.grabbedBucket >= 0 && g.grabbedBucket < len(g.buckets) {
+		x, y := cursorPosition()
+		bucket := &g.buckets[g.grabbedBucket]
+		bucket.pos = Pos{x: float32(x) - g.grabOffset.x, y: float32(y) - g.grabOffset.y}
+		if keyPressed(ebiten.KeyQ) {
+			bucket.angle -= bucketTiltPerFrame
+		}
+		if keyPressed(ebiten.KeyE) {
+			bucket.angle += bucketTiltPerFrame
+		}
 	}
+	g.updateBuckets()
+	g.updateEmitterTool()
+	g.updateWellTool()
+	g.updateFreezeTool()
+
+	tempPressed := keyPressed(ebiten.KeyT)
+	if tempPressed && !g.prevTempPressed {
+		g.showTemperature = !g.showTemperature
+	}
+	g.prevTempPressed = tempPressed
 
-	// Save/Load scene (no file dialog; uses working directory)
-	ctrlDown := ebiten.IsKeyPressed(ebiten.KeyControl) || ebiten.IsKeyPressed(ebiten.KeyMeta)
-	shiftDown := ebiten.IsKeyPressed(ebiten.KeyShift)
-	savePressed := ctrlDown && ebiten.IsKeyPressed(ebiten.KeyS)
-	loadPressed := ctrlDown && ebiten.IsKeyPressed(ebiten.KeyO)
+	velPressed := keyPressed(ebiten.KeyV)
+	if velPressed && !g.prevVelPressed {
+		g.showVelocities = !g.showVelocities
+	}
+	g.prevVelPressed = velPressed
+
+	densityPressed := keyPressed(ebiten.KeyH)
+	if densityPressed && !g.prevDensityPressed {
+		g.showDensity = !g.showDensity
+	}
+	g.prevDensityPressed = densityPressed
+
+	gasShadePressed := keyPressed(ebiten.KeyD)
+	if gasShadePressed && !g.prevGasShadePress {
+		g.gasShading = !g.gasShading
+		g.updateMessage = fmt.Sprintf("Gas density shading %s", onOff(g.gasShading))
+	}
+	g.prevGasShadePress = gasShadePressed
+
+	exactPressed := keyPressed(ebiten.KeyJ)
+	if exactPressed && !g.prevExactPress {
+		g.exactPositions = !g.exactPositions
+		g.updateMessage = fmt.Sprintf("Render interpolation %s", onOff(!g.exactPositions))
+	}
+	g.prevExactPress = exactPressed
+
+	layerPressed := keyPressed(ebiten.KeyN)
+	if layerPressed && !g.prevLayerPressed {
+		g.spawnLayer = (g.spawnLayer + 1) % spawnLayers
+		if g.spawnLayer == 0 {
+			g.updateMessage = "Spawn layer 0 (shared, touches every layer)"
+		} else {
+			g.updateMessage = fmt.Sprintf("Spawn layer %d (touches layers %d and 0)", g.spawnLayer, g.spawnLayer)
+		}
+	}
+	g.prevLayerPressed = layerPressed
+
+	gridPressed := keyPressed(ebiten.KeyF3)
+	if gridPressed && !g.prevGridPressed {
+		g.showGrid = !g.showGrid
+	}
+	g.prevGridPressed = gridPressed
+
+	windPressed := keyPressed(ebiten.KeyW)
+	if windPressed && !g.prevWindPressed {
+		g.settings.windOn = !g.settings.windOn
+		g.updateMessage = fmt.Sprintf("Wind %s", onOff(g.settings.windOn))
+	}
+	g.prevWindPressed = windPressed
+
+	energyPressed := keyPressed(ebiten.KeyK)
+	if energyPressed && !g.prevEnergyPressed {
+		g.showEnergy = !g.showEnergy
+	}
+	g.prevEnergyPressed = energyPressed
+
+	histPressed := keyPressed(ebiten.KeyF4)
+	if histPressed && !g.prevHistPressed {
+		g.showHistogram = !g.showHistogram
+	}
+	g.prevHistPressed = histPressed
+
+	metaPressed := keyPressed(ebiten.KeyM)
+	if metaPressed && !g.prevMetaPressed {
+		g.metaballs = !g.metaballs
+	}
+	g.prevMetaPressed = metaPressed
+
+	axesPressed := keyPressed(ebiten.KeyF2)
+	if axesPressed && !g.prevAxesPressed {
+		g.showAxes = !g.showAxes
+	}
+	g.prevAxesPressed = axesPressed
 
-	if savePressed && !g.prevSavePressed {
-		if err := saveSceneToFile(defaultSceneFileName, g); err != nil {
-			g.updateMessage = fmt.Sprintf("Save failed: %v", err)
+	gifPressed := keyPressed(ebiten.KeyF10)
+	if gifPressed && !g.prevGIFPressed {
+		if g.gifRecording {
+			g.finishGIF()
 		} else {
-			g.updateMessage = fmt.Sprintf("Saved: %s", defaultSceneFileName)
+			g.gifRecording = true
+			g.gifTick = 0
+			g.gifFrames = make([]*image.Paletted, 0, g.settings.gifFrameCount)
 		}
 	}
-	if loadPressed && !g.prevLoadPressed {
-		if err := loadSceneFromFile(defaultSceneFileName, g); err != nil {
-			g.updateMessage = fmt.Sprintf("Load failed: %v", err)
-		} else {
-			g.updateMessage = fmt.Sprintf("Loaded: %s", defaultSceneFileName)
+	g.prevGIFPressed = gifPressed
+	if g.gifRecording {
+		if g.gifTick%gifCaptureInterval(g.settings.gifFPS) == 0 {
+			g.captureGIFFrame()
 		}
+		g.gifTick++
 	}
-	g.prevSavePressed = savePressed
-	g.prevLoadPressed = loadPressed
 
-	// Slots: Ctrl+1..9 loads; Ctrl+Shift+1..9 saves
-	slotKeys := [...]ebiten.Key{
-		ebiten.Key1, ebiten.Key2, ebiten.Key3, ebiten.Key4, ebiten.Key5,
-		ebiten.Key6, ebiten.Key7, ebiten.Key8, ebiten.Key9,
+	shotPressed := keyPressed(ebiten.KeyF12)
+	if shotPressed && !g.prevShotPressed {
+		g.shotPending = true
 	}
-	for i, key := range slotKeys {
-		pressed := ctrlDown && ebiten.IsKeyPressed(key)
-		if pressed && !g.prevSlotPressed[i] {
-			slot := i + 1
-			filename := sceneSlotFileName(slot)
-			if shiftDown {
-				if err := saveSceneToFile(filename, g); err != nil {
-					g.updateMessage = fmt.Sprintf("Save slot %d failed: %v", slot, err)
-				} else {
-					g.updateMessage = fmt.Sprintf("Saved slot %d", slot)
-				}
-			} else {
-				if err := loadSceneFromFile(filename, g); err != nil {
-					g.updateMessage = fmt.Sprintf("Load slot %d failed: %v", slot, err)
-				} else {
-					g.updateMessage = fmt.Sprintf("Loaded slot %d", slot)
-				}
-			}
+	g.prevShotPressed = shotPressed
+
+	reversePressed := keyPressed(ebiten.KeyR)
+	if reversePressed && !g.prevReversePressed {
+		g.reverseTime()
+		g.updateMessage = "Time reversed (approximate)"
+	}
+	g.prevReversePressed = reversePressed
+
+	// Ctrl + '+' / Ctrl + '-' grow or shrink the whole scene
+	scaleUp := ctrlDown && (keyPressed(ebiten.KeyEqual) || keyPressed(ebiten.KeyNumpadAdd))
+	scaleDown := ctrlDown && (keyPressed(ebiten.KeyMinus) || keyPressed(ebiten.KeyNumpadSubtract))
+	if (scaleUp || scaleDown) && !g.prevScalePressed {
+		factor := float32(sceneScaleStep)
+		if scaleDown {
+			factor = 1 / factor
 		}
-		g.prevSlotPressed[i] = pressed
+		g.scaleScene(factor)
+		g.updateMessage = fmt.Sprintf("Scaled scene by %.2fx", factor)
+	}
+	g.prevScalePressed = scaleUp || scaleDown
+
+	clearPressed := keyPressed(ebiten.KeyDelete)
+	if clearPressed && !g.prevClearPressed {
+		g.clearScene()
+		g.updateMessage = "Cleared all particles"
+	}
+	g.prevClearPressed = clearPressed
+
+	// Space pauses; while paused, '.' advances a single step
+	pausePressed := keyPressed(ebiten.KeySpace)
+	if pausePressed && !g.prevPausePressed {
+		g.paused = !g.paused
 	}
+	g.prevPausePressed = pausePressed
+	stepPressed := keyPressed(ebiten.KeyPeriod)
+	advance := !g.paused || (stepPressed && !g.prevStepPressed)
+	g.prevStepPressed = stepPressed
+	forcesPressed := keyPressed(ebiten.KeyI)
+	if forcesPressed && !g.prevForcesPressed && g.selected >= 0 {
+		g.showForces = !g.showForces
+	}
+	g.prevForcesPressed = forcesPressed
+	if g.selected >= len(g.balls) {
+		g.selected = -1
+	}
+	g.forces = forceProbe{}
+	g.probed = g.selected
 
 	// Shape selection with number keys
-	if ebiten.IsKeyPressed(ebiten.Key1) {
+	if keyPressed(ebiten.Key1) {
 		currentShape = ShapeCircle
-	} else if ebiten.IsKeyPressed(ebiten.Key2) {
+	} else if keyPressed(ebiten.Key2) {
 		currentShape = ShapeSquare
-	} else if ebiten.IsKeyPressed(ebiten.Key3) {
+	} else if keyPressed(ebiten.Key3) {
 		currentShape = ShapeTriangle
-	} else if ebiten.IsKeyPressed(ebiten.Key4) {
+	} else if keyPressed(ebiten.Key4) {
 		currentShape = ShapeWater
-	} else if ebiten.IsKeyPressed(ebiten.Key5) {
+	} else if keyPressed(ebiten.Key5) {
 		currentShape = ShapeGas
-	} else if ebiten.IsKeyPressed(ebiten.Key6) {
+	} else if keyPressed(ebiten.Key6) {
 		currentShape = ShapeStatic
+	} else if keyPressed(ebiten.Key7) {
+		currentShape = ShapeGrate
+	} else if keyPressed(ebiten.Key8) {
+		currentShape = ShapeSand
 	}
 
-	_, my := ebiten.Wheel()
+	_, my := wheel()
 
-	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+	if keyPressed(ebiten.KeyShift) {
 		if my < 0 {
 			moveAttractDistance += 2
 		} else if my > 0 {
-			moveAttractDistance -= 2
+			moveAttractDistance = math.Max(minAttractDistance, moveAttractDistance-2)
 		}
 	} else {
 		if my < 0 {
@@ -789,59 +4418,82 @@ func (g *Game) Update() error {
 		ballsize = math.Max(math.Min(ballsize, float64(maxSpawnRadius)), float64(minSpawnRadius))
 	}
 
-	// Handle update button click
-	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) && g.updateButtonHover && !g.updateChecking {
+	select {
+	case res := <-g.updateResult:
+		g.finishUpdateCheck(res)
+	default:
+	}
+	select {
+	case msg := <-g.fileMessages:
+		g.updateMessage = msg
+	default:
+	}
+
+	// Handle update button click; clicking again while checking cancels.
+	buttonClick := mousePressed(ebiten.MouseButtonLeft) && g.updateButtonHover
+	if buttonClick && !g.prevButtonClick && g.updateChecking && g.updateCancel != nil {
+		g.updateCancel()
+	} else if buttonClick && !g.prevButtonClick && !g.updateChecking {
 		g.updateChecking = true
 		g.updateMessage = ""
+		ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+		g.updateCancel = cancel
+		results := make(chan updateCheckResult, 1)
+		g.updateResult = results
+		channel := g.updateChannel
 		go func() {
-			release, err := checkForUpdates()
-			if err != nil {
-				g.updateMessage = fmt.Sprintf("Error: %v", err)
-				g.updateChecking = false
-				return
-			}
-			if release == nil {
-				g.updateMessage = fmt.Sprintf("Up to date! (%s)", version)
-				g.updateAvailable = false
-			} else {
-				g.updateMessage = fmt.Sprintf("New version: %s", release.TagName)
-				g.updateAvailable = true
-			}
-			g.updateChecking = false
+			defer cancel()
+			release, err := checkForUpdates(ctx, channel)
+			results <- updateCheckResult{release: release, err: err}
 		}()
 	}
 
-	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
-		x, y := ebiten.CursorPosition()
+	g.prevButtonClick = buttonClick
+
+	zoneTool := g.updateZoneTool()
+	polyTool := g.updatePolygonTool()
+	wallTool := g.updateWallTool()
+	blockTool := g.updateBlockTool()
+	cannonTool := g.updateCannonTool()
+	g.spawnThrottled = false
+
+	if mousePressed(ebiten.MouseButtonLeft) && !zoneTool && !polyTool && !wallTool && !blockTool && !cannonTool {
+		x, y := cursorPosition()
 
-		if ebiten.IsKeyPressed(ebiten.KeyShift) {
-			for i := len(balls) - 1; i >= 0; i-- {
-				dx := balls[i].pos.x - float32(x)
-				dy := balls[i].pos.y - float32(y)
+		if keyPressed(ebiten.KeyAlt) {
+			g.paintStatic(float32(x), float32(y), !keyPressed(ebiten.KeyShift))
+		} else if keyPressed(ebiten.KeyControl) || keyPressed(ebiten.KeyMeta) {
+			for i := len(g.balls) - 1; i >= 0; i-- {
+				dx := g.balls[i].pos.x - float32(x)
+				dy := g.balls[i].pos.y - float32(y)
 				distSq := dx*dx + dy*dy
 
-				radiusCheck := balls[i].radius + 15
+				radiusCheck := g.balls[i].radius + 15
 				if distSq < radiusCheck*radiusCheck {
-					balls = append(balls[:i], balls[i+1:]...)
+					g.removeParticle(i)
 				}
 			}
-		} else if ballSpawnTimer <= 0 {
+		} else if ballSpawnTimer <= 0 && g.spawnCooldownLeft <= 0 {
 			count := g.spawnClusterCount
 			if count < 1 {
 				count = 1
 			}
-			clampSolid := func(size float64) float32 {
-				return float32(math.Min(math.Max(size, float64(minSpawnRadius)), float64(maxSpawnRadius)))
-			}
-			clampWater := func(size float64) float32 {
-				return float32(math.Min(math.Max(size, float64(waterSpawnClampMin)), float64(waterSpawnClampMax)))
+			if g.settings.spawnRateLimit > 0 {
+				if available := int(g.spawnTokens); count > available {
+					count = available
+					g.spawnThrottled = true
+				}
 			}
-			clampGas := func(size float64) float32 {
-				return float32(math.Min(math.Max(size, float64(gasSpawnClampMin)), float64(gasSpawnClampMax)))
+			count = g.makeRoom(count)
+			if g.settings.spawnRateLimit > 0 {
+				g.spawnTokens -= float32(count)
+				if count >= largeSpawnBatch {
+					g.spawnCooldownLeft = g.settings.spawnCooldown
+				}
 			}
-			baseSolid := clampSolid(ballsize)
-			baseWater := clampWater(ballsize)
-			baseGas := clampGas(ballsize)
+			baseSolid := g.settings.spawnRadius(ShapeCircle, ballsize)
+			baseWater := g.settings.spawnRadius(ShapeWater, ballsize)
+			baseGas := g.settings.spawnRadius(ShapeGas, ballsize)
 			for n := 0; n < count; n++ {
 				angle := 0.0
 				if count > 1 {
@@ -860,17 +4512,19 @@ func (g *Game) Update() error {
 				}
 				offsetX := float32(math.Cos(angle)) * offsetScale
 				offsetY := float32(math.Sin(angle)) * offsetScale
-				pos := createPos(float32(x)+offsetX, float32(y)+offsetY)
-				switch currentShape {
-				case ShapeWater:
-					balls = append(balls, createWaterParticle(pos, baseWater))
-				case ShapeGas:
-					balls = append(balls, createGasParticle(pos, baseGas))
-				case ShapeStatic:
-					balls = append(balls, createStaticSolid(pos, baseSolid, ShapeStatic))
-				default:
-					balls = append(balls, createBall(pos, baseSolid, currentShape))
+				if g.spawnScatter {
+					// Uniform over the brush disc: sqrt keeps the density even toward the rim.
+					r := g.scatterRadius * float32(math.Sqrt(g.rng.Float64()))
+					theta := 2 * math.Pi * g.rng.Float64()
+					offsetX = float32(math.Cos(theta)) * r
+					offsetY = float32(math.Sin(theta)) * r
 				}
+				pos := createPos(float32(x)+offsetX, float32(y)+offsetY)
+				b := createParticle(currentShape, pos, g.settings.spawnRadius(currentShape, ballsize))
+				b.mass *= g.settings.spawnMass
+				b.temperature = g.settings.spawnTemperature
+				b.layer = g.spawnLayer
+				g.addParticle(b)
 			}
 			ballSpawnTimer = 3 // Spawn every 3 frames (20 times per second at 60 FPS)
 		}
@@ -879,319 +4533,572 @@ func (g *Game) Update() error {
 	if ballSpawnTimer > 0 {
 		ballSpawnTimer--
 	}
+	g.refillSpawnBudget()
+	steps := g.physicsSteps(advance)
+	if steps > 0 {
+		g.updateCannons()
+		g.updateEmitters()
+	}
 
-	if ebiten.IsMouseButtonPressed(ebiten.MouseButtonRight) {
-		x, y := ebiten.CursorPosition()
+	if mousePressed(ebiten.MouseButtonRight) {
+		x, y := cursorPosition()
 		mousePos := createPos(float32(x), float32(y))
 
-		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		if keyPressed(ebiten.KeyControl) || keyPressed(ebiten.KeyMeta) {
+			g.applyVortex(mousePos, keyPressed(ebiten.KeyShift))
+		} else if keyPressed(ebiten.KeyShift) {
 			attractDistSq := float32(moveAttractDistance * moveAttractDistance)
-			for i := range balls {
-				dx := balls[i].pos.x - mousePos.x
-				dy := balls[i].pos.y - mousePos.y
+			for i := range g.balls {
+				dx := g.balls[i].pos.x - mousePos.x
+				dy := g.balls[i].pos.y - mousePos.y
 				distSq := dx*dx + dy*dy
 
 				if distSq < attractDistSq {
 					nx, ny, _ := normalize(dx, dy)
-					balls[i].velocity.vx -= nx * g.settings.moveAttractStrength
-					balls[i].velocity.vy -= ny * g.settings.moveAttractStrength
+					g.balls[i].velocity.vx -= nx * g.settings.moveAttractStrength
+					g.balls[i].velocity.vy -= ny * g.settings.moveAttractStrength
+					g.probe(&g.forces.tool, i, -nx*g.settings.moveAttractStrength, -ny*g.settings.moveAttractStrength)
 				}
 			}
 		} else {
 			moveAwayDistSq := g.settings.moveAwayDistance * g.settings.moveAwayDistance
-			for i := range balls {
-				dx := balls[i].pos.x - mousePos.x
-				dy := balls[i].pos.y - mousePos.y
+			for i := range g.balls {
+				dx := g.balls[i].pos.x - mousePos.x
+				dy := g.balls[i].pos.y - mousePos.y
 				distSq := dx*dx + dy*dy
 
 				if distSq < moveAwayDistSq {
 					nx, ny, _ := normalize(dx, dy)
-					balls[i].velocity.vx += nx * g.settings.moveAwayStrength
-					balls[i].velocity.vy += ny * g.settings.moveAwayStrength
+					g.balls[i].velocity.vx += nx * g.settings.moveAwayStrength
+					g.balls[i].velocity.vy += ny * g.settings.moveAwayStrength
+					g.probe(&g.forces.tool, i, nx*g.settings.moveAwayStrength, ny*g.settings.moveAwayStrength)
 				}
 			}
 		}
 	}
 
-	g.applyWaterForces()
-	g.applyGasForces()
-
-	dragFactor := 1 - g.settings.airDrag
-	bottomLimit := float32(screenHeight) - screenPadding
-	rightLimit := float32(screenWidth)
+	if cap(g.balls) > autoCompactMinCap && cap(g.balls) > autoCompactRatio*len(g.balls) {
+		g.updateMessage = g.compact()
+	}
 
-	for i := range balls {
-		if balls[i].material == MaterialStatic {
-			continue
+	for n := range steps {
+		if n > 0 {
+			g.updateCannons()
+			g.updateEmitters()
 		}
-		balls[i].velocity.vy += g.settings.gravity
-		balls[i].velocity.vx *= dragFactor
-		balls[i].velocity.vy *= dragFactor
-
-		speedSq := balls[i].speedSquared()
-		if speedSq > g.settings.maxSpeed*g.settings.maxSpeed {
-			speed := float32(math.Sqrt(float64(speedSq)))
-			scale := g.settings.maxSpeed / speed
-			balls[i].velocity.vx *= scale
-			balls[i].velocity.vy *= scale
+		if n == steps-1 {
+			g.renderPrev = g.renderPrev[:0]
+			for i := range g.balls {
+				g.renderPrev = append(g.renderPrev, renderSample{seq: g.balls[i].seq, pos: g.balls[i].pos})
+			}
 		}
+		g.Step(g.stepDT())
+		// Step can remove expired gas; it keeps probed on the selected particle.
+		g.selected = g.probed
+		g.steps++
+		if g.stream != nil {
+			g.stream.publish(g.steps, g.width, g.height, g.balls)
+		}
+		if g.csv != nil {
+			if err := g.csv.write(g.steps, g.balls); err != nil {
+				g.updateMessage = fmt.Sprintf("CSV export stopped: %v", err)
+				g.csv.Close()
+				g.csv = nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Material densities relative to water, used by buoyancy and to size the
+// bubble lift. Air fills whatever space no other fluid does. Under the
+// default gravity of 0.2, gas in open air is lifted by 0.25 per tick, a net
+// rise of 0.05.
+const (
+	waterMaterialDensity = float32(1.0)
+	gasMaterialDensity   = float32(0.01)
+	airMaterialDensity   = float32(0.0125)
+	buoyancyReach        = gasInteraction // Must not exceed any fluid's cell size
+	maxBuoyancy          = float32(0.6)   // Per tick, so a bubble deep in water doesn't shoot out
+)
+
+// materialDensity is the density buoyancy uses for m. Anything that isn't a
+// fluid counts as air.
+func materialDensity(m MaterialType) float32 {
+	switch m {
+	case MaterialWater:
+		return waterMaterialDensity
+	case MaterialGas:
+		return gasMaterialDensity
+	}
+	return airMaterialDensity
+}
 
-		balls[i].pos.x += balls[i].velocity.vx
-		balls[i].pos.y += balls[i].velocity.vy
+// applyBuoyancy pushes every fluid particle against gravity by |g| times the
+// density of its surroundings over its own: the share of its weight the fluid
+// it displaces carries. The surroundings are the other fluids within
+// buoyancyReach, weighted by closeness, topped up with air. Particles of the
+// same fluid are left out since its pressure already holds them up. It reads
+// the fluid colliders, so it runs after applyWaterForces and applyGasForces.
+func (s *Simulation) applyBuoyancy(ticks float32) {
+	if len(s.balls) == 0 {
+		return
+	}
+	s.buoyFluid(s.waterIndices, MaterialWater, &s.gasCollider, ticks)
+	s.buoyFluid(s.gasIndices, MaterialGas, &s.waterCollider, ticks)
+}
 
-		// Top barrier (optional)
-		if g.settings.hasTopBarrier {
-			topLimit := screenPadding
-			if balls[i].pos.y-balls[i].radius < topLimit {
-				balls[i].pos.y = topLimit + balls[i].radius
-				balls[i].velocity.vy *= -g.settings.groundRestitution
+// buoyFluid applies buoyancy to the particles at indices, all of material,
+// sampling the other fluid from others.
+func (s *Simulation) buoyFluid(indices []int, material MaterialType, others *spatialHash, ticks float32) {
+	own := materialDensity(material)
+	reachSq := buoyancyReach * buoyancyReach
+	for _, i := range indices {
+		b := &s.balls[i]
+		if b.frozen || b.asleep() {
+			continue
+		}
+		gx, gy := s.gravityFor(i)
+		if gx == 0 && gy == 0 {
+			continue
+		}
+		weight := float32(1)
+		surrounding := airMaterialDensity
+		cx, cy := others.coord(b.pos.x), others.coord(b.pos.y)
+		for _, offset := range neighborOffsets {
+			for _, j := range others.cell(cx+offset.dx, cy+offset.dy) {
+				if !layersMeet(b, &s.balls[j]) {
+					continue
+				}
+				dx := s.balls[j].pos.x - b.pos.x
+				dy := s.balls[j].pos.y - b.pos.y
+				distSq := dx*dx + dy*dy
+				if distSq >= reachSq {
+					continue
+				}
+				w := 1 - float32(math.Sqrt(float64(distSq)))/buoyancyReach
+				weight += w
+				surrounding += w * materialDensity(s.balls[j].material)
 			}
 		}
+		ratio := surrounding / weight / own
+		if g := float32(math.Hypot(float64(gx), float64(gy))); g*ratio > maxBuoyancy {
+			ratio = maxBuoyancy / g
+		}
+		liftX := -gx * ratio * ticks
+		liftY := -gy * ratio * ticks
+		b.velocity.vx += liftX
+		b.velocity.vy += liftY
+		s.probe(&s.forces.buoyancy, i, liftX, liftY)
+	}
+}
 
-		if balls[i].pos.y+balls[i].radius > bottomLimit {
-			balls[i].pos.y = bottomLimit - balls[i].radius
-			balls[i].velocity.vy *= -g.settings.groundRestitution
-			balls[i].velocity.vx *= g.settings.groundFriction
+// bubbleLiftShare is the part of the bubbleLift setting applied per contact.
+// It is the 0.9 the lift had when sized from the old 1:0.1 water-gas density
+// ratio, kept separate so tuning the buoyancy densities leaves bubbles alone.
+const bubbleLiftShare = float32(0.9)
+
+// liftBubble pushes a gas particle up through the water particle it touches,
+// with an equal and opposite push on the water. A gas particle directly under
+// water is also nudged sideways so it slides around instead of getting stuck.
+func (s *Simulation) liftBubble(gasIdx, waterIdx int) {
+	gas := &s.balls[gasIdx]
+	water := &s.balls[waterIdx]
+	lift := s.settings.bubbleLift * bubbleLiftShare
+	gas.velocity.vy -= lift
+	water.velocity.vy += lift
+	s.probe(&s.forces.buoyancy, gasIdx, 0, -lift)
+	if gas.pos.y > water.pos.y {
+		side := float32(1)
+		if gas.pos.x < water.pos.x {
+			side = -1
 		}
+		gas.velocity.vx += side * lift * 0.5
+		s.probe(&s.forces.buoyancy, gasIdx, side*lift*0.5, 0)
+	}
+}
+
+// The balls slice is compacted automatically once its capacity is this many
+// times the particle count, e.g. after a large scene has been erased.
+const (
+	autoCompactMinCap = 4096
+	autoCompactRatio  = 4
+)
+
+// compact reallocates the particle slice, the per-material caches and the
+// spatial hashes to fit the current population, then returns the memory
+// released to the OS as a status message.
+func (g *Game) compact() string {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	g.balls = append(make([]Ball, 0, len(g.balls)), g.balls...)
+	g.cellCache = nil
+	g.waterCellCache = nil
+	g.waterIndices = nil
+	g.waterDensity = nil
+	g.waterNearDensity = nil
+	g.waterIndexMap = make(map[int]int)
+	g.solidIndices = nil
+	g.gasCellCache = nil
+	g.gasIndices = nil
+	g.gasIndexMap = make(map[int]int)
+	g.gasDensity = nil
+	g.collider = newSpatialHash(g.collider.cellSize)
+	g.waterCollider = newSpatialHash(g.waterCollider.cellSize)
+	g.solidCollider = newSpatialHash(g.solidCollider.cellSize)
+	g.gasCollider = newSpatialHash(g.gasCollider.cellSize)
+
+	debug.FreeOSMemory()
+	runtime.ReadMemStats(&after)
+	inUse := func(m runtime.MemStats) float64 { return float64(m.HeapSys-m.HeapReleased) / (1 << 20) }
+	return fmt.Sprintf("Compacted: heap %.1f MB -> %.1f MB", inUse(before), inUse(after))
+}
+
+// largeSpawnBatch is the batch size that triggers the spawn cooldown.
+const largeSpawnBatch = 20
+
+// refillSpawnBudget tops up the spawn token bucket. The bucket holds a quarter
+// second of spawns, so a held button pours steadily instead of all at once.
+func (g *Game) refillSpawnBudget() {
+	dt := float32(1) / float32(ebiten.TPS())
+	if g.spawnCooldownLeft > 0 {
+		g.spawnCooldownLeft -= dt
+		g.spawnThrottled = true
+	}
+	if g.settings.spawnRateLimit <= 0 {
+		return
+	}
+	rate := float32(g.settings.spawnRateLimit)
+	g.spawnTokens += rate * dt
+	if capacity := rate / 4; g.spawnTokens > capacity {
+		g.spawnTokens = capacity
+	}
+}
 
-		if balls[i].pos.x-balls[i].radius < 0 {
-			balls[i].pos.x = balls[i].radius
-			balls[i].velocity.vx *= -g.settings.groundRestitution
+// reverseTime negates every dynamic particle's velocity so the scene runs
+// backward. Only the conservative parts retrace their path: drag, friction,
+// inelastic bounces and the fluid viscosity all lose energy in both
+// directions, so a splash only reassembles roughly and for a short while.
+func (s *Simulation) reverseTime() {
+	for i := range s.balls {
+		if s.balls[i].material == MaterialStatic {
+			continue
 		}
+		s.balls[i].velocity.vx = -s.balls[i].velocity.vx
+		s.balls[i].velocity.vy = -s.balls[i].velocity.vy
+	}
+}
 
-		ballRightLimit := rightLimit - balls[i].radius
-		if balls[i].pos.x > ballRightLimit {
-			balls[i].pos.x = ballRightLimit
-			balls[i].velocity.vx *= -g.settings.groundRestitution
+// sceneScaleStep is the factor applied per Ctrl+'+' press.
+const sceneScaleStep = 1.1
+
+// scaleScene scales every particle, bucket, zone, polygon, wall, well and
+// cannon about the world center. Radii are clamped to [minSpawnRadius,
+// maxSpawnRadius] afterward, like loaded ones, so a scene scaled far down and
+// back up again may not match the original exactly. The tunable spawn ranges
+// only limit new particles.
+func (g *Game) scaleScene(factor float32) {
+	cx, cy := g.width/2, g.height/2
+	scale := func(p Pos) Pos {
+		return Pos{x: cx + (p.x-cx)*factor, y: cy + (p.y-cy)*factor}
+	}
+	for i := range g.balls {
+		g.balls[i].pos = scale(g.balls[i].pos)
+		g.balls[i].radius = clampRadius(g.balls[i].radius*factor, minSpawnRadius, maxSpawnRadius)
+		g.balls[i].local = Pos{x: g.balls[i].local.x * factor, y: g.balls[i].local.y * factor}
+	}
+	for i := range g.buckets {
+		g.buckets[i].pos = scale(g.buckets[i].pos)
+	}
+	for i := range g.zones {
+		g.zones[i].min = scale(g.zones[i].min)
+		g.zones[i].max = scale(g.zones[i].max)
+	}
+	for i := range g.polygons {
+		points := make([]Pos, len(g.polygons[i].points))
+		for j, pt := range g.polygons[i].points {
+			points[j] = scale(pt)
 		}
+		if poly, ok := newPolygon(points); ok {
+			g.polygons[i] = poly
+		}
+	}
+	for i := range g.wells {
+		g.wells[i].pos = scale(g.wells[i].pos)
+	}
+	for i := range g.walls {
+		g.walls[i].a = scale(g.walls[i].a)
+		g.walls[i].b = scale(g.walls[i].b)
+		g.walls[i].thickness *= factor
+	}
+	for i := range g.cannons {
+		g.cannons[i].pos = scale(g.cannons[i].pos)
+		g.cannons[i].radius = clampRadius(g.cannons[i].radius*factor, minSpawnRadius, maxSpawnRadius)
 	}
 
-	if len(balls) > 1 {
-		for iteration := 0; iteration < maxCollisionSolves; iteration++ {
-			g.collider.Clear()
-			if len(g.cellCache) < len(balls) {
-				g.cellCache = make([]cellCoord, len(balls))
-			}
-			for i := range balls {
-				cx := g.collider.coord(balls[i].pos.x)
-				cy := g.collider.coord(balls[i].pos.y)
-				g.cellCache[i] = cellCoord{x: cx, y: cy}
-				g.collider.insert(i, cx, cy)
-			}
+	g.queryDirty = true
+	// Any calibrated cell size was tuned for the old radii.
+	g.collider = newSpatialHash(maxSpawnRadius * 2)
+	g.solidCollider = newSpatialHash(maxSpawnRadius * 2)
+}
 
-			anyResolved := false
-			for i := range balls {
-				coord := g.cellCache[i]
-				for _, offset := range neighborOffsets {
-					neighbors := g.collider.cell(coord.x+offset.dx, coord.y+offset.dy)
-					for _, j := range neighbors {
-						if j <= i {
-							continue
-						}
-						a := &balls[i]
-						b := &balls[j]
-						ma := a.material
-						mb := b.material
-						switch {
-						case ma == MaterialWater && mb == MaterialWater:
-							continue
-						case ma == MaterialGas && mb == MaterialGas:
-							continue
-						case (ma == MaterialWater && mb == MaterialGas) || (ma == MaterialGas && mb == MaterialWater):
-							if resolveCollisionCustom(a, b, g.settings.collisionRestitution*0.2, 0.04) {
-								anyResolved = true
-							}
-							continue
-						case ma == MaterialWater || mb == MaterialWater:
-							if resolveCollisionCustom(a, b, g.settings.collisionRestitution*0.25, 0.05) {
-								anyResolved = true
-							}
-							continue
-						case ma == MaterialGas || mb == MaterialGas:
-							if resolveCollisionCustom(a, b, g.settings.collisionRestitution*0.3, 0.02) {
-								anyResolved = true
-							}
-							continue
-						default:
-							if resolveCollision(a, b, g.settings.collisionRestitution) {
-								anyResolved = true
-							}
-						}
-					}
+// staticOverlapSlop ignores overlaps shallower than this, which come from
+// rounding rather than malformed geometry.
+const staticOverlapSlop = float32(0.5)
+
+// findStaticOverlaps returns the midpoint of every pair of static particles
+// that overlap. Walls of the same bucket are built overlapping on purpose and
+// are skipped.
+func findStaticOverlaps(list []Ball) []Pos {
+	var statics []int
+	for i := range list {
+		if list[i].material == MaterialStatic {
+			statics = append(statics, i)
+		}
+	}
+	if len(statics) < 2 {
+		return nil
+	}
+	hash := newSpatialHash(2 * maxRadius(list))
+	cells := make([]cellCoord, len(statics))
+	for n, i := range statics {
+		cells[n] = cellCoord{x: hash.coord(list[i].pos.x), y: hash.coord(list[i].pos.y)}
+		hash.insert(i, cells[n].x, cells[n].y)
+	}
+	var overlaps []Pos
+	for n, i := range statics {
+		for _, offset := range neighborOffsets {
+			for _, j := range hash.cell(cells[n].x+offset.dx, cells[n].y+offset.dy) {
+				if j <= i {
+					continue
+				}
+				a, b := &list[i], &list[j]
+				if a.body != 0 && a.body == b.body {
+					continue
+				}
+				dx := b.pos.x - a.pos.x
+				dy := b.pos.y - a.pos.y
+				reach := a.radius + b.radius - staticOverlapSlop
+				if dx*dx+dy*dy < reach*reach {
+					overlaps = append(overlaps, Pos{x: a.pos.x + dx/2, y: a.pos.y + dy/2})
 				}
-			}
-			if !anyResolved {
-				break
 			}
 		}
 	}
+	return overlaps
+}
 
-	return nil
+func describeOverlaps(overlaps []Pos) string {
+	if len(overlaps) == 0 {
+		return "No overlapping static particles"
+	}
+	const listed = 3
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d static overlaps at", len(overlaps))
+	for i, p := range overlaps {
+		if i == listed {
+			fmt.Fprintf(&b, " (+%d more, marked in red)", len(overlaps)-listed)
+			break
+		}
+		fmt.Fprintf(&b, " (%.0f, %.0f)", p.x, p.y)
+	}
+	return b.String()
+}
+
+// pickBall returns the index of the topmost particle under (x, y), or -1.
+func (s *Simulation) pickBall(x, y float32) int {
+	for i := len(s.balls) - 1; i >= 0; i-- {
+		dx := s.balls[i].pos.x - x
+		dy := s.balls[i].pos.y - y
+		r := s.balls[i].radius + 5
+		if dx*dx+dy*dy < r*r {
+			return i
+		}
+	}
+	return -1
 }
 
-func (g *Game) applyWaterForces() {
-	if len(balls) == 0 {
+func (s *Simulation) applyWaterForces() {
+	if len(s.balls) == 0 {
 		return
 	}
 
-	g.waterCollider.Clear()
-	g.solidCollider.Clear()
-	g.waterIndices = g.waterIndices[:0]
-	g.solidIndices = g.solidIndices[:0]
+	s.waterCollider.Clear()
+	s.solidCollider.Clear()
+	s.waterIndices = s.waterIndices[:0]
+	s.solidIndices = s.solidIndices[:0]
 
-	for i := range balls {
-		switch balls[i].material {
+	for i := range s.balls {
+		switch s.balls[i].material {
 		case MaterialWater:
-			g.waterIndices = append(g.waterIndices, i)
-		case MaterialSolid:
-			g.solidIndices = append(g.solidIndices, i)
+			s.waterIndices = append(s.waterIndices, i)
+		case MaterialSolid, MaterialSand:
+			s.solidIndices = append(s.solidIndices, i)
 		case MaterialStatic:
-			g.solidIndices = append(g.solidIndices, i)
+			if !s.balls[i].permeable {
+				s.solidIndices = append(s.solidIndices, i)
+			}
 		}
 	}
 
-	if len(g.waterIndices) == 0 {
+	if len(s.waterIndices) == 0 {
 		return
 	}
 
-	if len(g.waterCellCache) < len(g.waterIndices) {
-		g.waterCellCache = make([]cellCoord, len(g.waterIndices))
+	if len(s.waterCellCache) < len(s.waterIndices) {
+		s.waterCellCache = make([]cellCoord, len(s.waterIndices))
 	}
-	if len(g.waterDensity) < len(g.waterIndices) {
-		g.waterDensity = make([]float32, len(g.waterIndices))
+	if len(s.waterDensity) < len(s.waterIndices) {
+		s.waterDensity = make([]float32, len(s.waterIndices))
 	}
-	if len(g.waterNearDensity) < len(g.waterIndices) {
-		g.waterNearDensity = make([]float32, len(g.waterIndices))
+	if len(s.waterNearDensity) < len(s.waterIndices) {
+		s.waterNearDensity = make([]float32, len(s.waterIndices))
 	}
 
-	for key := range g.waterIndexMap {
-		delete(g.waterIndexMap, key)
+	for key := range s.waterIndexMap {
+		delete(s.waterIndexMap, key)
 	}
 
-	for idx, ballIdx := range g.waterIndices {
-		cx := g.waterCollider.coord(balls[ballIdx].pos.x)
-		cy := g.waterCollider.coord(balls[ballIdx].pos.y)
-		g.waterCellCache[idx] = cellCoord{x: cx, y: cy}
-		g.waterCollider.insert(ballIdx, cx, cy)
-		g.waterIndexMap[ballIdx] = idx
+	for idx, ballIdx := range s.waterIndices {
+		cx := s.waterCollider.coord(s.balls[ballIdx].pos.x)
+		cy := s.waterCollider.coord(s.balls[ballIdx].pos.y)
+		s.waterCellCache[idx] = cellCoord{x: cx, y: cy}
+		s.waterCollider.insert(ballIdx, cx, cy)
+		s.waterIndexMap[ballIdx] = idx
 	}
 
-	if len(g.solidIndices) > 0 {
-		for _, ballIdx := range g.solidIndices {
-			cx := g.solidCollider.coord(balls[ballIdx].pos.x)
-			cy := g.solidCollider.coord(balls[ballIdx].pos.y)
-			g.solidCollider.insert(ballIdx, cx, cy)
+	if len(s.solidIndices) > 0 {
+		for _, ballIdx := range s.solidIndices {
+			cx := s.solidCollider.coord(s.balls[ballIdx].pos.x)
+			cy := s.solidCollider.coord(s.balls[ballIdx].pos.y)
+			s.solidCollider.insert(ballIdx, cx, cy)
 		}
 	}
 
 	interactionRadius := waterInteraction
 	interactionRadiusSq := interactionRadius * interactionRadius
 
-	for idx, ballIdx := range g.waterIndices {
-		density := float32(0)
-		nearDensity := float32(0)
-		coord := g.waterCellCache[idx]
-		for _, offset := range neighborOffsets {
-			neighbors := g.waterCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
-			for _, neighborIdx := range neighbors {
-				if neighborIdx == ballIdx {
-					continue
-				}
-				if balls[neighborIdx].material != MaterialWater {
-					continue
-				}
-				dx := balls[neighborIdx].pos.x - balls[ballIdx].pos.x
-				dy := balls[neighborIdx].pos.y - balls[ballIdx].pos.y
-				distSq := dx*dx + dy*dy
-				if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
-					continue
-				}
-				dist := float32(math.Sqrt(float64(distSq)))
-				if dist <= 0 {
-					continue
-				}
-				q := 1 - dist/interactionRadius
-				density += q * q
-				nearDensity += q * q * q
-			}
+	// Extra solver iterations re-evaluate density at the positions particles are
+	// about to move to, so pressure reacts to compression within the same frame.
+	// Each later pass is weaker than the last to keep high counts from overshooting.
+	for iteration := 0; iteration < s.settings.fluidIterations; iteration++ {
+		lookahead := float32(0)
+		relax := float32(1)
+		if iteration > 0 {
+			lookahead = 1
+			relax = 1 / float32(iteration+1)
 		}
-		g.waterDensity[idx] = density + 1
-		g.waterNearDensity[idx] = nearDensity
-	}
 
-	for idx, ballIdx := range g.waterIndices {
-		coord := g.waterCellCache[idx]
-		density := g.waterDensity[idx]
-		nearDensity := g.waterNearDensity[idx]
-		pressure := waterPressureStiff * (density - waterRestDensity)
-		nearPressure := waterNearStiff * nearDensity
+		parallelRange(len(s.waterIndices), func(lo, hi int) {
+			s.waterDensityRange(lo, hi, lookahead, interactionRadius)
+		})
 
-		for _, offset := range neighborOffsets {
-			neighbors := g.waterCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
-			for _, neighborIdx := range neighbors {
-				if neighborIdx <= ballIdx {
-					continue
-				}
-				neighborWaterIdx, ok := g.waterIndexMap[neighborIdx]
-				if !ok {
-					continue
-				}
+		for idx, ballIdx := range s.waterIndices {
+			coord := s.waterCellCache[idx]
+			density := s.waterDensity[idx]
+			nearDensity := s.waterNearDensity[idx]
+			pressure := s.settings.waterPressureStiff * (density - s.settings.waterRestDensity)
+			nearPressure := waterNearStiff * nearDensity
+
+			found := 0
+		waterPairs:
+			for _, offset := range neighborOffsets {
+				neighbors := s.waterCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
+				for _, neighborIdx := range neighbors {
+					if neighborIdx <= ballIdx {
+						continue
+					}
+					if !layersMeet(&s.balls[ballIdx], &s.balls[neighborIdx]) {
+						continue
+					}
+					neighborWaterIdx, ok := s.waterIndexMap[neighborIdx]
+					if !ok {
+						continue
+					}
 
-				dx := balls[neighborIdx].pos.x - balls[ballIdx].pos.x
-				dy := balls[neighborIdx].pos.y - balls[ballIdx].pos.y
-				distSq := dx*dx + dy*dy
-				if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
-					continue
-				}
-				dist := float32(math.Sqrt(float64(distSq)))
-				if dist <= 0 {
-					continue
-				}
-				q := 1 - dist/interactionRadius
-				nx := dx / dist
-				ny := dy / dist
+					dx := s.balls[neighborIdx].pos.x + s.balls[neighborIdx].velocity.vx*lookahead - s.balls[ballIdx].pos.x - s.balls[ballIdx].velocity.vx*lookahead
+					dy := s.balls[neighborIdx].pos.y + s.balls[neighborIdx].velocity.vy*lookahead - s.balls[ballIdx].pos.y - s.balls[ballIdx].velocity.vy*lookahead
+					distSq := dx*dx + dy*dy
+					if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
+						continue
+					}
+					dist := float32(math.Sqrt(float64(distSq)))
+					if dist <= 0 {
+						continue
+					}
+					q := 1 - dist/interactionRadius
+					nx := dx / dist
+					ny := dy / dist
+
+					neighborDensity := s.waterDensity[neighborWaterIdx]
+					neighborNearDensity := s.waterNearDensity[neighborWaterIdx]
+					neighborPressure := s.settings.waterPressureStiff * (neighborDensity - s.settings.waterRestDensity)
+					neighborNearPressure := waterNearStiff * neighborNearDensity
+
+					pressureMag := (pressure + neighborPressure) * 0.5
+					nearMag := (nearPressure + neighborNearPressure) * 0.5
+					force := (q*pressureMag + q*q*nearMag) * relax
+					// Stiff settings can ask for more than a frame's worth of
+					// separation; clamping keeps the pair from overshooting.
+					force = max(-waterMaxPairForce, min(waterMaxPairForce, force))
+					if force != 0 {
+						impulseX := nx * force
+						impulseY := ny * force
+						s.balls[ballIdx].velocity.vx -= impulseX
+						s.balls[ballIdx].velocity.vy -= impulseY
+						s.balls[neighborIdx].velocity.vx += impulseX
+						s.balls[neighborIdx].velocity.vy += impulseY
+						s.probe(&s.forces.pressure, ballIdx, -impulseX, -impulseY)
+						s.probe(&s.forces.pressure, neighborIdx, impulseX, impulseY)
+					}
 
-				neighborDensity := g.waterDensity[neighborWaterIdx]
-				neighborNearDensity := g.waterNearDensity[neighborWaterIdx]
-				neighborPressure := waterPressureStiff * (neighborDensity - waterRestDensity)
-				neighborNearPressure := waterNearStiff * neighborNearDensity
-
-				pressureMag := (pressure + neighborPressure) * 0.5
-				nearMag := (nearPressure + neighborNearPressure) * 0.5
-				force := q*pressureMag + q*q*nearMag
-				if force != 0 {
-					impulseX := nx * force
-					impulseY := ny * force
-					balls[ballIdx].velocity.vx -= impulseX
-					balls[ballIdx].velocity.vy -= impulseY
-					balls[neighborIdx].velocity.vx += impulseX
-					balls[neighborIdx].velocity.vy += impulseY
+					relVelX := s.balls[neighborIdx].velocity.vx - s.balls[ballIdx].velocity.vx
+					relVelY := s.balls[neighborIdx].velocity.vy - s.balls[ballIdx].velocity.vy
+					relAlongNormal := relVelX*nx + relVelY*ny
+					viscImpulse := relAlongNormal * waterViscosity * q * 0.5 * relax
+					viscX := nx * viscImpulse
+					viscY := ny * viscImpulse
+					s.balls[ballIdx].velocity.vx += viscX
+					s.balls[ballIdx].velocity.vy += viscY
+					s.balls[neighborIdx].velocity.vx -= viscX
+					s.balls[neighborIdx].velocity.vy -= viscY
+					s.probe(&s.forces.viscosity, ballIdx, viscX, viscY)
+					s.probe(&s.forces.viscosity, neighborIdx, -viscX, -viscY)
+
+					// Surface tension pulls neighbors together. It fades to zero
+					// both at contact, where pressure takes over, and at the edge
+					// of the interaction radius, and runs once per frame.
+					if iteration == 0 && s.settings.waterSurfaceTension > 0 {
+						pull := s.settings.waterSurfaceTension * q * (1 - q)
+						pullX, pullY := nx*pull, ny*pull
+						s.balls[ballIdx].velocity.vx += pullX
+						s.balls[ballIdx].velocity.vy += pullY
+						s.balls[neighborIdx].velocity.vx -= pullX
+						s.balls[neighborIdx].velocity.vy -= pullY
+						s.probe(&s.forces.tension, ballIdx, pullX, pullY)
+						s.probe(&s.forces.tension, neighborIdx, -pullX, -pullY)
+					}
+					found++
+					if s.settings.neighborCap > 0 && found >= s.settings.neighborCap {
+						break waterPairs
+					}
 				}
-
-				relVelX := balls[neighborIdx].velocity.vx - balls[ballIdx].velocity.vx
-				relVelY := balls[neighborIdx].velocity.vy - balls[ballIdx].velocity.vy
-				relAlongNormal := relVelX*nx + relVelY*ny
-				viscImpulse := relAlongNormal * waterViscosity * q * 0.5
-				viscX := nx * viscImpulse
-				viscY := ny * viscImpulse
-				balls[ballIdx].velocity.vx += viscX
-				balls[ballIdx].velocity.vy += viscY
-				balls[neighborIdx].velocity.vx -= viscX
-				balls[neighborIdx].velocity.vy -= viscY
 			}
 		}
 	}
 
-	for idx, waterIdx := range g.waterIndices {
-		waterBall := &balls[waterIdx]
+	for idx, waterIdx := range s.waterIndices {
+		waterBall := &s.balls[waterIdx]
 		baseRange := waterBall.radius + waterRestDistance
-		coord := g.waterCellCache[idx]
+		coord := s.waterCellCache[idx]
 		for _, offset := range neighborOffsets {
-			neighbors := g.solidCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
+			neighbors := s.solidCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
 			for _, solidIdx := range neighbors {
-				dx := waterBall.pos.x - balls[solidIdx].pos.x
-				dy := waterBall.pos.y - balls[solidIdx].pos.y
-				allowed := balls[solidIdx].radius + baseRange
+				dx := waterBall.pos.x - s.balls[solidIdx].pos.x
+				dy := waterBall.pos.y - s.balls[solidIdx].pos.y
+				allowed := s.balls[solidIdx].radius + baseRange
 				distSq := dx*dx + dy*dy
 				if distSq >= allowed*allowed || distSq < minimumSeparation*minimumSeparation {
 					continue
@@ -1206,133 +5113,216 @@ func (g *Game) applyWaterForces() {
 				push := penetration * waterBoundaryPush
 				waterBall.velocity.vx += nx * push
 				waterBall.velocity.vy += ny * push
-				if balls[solidIdx].material != MaterialStatic {
-					balls[solidIdx].velocity.vx -= nx * push * 0.25
-					balls[solidIdx].velocity.vy -= ny * push * 0.25
+				s.probe(&s.forces.boundary, waterIdx, nx*push, ny*push)
+				if s.balls[solidIdx].material != MaterialStatic {
+					s.balls[solidIdx].velocity.vx -= nx * push * 0.25
+					s.balls[solidIdx].velocity.vy -= ny * push * 0.25
+					s.probe(&s.forces.boundary, solidIdx, -nx*push*0.25, -ny*push*0.25)
 				}
 
 				tx := -ny
 				ty := nx
-				relVelX := waterBall.velocity.vx - balls[solidIdx].velocity.vx
-				relVelY := waterBall.velocity.vy - balls[solidIdx].velocity.vy
+				relVelX := waterBall.velocity.vx - s.balls[solidIdx].velocity.vx
+				relVelY := waterBall.velocity.vy - s.balls[solidIdx].velocity.vy
 				relTangential := relVelX*tx + relVelY*ty
 				drag := relTangential * waterBoundaryDrag
 				waterBall.velocity.vx -= tx * drag
 				waterBall.velocity.vy -= ty * drag
-				if balls[solidIdx].material != MaterialStatic {
-					balls[solidIdx].velocity.vx += tx * drag * 0.25
-					balls[solidIdx].velocity.vy += ty * drag * 0.25
+				s.probe(&s.forces.boundary, waterIdx, -tx*drag, -ty*drag)
+				if s.balls[solidIdx].material != MaterialStatic {
+					s.balls[solidIdx].velocity.vx += tx * drag * 0.25
+					s.balls[solidIdx].velocity.vy += ty * drag * 0.25
+					s.probe(&s.forces.boundary, solidIdx, tx*drag*0.25, ty*drag*0.25)
+				}
+			}
+		}
+	}
+
+	s.pushFluidFromObstacles(s.waterIndices, waterRestDistance, waterBoundaryPush)
+}
+
+// waterDensityRange computes density and near-density for water slots
+// [lo, hi). It only reads particle state and writes its own slots, so
+// disjoint ranges can run concurrently.
+func (s *Simulation) waterDensityRange(lo, hi int, lookahead, interactionRadius float32) {
+	interactionRadiusSq := interactionRadius * interactionRadius
+	for idx := lo; idx < hi; idx++ {
+		ballIdx := s.waterIndices[idx]
+		density := float32(0)
+		nearDensity := float32(0)
+		coord := s.waterCellCache[idx]
+		found := 0
+	densityNeighbors:
+		for _, offset := range neighborOffsets {
+			neighbors := s.waterCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
+			for _, neighborIdx := range neighbors {
+				if neighborIdx == ballIdx {
+					continue
+				}
+				if s.balls[neighborIdx].material != MaterialWater || !layersMeet(&s.balls[ballIdx], &s.balls[neighborIdx]) {
+					continue
+				}
+				dx := s.balls[neighborIdx].pos.x + s.balls[neighborIdx].velocity.vx*lookahead - s.balls[ballIdx].pos.x - s.balls[ballIdx].velocity.vx*lookahead
+				dy := s.balls[neighborIdx].pos.y + s.balls[neighborIdx].velocity.vy*lookahead - s.balls[ballIdx].pos.y - s.balls[ballIdx].velocity.vy*lookahead
+				distSq := dx*dx + dy*dy
+				if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
+					continue
+				}
+				dist := float32(math.Sqrt(float64(distSq)))
+				if dist <= 0 {
+					continue
+				}
+				q := 1 - dist/interactionRadius
+				density += q * q
+				nearDensity += q * q * q
+				found++
+				if s.settings.neighborCap > 0 && found >= s.settings.neighborCap {
+					break densityNeighbors
 				}
 			}
 		}
+		s.waterDensity[idx] = density + 1
+		s.waterNearDensity[idx] = nearDensity
 	}
 }
 
-func (g *Game) applyGasForces() {
-	g.gasCollider.Clear()
-	g.gasIndices = g.gasIndices[:0]
+func (s *Simulation) applyGasForces() {
+	s.gasCollider.Clear()
+	s.gasIndices = s.gasIndices[:0]
+	clear(s.gasIndexMap)
 
-	for i := range balls {
-		if balls[i].material == MaterialGas {
-			g.gasIndices = append(g.gasIndices, i)
+	for i := range s.balls {
+		if s.balls[i].material == MaterialGas {
+			s.gasIndices = append(s.gasIndices, i)
 		}
 	}
 
-	if len(g.gasIndices) == 0 {
+	if len(s.gasIndices) == 0 {
 		return
 	}
 
-	if len(g.gasCellCache) < len(g.gasIndices) {
-		g.gasCellCache = make([]cellCoord, len(g.gasIndices))
+	if len(s.gasCellCache) < len(s.gasIndices) {
+		s.gasCellCache = make([]cellCoord, len(s.gasIndices))
 	}
-
-	for idx, ballIdx := range g.gasIndices {
-		cx := g.gasCollider.coord(balls[ballIdx].pos.x)
-		cy := g.gasCollider.coord(balls[ballIdx].pos.y)
-		g.gasCellCache[idx] = cellCoord{x: cx, y: cy}
-		g.gasCollider.insert(ballIdx, cx, cy)
+	if len(s.gasDensity) < len(s.gasIndices) {
+		s.gasDensity = make([]float32, len(s.gasIndices))
+	}
+	clear(s.gasDensity[:len(s.gasIndices)])
+
+	for idx, ballIdx := range s.gasIndices {
+		cx := s.gasCollider.coord(s.balls[ballIdx].pos.x)
+		cy := s.gasCollider.coord(s.balls[ballIdx].pos.y)
+		s.gasCellCache[idx] = cellCoord{x: cx, y: cy}
+		s.gasCollider.insert(ballIdx, cx, cy)
+		s.gasIndexMap[ballIdx] = idx
 	}
 
-	g.solidCollider.Clear()
-	g.solidIndices = g.solidIndices[:0]
-	for i := range balls {
-		if balls[i].material != MaterialSolid && balls[i].material != MaterialStatic {
+	s.solidCollider.Clear()
+	s.solidIndices = s.solidIndices[:0]
+	for i := range s.balls {
+		if isFluid(s.balls[i].material) || s.balls[i].permeable {
 			continue
 		}
-		g.solidIndices = append(g.solidIndices, i)
-		cx := g.solidCollider.coord(balls[i].pos.x)
-		cy := g.solidCollider.coord(balls[i].pos.y)
-		g.solidCollider.insert(i, cx, cy)
-	}
-
-	interactionRadius := gasInteraction
-	interactionRadiusSq := interactionRadius * interactionRadius
-	dragFactorX := 1 - gasDrag
-	dragFactorY := 1 - gasDrag*0.5
-
-	for _, ballIdx := range g.gasIndices {
-		balls[ballIdx].velocity.vy -= gasBuoyancy
-		balls[ballIdx].velocity.vx *= dragFactorX
-		balls[ballIdx].velocity.vy *= dragFactorY
+		s.solidIndices = append(s.solidIndices, i)
+		cx := s.solidCollider.coord(s.balls[i].pos.x)
+		cy := s.solidCollider.coord(s.balls[i].pos.y)
+		s.solidCollider.insert(i, cx, cy)
 	}
 
-	for idx, ballIdx := range g.gasIndices {
-		coord := g.gasCellCache[idx]
-		for _, offset := range neighborOffsets {
-			neighbors := g.gasCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
-			for _, neighborIdx := range neighbors {
-				if neighborIdx <= ballIdx {
-					continue
-				}
-				dx := balls[neighborIdx].pos.x - balls[ballIdx].pos.x
-				dy := balls[neighborIdx].pos.y - balls[ballIdx].pos.y
-				distSq := dx*dx + dy*dy
-				if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
-					continue
-				}
-				dist := float32(math.Sqrt(float64(distSq)))
-				if dist <= 0 {
-					continue
+	interactionRadius := gasInteraction
+	interactionRadiusSq := interactionRadius * interactionRadius
+	dragFactorX := 1 - gasDrag
+	dragFactorY := 1 - gasDrag*0.5
+
+	for _, ballIdx := range s.gasIndices {
+		s.balls[ballIdx].velocity.vx *= dragFactorX
+		s.balls[ballIdx].velocity.vy *= dragFactorY
+	}
+
+	for iteration := 0; iteration < s.settings.fluidIterations; iteration++ {
+		lookahead := float32(0)
+		relax := float32(1)
+		if iteration > 0 {
+			lookahead = 1
+			relax = 1 / float32(iteration+1)
+		}
+
+		for idx, ballIdx := range s.gasIndices {
+			coord := s.gasCellCache[idx]
+			found := 0
+		gasPairs:
+			for _, offset := range neighborOffsets {
+				neighbors := s.gasCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
+				for _, neighborIdx := range neighbors {
+					if neighborIdx <= ballIdx || !layersMeet(&s.balls[ballIdx], &s.balls[neighborIdx]) {
+						continue
+					}
+					dx := s.balls[neighborIdx].pos.x + s.balls[neighborIdx].velocity.vx*lookahead - s.balls[ballIdx].pos.x - s.balls[ballIdx].velocity.vx*lookahead
+					dy := s.balls[neighborIdx].pos.y + s.balls[neighborIdx].velocity.vy*lookahead - s.balls[ballIdx].pos.y - s.balls[ballIdx].velocity.vy*lookahead
+					distSq := dx*dx + dy*dy
+					if distSq >= interactionRadiusSq || distSq < minimumSeparation*minimumSeparation {
+						continue
+					}
+					dist := float32(math.Sqrt(float64(distSq)))
+					if dist <= 0 {
+						continue
+					}
+					nx := dx / dist
+					ny := dy / dist
+					q := 1 - dist/interactionRadius
+					if iteration == 0 {
+						// gasIndices is in ball order, so the neighbor's slot
+						// can be searched for.
+						s.gasDensity[idx] += q * q
+						s.gasDensity[sort.SearchInts(s.gasIndices, neighborIdx)] += q * q
+					}
+					pressure := gasPressure * q * q * relax
+					impulseX := nx * pressure
+					impulseY := ny * pressure
+					s.balls[ballIdx].velocity.vx -= impulseX
+					s.balls[ballIdx].velocity.vy -= impulseY
+					s.balls[neighborIdx].velocity.vx += impulseX
+					s.balls[neighborIdx].velocity.vy += impulseY
+					s.probe(&s.forces.pressure, ballIdx, -impulseX, -impulseY)
+					s.probe(&s.forces.pressure, neighborIdx, impulseX, impulseY)
+
+					relVelX := s.balls[neighborIdx].velocity.vx - s.balls[ballIdx].velocity.vx
+					relVelY := s.balls[neighborIdx].velocity.vy - s.balls[ballIdx].velocity.vy
+					relAlongNormal := relVelX*nx + relVelY*ny
+					viscImpulse := relAlongNormal * gasViscosity * q * 0.5 * relax
+					viscX := nx * viscImpulse
+					viscY := ny * viscImpulse
+					s.balls[ballIdx].velocity.vx += viscX
+					s.balls[ballIdx].velocity.vy += viscY
+					s.balls[neighborIdx].velocity.vx -= viscX
+					s.balls[neighborIdx].velocity.vy -= viscY
+					s.probe(&s.forces.viscosity, ballIdx, viscX, viscY)
+					s.probe(&s.forces.viscosity, neighborIdx, -viscX, -viscY)
+					found++
+					if s.settings.neighborCap > 0 && found >= s.settings.neighborCap {
+						break gasPairs
+					}
 				}
-				nx := dx / dist
-				ny := dy / dist
-				q := 1 - dist/interactionRadius
-				pressure := gasPressure * q * q
-				impulseX := nx * pressure
-				impulseY := ny * pressure
-				balls[ballIdx].velocity.vx -= impulseX
-				balls[ballIdx].velocity.vy -= impulseY
-				balls[neighborIdx].velocity.vx += impulseX
-				balls[neighborIdx].velocity.vy += impulseY
-
-				relVelX := balls[neighborIdx].velocity.vx - balls[ballIdx].velocity.vx
-				relVelY := balls[neighborIdx].velocity.vy - balls[ballIdx].velocity.vy
-				relAlongNormal := relVelX*nx + relVelY*ny
-				viscImpulse := relAlongNormal * gasViscosity * q * 0.5
-				viscX := nx * viscImpulse
-				viscY := ny * viscImpulse
-				balls[ballIdx].velocity.vx += viscX
-				balls[ballIdx].velocity.vy += viscY
-				balls[neighborIdx].velocity.vx -= viscX
-				balls[neighborIdx].velocity.vy -= viscY
 			}
 		}
 	}
 
-	if len(g.solidIndices) == 0 {
+	s.pushFluidFromObstacles(s.gasIndices, gasRestDistance, gasBoundaryPush)
+
+	if len(s.solidIndices) == 0 {
 		return
 	}
 
-	for idx, gasIdx := range g.gasIndices {
-		gasBall := &balls[gasIdx]
+	for idx, gasIdx := range s.gasIndices {
+		gasBall := &s.balls[gasIdx]
 		baseRange := gasBall.radius + gasRestDistance
-		coord := g.gasCellCache[idx]
+		coord := s.gasCellCache[idx]
 		for _, offset := range neighborOffsets {
-			neighbors := g.solidCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
+			neighbors := s.solidCollider.cell(coord.x+offset.dx, coord.y+offset.dy)
 			for _, solidIdx := range neighbors {
-				dx := gasBall.pos.x - balls[solidIdx].pos.x
-				dy := gasBall.pos.y - balls[solidIdx].pos.y
-				allowed := balls[solidIdx].radius + baseRange
+				dx := gasBall.pos.x - s.balls[solidIdx].pos.x
+				dy := gasBall.pos.y - s.balls[solidIdx].pos.y
+				allowed := s.balls[solidIdx].radius + baseRange
 				distSq := dx*dx + dy*dy
 				if distSq >= allowed*allowed || distSq < minimumSeparation*minimumSeparation {
 					continue
@@ -1347,22 +5337,26 @@ func (g *Game) applyGasForces() {
 				push := penetration * gasBoundaryPush
 				gasBall.velocity.vx += nx * push
 				gasBall.velocity.vy += ny * push
-				if balls[solidIdx].material != MaterialStatic {
-					balls[solidIdx].velocity.vx -= nx * push * 0.15
-					balls[solidIdx].velocity.vy -= ny * push * 0.15
+				s.probe(&s.forces.boundary, gasIdx, nx*push, ny*push)
+				if s.balls[solidIdx].material != MaterialStatic {
+					s.balls[solidIdx].velocity.vx -= nx * push * 0.15
+					s.balls[solidIdx].velocity.vy -= ny * push * 0.15
+					s.probe(&s.forces.boundary, solidIdx, -nx*push*0.15, -ny*push*0.15)
 				}
 
 				tx := -ny
 				ty := nx
-				relVelX := gasBall.velocity.vx - balls[solidIdx].velocity.vx
-				relVelY := gasBall.velocity.vy - balls[solidIdx].velocity.vy
+				relVelX := gasBall.velocity.vx - s.balls[solidIdx].velocity.vx
+				relVelY := gasBall.velocity.vy - s.balls[solidIdx].velocity.vy
 				relTangential := relVelX*tx + relVelY*ty
 				drag := relTangential * gasBoundaryDrag
 				gasBall.velocity.vx -= tx * drag
 				gasBall.velocity.vy -= ty * drag
-				if balls[solidIdx].material != MaterialStatic {
-					balls[solidIdx].velocity.vx += tx * drag * 0.15
-					balls[solidIdx].velocity.vy += ty * drag * 0.15
+				s.probe(&s.forces.boundary, gasIdx, -tx*drag, -ty*drag)
+				if s.balls[solidIdx].material != MaterialStatic {
+					s.balls[solidIdx].velocity.vx += tx * drag * 0.15
+					s.balls[solidIdx].velocity.vy += ty * drag * 0.15
+					s.probe(&s.forces.boundary, solidIdx, tx*drag*0.15, ty*drag*0.15)
 				}
 			}
 		}
@@ -1370,47 +5364,126 @@ func (g *Game) applyGasForces() {
 }
 
 func (g *Game) Draw(screen *ebiten.Image) {
+	// With frame skip on, the screen keeps its last contents between draws
+	// (see Update), so skipped frames simply leave the previous image up.
+	g.drawFrame++
+	if skip := g.settings.renderSkip; skip > 0 {
+		if g.drawFrame%(skip+1) != 0 {
+			return
+		}
+	}
+	screen.Fill(g.settings.background)
+	if g.shotPending {
+		g.shotPending = false
+		defer g.saveScreenshot(screen)
+	}
+
 	fps := ebiten.CurrentFPS()
-	shapeNames := []string{"Circle", "Square", "Triangle", "Water", "Gas", "Static"}
+	shapeNames := []string{"Circle", "Square", "Triangle", "Water", "Gas", "Static", "Grate", "Sand"}
 	shapeLabel := "Unknown"
 	if int(currentShape) < len(shapeNames) {
 		shapeLabel = shapeNames[currentShape]
 	}
-	bc := fmt.Sprintf("%.f particles | FPS: %.2f | ball radius: %.2f | attract radius: %.f | spawn count: %d | Shape: %s (1/2/3/4/5/6)",
-		float64(len(balls)), fps, ballsize, moveAttractDistance, g.spawnClusterCount, shapeLabel)
-	ebitenutil.DebugPrint(screen, bc)
+	particleLabel := fmt.Sprintf("%d", len(g.balls))
+	if g.settings.maxParticles > 0 {
+		particleLabel = fmt.Sprintf("%d/%d", len(g.balls), g.settings.maxParticles)
+	}
+	fpsLabel := fmt.Sprintf("%.2f", fps)
+	if g.settings.timeScale < 1 {
+		fpsLabel += fmt.Sprintf(" (slow motion %.2fx)", g.settings.timeScale)
+	}
+	bc := fmt.Sprintf("%s particles | FPS: %s | ball radius: %.2f | attract radius: %.f | spawn count: %d | Shape: %s (1/2/3/4/5/6/7/8) | Profile: %s",
+		particleLabel, fpsLabel, ballsize, moveAttractDistance, g.spawnClusterCount, shapeLabel, g.settings.profileName())
+	if !g.hideHUD {
+		g.printHUD(screen, bc, 0, 0)
+		g.printHUD(screen, mouseHelp, int(g.width)-len(mouseHelp)*6-10, int(g.height)-20)
+		if g.showEnergy {
+			e := measureEnergy(g.balls)
+			g.printHUD(screen, fmt.Sprintf("kinetic energy: %.1f | momentum: (%.1f, %.1f) | avg speed: %.2f over %d moving particles",
+				e.kinetic, e.momentumX, e.momentumY, e.avgSpeed, e.count), 0, 16)
+		}
+	}
 
-	for i := range balls {
-		var col color.Color
-		switch balls[i].material {
-		case MaterialWater:
-			col = color.RGBA{R: 45, G: 134, B: 255, A: 200}
-		case MaterialGas:
-			col = color.RGBA{R: 220, G: 220, B: 255, A: 140}
-		case MaterialStatic:
-			col = color.RGBA{R: 180, G: 180, B: 195, A: 240}
-		default:
-			speed := balls[i].speed()
-			col = velocityToColor(speed, g.settings.maxSpeed)
+	g.drawPolygons(screen)
+	g.drawWalls(screen)
+	g.drawWells(screen)
+	g.drawCannons(screen)
+	g.drawEmitters(screen)
+	if g.showDensity && !g.hideHUD {
+		g.drawDensity(screen)
+	}
+
+	if factor := g.settings.renderDownscale; factor > 1 {
+		// Draw particles into a smaller buffer and stretch it over the window.
+		// World coordinates stay native, so only drawing is scaled.
+		w := int(g.width) / factor
+		h := int(g.height) / factor
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+		if g.lowResImage == nil || g.lowResImage.Bounds().Dx() != w || g.lowResImage.Bounds().Dy() != h {
+			if g.lowResImage != nil {
+				g.lowResImage.Deallocate()
+			}
+			g.lowResImage = ebiten.NewImage(w, h)
+		}
+		g.lowResImage.Clear()
+		g.drawParticles(g.lowResImage, 1/float32(factor))
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Scale(float64(factor), float64(factor))
+		op.Filter = ebiten.FilterNearest
+		screen.DrawImage(g.lowResImage, op)
+	} else {
+		g.drawParticles(screen, 1)
+	}
+
+	if !g.hideHUD {
+		g.drawZones(screen)
+
+		if g.showAxes {
+			drawAxes(screen)
+		}
+		if g.showVelocities {
+			g.drawVelocities(screen)
+		}
+		if g.showGrid {
+			g.drawGrid(screen)
+		}
+		if g.showHistogram {
+			g.drawSpeedHistogram(screen)
+		}
+
+		if g.selected >= 0 && g.selected < len(g.balls) {
+			g.drawInspector(screen)
+		}
+
+		for _, p := range g.staticOverlaps {
+			vector.StrokeCircle(screen, p.x, p.y, 8, 2, color.RGBA{255, 40, 40, 255}, false)
+		}
+
+		if !g.showMenu {
+			g.drawBrushPreview(screen)
 		}
-		drawShape(screen, balls[i].shape, balls[i].pos.x, balls[i].pos.y, balls[i].radius, col)
 	}
 
 	if g.showMenu {
 		// Draw semi-transparent overlay
 		overlayColor := color.RGBA{R: 0, G: 0, B: 0, A: 180}
-		vector.DrawFilledRect(screen, 0, 0, float32(screenWidth), float32(screenHeight), overlayColor, false)
+		vector.DrawFilledRect(screen, 0, 0, g.width, g.height, overlayColor, false)
 
 		// Menu title
-		menuX := float32(screenWidth)/2 - 200
-		menuY := float32(screenHeight)/2 - 250
+		menuX := g.width/2 - 200
+		menuY := g.height/2 - 250
 		title := "=== SETTINGS MENU ==="
 		ebitenutil.DebugPrintAt(screen, title, int(menuX), int(menuY))
 
 		menuY += 40
-		ebitenutil.DebugPrintAt(screen, "Use UP/DOWN arrows to navigate", int(menuX), int(menuY))
+		ebitenutil.DebugPrintAt(screen, "Use UP/DOWN arrows or click to select", int(menuX), int(menuY))
 		menuY += 15
-		ebitenutil.DebugPrintAt(screen, "Use MOUSE WHEEL to adjust values", int(menuX), int(menuY))
+		ebitenutil.DebugPrintAt(screen, "Use MOUSE WHEEL or drag left/right to adjust values", int(menuX), int(menuY))
 		menuY += 15
 		ebitenutil.DebugPrintAt(screen, "Hold SHIFT for faster changes", int(menuX), int(menuY))
 		menuY += 15
@@ -1418,8 +5491,48 @@ func (g *Game) Draw(screen *ebiten.Image) {
 		menuY += 40
 
 		// Menu options
+		spawnModeLabel := "Cluster"
+		if g.spawnScatter {
+			spawnModeLabel = "Scatter"
+		}
+		zoneModeLabel := "Override"
+		if g.settings.zoneAdditive {
+			zoneModeLabel = "Additive"
+		}
+		spawnLimitLabel := "off"
+		if g.settings.spawnRateLimit > 0 {
+			spawnLimitLabel = fmt.Sprintf("%d/s", g.settings.spawnRateLimit)
+		}
+		maxParticlesLabel := "off"
+		if g.settings.maxParticles > 0 {
+			maxParticlesLabel = fmt.Sprintf("%d", g.settings.maxParticles)
+		}
+		neighborCapLabel := "off"
+		if g.settings.neighborCap > 0 {
+			neighborCapLabel = fmt.Sprintf("%d", g.settings.neighborCap)
+		}
+		screenshotLabel := "Particles Only"
+		if g.settings.screenshotFull {
+			screenshotLabel = "Full Screen"
+		}
+		capModeLabel := "Refuse Spawns"
+		if g.settings.evictOldest {
+			capModeLabel = "Evict Oldest"
+		}
+		backgroundLabel := "Custom"
+		if i := backgroundIndex(g.settings.background); i >= 0 {
+			backgroundLabel = backgrounds[i].name
+		}
+		colorRampLabel := "Custom"
+		if i := colorRampIndex(g.settings.colorRamp); i >= 0 {
+			colorRampLabel = colorRamps[i].name
+		}
+		gasLifetimeLabel := "Gas Lifetime: forever"
+		if g.settings.gasLifetime > 0 {
+			gasLifetimeLabel = fmt.Sprintf("Gas Lifetime: %d ticks", g.settings.gasLifetime)
+		}
 		options := []string{
-			fmt.Sprintf("Gravity: %.2f", g.settings.gravity),
+			fmt.Sprintf("Gravity: %.2f", g.settings.gravityMagnitude()),
 			fmt.Sprintf("Max Speed: %.2f", g.settings.maxSpeed),
 			fmt.Sprintf("Move Away Distance: %.1f", g.settings.moveAwayDistance),
 			fmt.Sprintf("Move Away Strength: %.2f", g.settings.moveAwayStrength),
@@ -1430,27 +5543,149 @@ func (g *Game) Draw(screen *ebiten.Image) {
 			fmt.Sprintf("Ground Friction: %.2f", g.settings.groundFriction),
 			fmt.Sprintf("Spawn Count: %d", g.spawnClusterCount),
 			fmt.Sprintf("Top Barrier: %v", g.settings.hasTopBarrier),
+			fmt.Sprintf("Fluid Iterations: %d", g.settings.fluidIterations),
+			fmt.Sprintf("Update Sound: %v", g.settings.updateSound),
+			fmt.Sprintf("Calibrate Cell Size: %.0f", g.collider.cellSize),
+			fmt.Sprintf("Water-Gas Restitution: %.2f", g.settings.contacts[MaterialWater][MaterialGas].restitution),
+			fmt.Sprintf("Water-Gas Friction: %.2f", g.settings.contacts[MaterialWater][MaterialGas].friction),
+			fmt.Sprintf("Bubble Lift: %.2f", g.settings.bubbleLift),
+			fmt.Sprintf("Render Downscale: %dx", g.settings.renderDownscale),
+			"Compact Memory",
+			fmt.Sprintf("Foam Threshold: %.1f", g.settings.foamThreshold),
+			fmt.Sprintf("Spawn Mode: %s", spawnModeLabel),
+			fmt.Sprintf("Scatter Radius: %.0f", g.scatterRadius),
+			fmt.Sprintf("Zone Gravity X: %.2f", g.settings.zoneGravityX),
+			fmt.Sprintf("Zone Gravity Y: %.2f", g.settings.zoneGravityY),
+			fmt.Sprintf("Zone Mode: %s", zoneModeLabel),
+			fmt.Sprintf("GIF Frames: %d", g.settings.gifFrameCount),
+			fmt.Sprintf("GIF Downscale: %dx", g.settings.gifDownscale),
+			fmt.Sprintf("Spawn Rate Limit: %s", spawnLimitLabel),
+			fmt.Sprintf("Large Batch Cooldown: %.2fs", g.settings.spawnCooldown),
+			fmt.Sprintf("Profile: %s", g.settings.profileName()),
+			fmt.Sprintf("Collision Solves: %d", g.settings.collisionSolves),
+			fmt.Sprintf("Render Frame Skip: %d", g.settings.renderSkip),
+			fmt.Sprintf("Max Particles: %s", maxParticlesLabel),
+			fmt.Sprintf("Neighbor Cap: %s", neighborCapLabel),
+			fmt.Sprintf("Water Rest Density: %.2f", g.settings.waterRestDensity),
+			fmt.Sprintf("Water Stiffness: %.2f", g.settings.waterPressureStiff),
+			fmt.Sprintf("Cannon Interval: %.1fs", g.settings.cannonInterval),
+			fmt.Sprintf("Cannon Burst: %d", g.settings.cannonBurst),
+			fmt.Sprintf("Cannon Speed: %.1f", g.settings.cannonSpeed),
+			fmt.Sprintf("Check Static Overlaps (%d marked)", len(g.staticOverlaps)),
+			fmt.Sprintf("Idle Hint: %v", g.settings.idleHint),
+			fmt.Sprintf("Restore Workspace On Launch: %v", g.restoreWorkspace),
+			fmt.Sprintf("Color Ramp: %s", colorRampLabel),
+			fmt.Sprintf("Spawn Mass: %.1fx", g.settings.spawnMass),
+			fmt.Sprintf("Gravity Angle: %.0f deg", g.settings.gravityAngle()),
+			fmt.Sprintf("Integration: %s", g.settings.integration),
+			fmt.Sprintf("Emitter Rate: %.0f/s", g.settings.emitterRate),
+			fmt.Sprintf("Emitter Speed: %.1f", g.settings.emitterSpeed),
+			fmt.Sprintf("At Particle Cap: %s", capModeLabel),
+			fmt.Sprintf("Spawn Temperature: %.0f", g.settings.spawnTemperature),
+			fmt.Sprintf("Boil Temperature: %.0f", g.settings.boilTemperature),
+			fmt.Sprintf("Condense Temperature: %.0f", g.settings.condenseTemperature),
+			fmt.Sprintf("Phase Change Rate: %.2f", g.settings.phaseRate),
+			fmt.Sprintf("GIF Capture FPS: %d", g.settings.gifFPS),
+			fmt.Sprintf("Screenshot: %s", screenshotLabel),
+			fmt.Sprintf("Ticks Per Second: %d", g.settings.tps),
+			fmt.Sprintf("Slow Motion: %.2fx", g.settings.timeScale),
+			fmt.Sprintf("Update Channel: %s", channelName(g.updateChannel)),
+			fmt.Sprintf("Antialiasing: %v", g.settings.antialias),
+			fmt.Sprintf("Background: %s", backgroundLabel),
+			fmt.Sprintf("Wind Strength: %.3f (W toggles, now %s)", g.settings.windStrength, onOff(g.settings.windOn)),
+			fmt.Sprintf("Wind Angle: %.0f deg", g.settings.windAngle),
+			fmt.Sprintf("Vortex Strength: %.2f", g.settings.vortexStrength),
+			fmt.Sprintf("Well Strength: %.0f (for new G wells)", g.settings.wellStrength),
+			fmt.Sprintf("Water Surface Tension: %.2f", g.settings.waterSurfaceTension),
+			fmt.Sprintf("Swept Collisions: %s (slower, stops fast solids tunneling)", onOff(g.settings.sweptCollisions)),
+			fmt.Sprintf("Solid Radius Min: %.1f", g.settings.solidRadiusMin),
+			fmt.Sprintf("Solid Radius Max: %.1f", g.settings.solidRadiusMax),
+			fmt.Sprintf("Water Radius Min: %.1f", g.settings.waterRadiusMin),
+			fmt.Sprintf("Water Radius Max: %.1f", g.settings.waterRadiusMax),
+			fmt.Sprintf("Gas Radius Min: %.1f", g.settings.gasRadiusMin),
+			fmt.Sprintf("Gas Radius Max: %.1f", g.settings.gasRadiusMax),
+			fmt.Sprintf("Sand Radius Min: %.1f", g.settings.sandRadiusMin),
+			fmt.Sprintf("Sand Radius Max: %.1f", g.settings.sandRadiusMax),
+			fmt.Sprintf("Move Attract Distance: %.0f (also Shift+wheel)", moveAttractDistance),
+			gasLifetimeLabel,
+			fmt.Sprintf("Load Preset: %s (click loads, scroll down picks)", scenePresets[g.presetChoice].label),
+			"Reset Settings",
 			"EXIT GAME",
 		}
 
-		for i, option := range options {
+		// Scroll the list so the selected row stays on screen.
+		visibleRows := (int(g.height) - int(menuY) - 30) / 20
+		if visibleRows < 1 {
+			visibleRows = 1
+		}
+		if g.selectedOption < g.menuScroll {
+			g.menuScroll = g.selectedOption
+		}
+		if g.selectedOption >= g.menuScroll+visibleRows {
+			g.menuScroll = g.selectedOption - visibleRows + 1
+		}
+		if g.menuScroll > len(options)-visibleRows {
+			g.menuScroll = max(0, len(options)-visibleRows)
+		}
+		if g.menuScroll > 0 {
+			ebitenutil.DebugPrintAt(screen, "  ...", int(menuX), int(menuY)-15)
+		}
+		g.menuRows = g.menuRows[:0]
+		for i := g.menuScroll; i < len(options) && i < g.menuScroll+visibleRows; i++ {
 			prefix := "  "
 			if i == g.selectedOption {
 				prefix = "> "
 			}
-			ebitenutil.DebugPrintAt(screen, prefix+option, int(menuX), int(menuY)+i*20)
+			rowY := int(menuY) + (i-g.menuScroll)*20
+			rect := image.Rect(int(menuX), rowY-2, int(menuX)+400, rowY+18)
+			g.menuRows = append(g.menuRows, menuRow{option: i, rect: rect})
+			if i == g.menuHover {
+				vector.DrawFilledRect(screen, float32(rect.Min.X), float32(rect.Min.Y), float32(rect.Dx()), float32(rect.Dy()), color.RGBA{R: 255, G: 255, B: 255, A: 30}, false)
+			}
+			ebitenutil.DebugPrintAt(screen, prefix+options[i], int(menuX), rowY)
+		}
+		if g.menuScroll+visibleRows < len(options) {
+			ebitenutil.DebugPrintAt(screen, "  ...", int(menuX), int(menuY)+visibleRows*20)
 		}
 	}
 
+	if g.hideHUD {
+		g.updateButtonHover = false
+		return
+	}
+
+	if g.spawnThrottled && mousePressed(ebiten.MouseButtonLeft) {
+		mx, my := cursorPosition()
+		ebitenutil.DebugPrintAt(screen, "spawn throttled", mx+12, my+12)
+	}
+
+	if g.paused && !g.showMenu {
+		msg := "PAUSED  (Space to resume, . to step)"
+		vector.DrawFilledRect(screen, 10, 65, float32(len(msg)*6+10), 20, color.RGBA{40, 40, 70, 220}, false)
+		ebitenutil.DebugPrintAt(screen, msg, 15, 68)
+	}
+
+	if g.gifRecording {
+		msg := fmt.Sprintf("Recording GIF... %d/%d (F10 to stop)", len(g.gifFrames), g.settings.gifFrameCount)
+		vector.DrawFilledRect(screen, 10, 40, float32(len(msg)*6+10), 20, color.RGBA{160, 30, 30, 220}, false)
+		ebitenutil.DebugPrintAt(screen, msg, 15, 43)
+	}
+
+	if g.tutorialStep >= 0 && !g.showMenu {
+		g.drawTutorial(screen)
+	} else if len(g.balls) == 0 && g.settings.idleHint && !g.idleHintDismissed && !g.showMenu {
+		drawHintBox(screen, idleHintText)
+	}
+
 	// Draw update button in top-right corner
 	if !g.showMenu {
 		buttonWidth := float32(140)
 		buttonHeight := float32(30)
-		buttonX := float32(screenWidth) - buttonWidth - 10
+		buttonX := g.width - buttonWidth - 10
 		buttonY := float32(10)
 
 		// Check if mouse is hovering over button
-		mx, my := ebiten.CursorPosition()
+		mx, my := cursorPosition()
 		g.updateButtonHover = float32(mx) >= buttonX && float32(mx) <= buttonX+buttonWidth &&
 			float32(my) >= buttonY && float32(my) <= buttonY+buttonHeight
 
@@ -1476,72 +5711,925 @@ func (g *Game) Draw(screen *ebiten.Image) {
 
 		// Draw button text
 		buttonText := "Check Updates"
-		if g.updateChecking {
+		if g.updateChecking && g.updateButtonHover {
+			buttonText = "Cancel Check"
+		} else if g.updateChecking {
 			buttonText = "Checking..."
 		} else if g.updateAvailable {
 			buttonText = "Update Available!"
 		}
 		ebitenutil.DebugPrintAt(screen, buttonText, int(buttonX+8), int(buttonY+10))
 
-		// Show update message if available
-		if g.updateMessage != "" {
-			msgX := buttonX - 150
-			msgY := buttonY + buttonHeight + 5
-			msgWidth := float32(290)
-			msgHeight := float32(30)
+		// Show update message if available
+		if g.updateMessage != "" {
+			msgX := buttonX - 150
+			msgY := buttonY + buttonHeight + 5
+			msgWidth := float32(290)
+			msgHeight := float32(30)
+
+			// Message background
+			vector.DrawFilledRect(screen, msgX, msgY, msgWidth, msgHeight, color.RGBA{40, 40, 50, 220}, false)
+			ebitenutil.DebugPrintAt(screen, g.updateMessage, int(msgX+5), int(msgY+10))
+		}
+	}
+}
+
+const (
+	axisTickSpacing  = 50
+	axisLabelSpacing = 200
+)
+
+// drawAxes marks the world origin, the X and Y axes and evenly spaced ticks.
+func drawAxes(screen *ebiten.Image) {
+	axisColor := color.RGBA{255, 90, 90, 220}
+	tickColor := color.RGBA{255, 90, 90, 160}
+	sw, sh := screen.Bounds().Dx(), screen.Bounds().Dy()
+	w, h := float32(sw), float32(sh)
+	vector.StrokeLine(screen, 0, 1, w, 1, 2, axisColor, false)
+	vector.StrokeLine(screen, 1, 0, 1, h, 2, axisColor, false)
+	vector.DrawFilledCircle(screen, 0, 0, 6, axisColor, false)
+	ebitenutil.DebugPrintAt(screen, "(0,0)", 8, 20)
+
+	for x := axisTickSpacing; x < sw; x += axisTickSpacing {
+		length := float32(6)
+		if x%axisLabelSpacing == 0 {
+			length = 12
+			ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%d", x), x-10, 20)
+		}
+		vector.StrokeLine(screen, float32(x), 0, float32(x), length, 1, tickColor, false)
+	}
+	for y := axisTickSpacing; y < sh; y += axisTickSpacing {
+		length := float32(6)
+		if y%axisLabelSpacing == 0 {
+			length = 12
+			ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%d", y), 16, y-8)
+		}
+		vector.StrokeLine(screen, 0, float32(y), length, float32(y), 1, tickColor, false)
+	}
+}
+
+// drawDensity draws a translucent blob behind each water particle colored by
+// the density from the last fluid step, blue when empty through red at twice
+// the rest density. Overlapping blobs add up, so packed water glows hotter.
+func (g *Game) drawDensity(screen *ebiten.Image) {
+	if len(g.waterIndices) == 0 {
+		return
+	}
+	high := 2 * g.settings.waterRestDensity
+	for slot, i := range g.waterIndices {
+		if slot >= len(g.waterDensity) || i >= len(g.balls) {
+			break
+		}
+		c := velocityToColor(g.waterDensity[slot], high, temperatureRamp).(color.RGBA)
+		heat := color.NRGBA{R: c.R, G: c.G, B: c.B, A: 50}
+		vector.DrawFilledCircle(screen, g.balls[i].pos.x, g.balls[i].pos.y, g.balls[i].radius*2, heat, false)
+	}
+}
+
+// energyStats sums motion over the particles that can move.
+type energyStats struct {
+	kinetic   float64 // sum of mass*v*v/2
+	momentumX float64
+	momentumY float64
+	avgSpeed  float64
+	count     int
+}
+
+// measureEnergy totals kinetic energy and momentum, skipping immovable
+// (zero-mass) particles.
+func measureEnergy(balls []Ball) energyStats {
+	var e energyStats
+	speedSum := 0.0
+	for i := range balls {
+		b := &balls[i]
+		if b.mass == 0 {
+			continue
+		}
+		m, vx, vy := float64(b.mass), float64(b.velocity.vx), float64(b.velocity.vy)
+		e.kinetic += 0.5 * m * (vx*vx + vy*vy)
+		e.momentumX += m * vx
+		e.momentumY += m * vy
+		speedSum += float64(b.speed())
+		e.count++
+	}
+	if e.count > 0 {
+		e.avgSpeed = speedSum / float64(e.count)
+	}
+	return e
+}
+
+const (
+	speedHistogramBins   = 20
+	speedHistogramBarW   = 8  // Width of one bar in pixels
+	speedHistogramHeight = 80 // Height of the tallest bar in pixels
+	speedHistogramMargin = 10 // Gap to the bottom-left corner of the screen
+)
+
+// drawSpeedHistogram counts moving particles into speed bins from 0 to
+// maxSpeed, with anything faster in the last bin, and draws the counts as
+// bars in the bottom-left corner scaled to the fullest bin.
+func (g *Game) drawSpeedHistogram(screen *ebiten.Image) {
+	g.speedBins = [speedHistogramBins]int{}
+	if g.settings.maxSpeed <= 0 {
+		return
+	}
+	binWidth := g.settings.maxSpeed / speedHistogramBins
+	for i := range g.balls {
+		if g.balls[i].mass == 0 {
+			continue
+		}
+		bin := max(0, min(int(g.balls[i].speed()/binWidth), speedHistogramBins-1))
+		g.speedBins[bin]++
+	}
+	fullest := 1
+	for _, n := range g.speedBins {
+		fullest = max(fullest, n)
+	}
+
+	left := float32(speedHistogramMargin)
+	bottom := g.height - speedHistogramMargin
+	width := float32(speedHistogramBins * speedHistogramBarW)
+	vector.DrawFilledRect(screen, left-4, bottom-speedHistogramHeight-20, width+8, speedHistogramHeight+24, color.RGBA{20, 20, 30, 200}, false)
+	barColor := color.RGBA{R: 90, G: 200, B: 255, A: 230}
+	for i, n := range g.speedBins {
+		h := float32(n) / float32(fullest) * speedHistogramHeight
+		x := left + float32(i*speedHistogramBarW)
+		vector.DrawFilledRect(screen, x, bottom-h, speedHistogramBarW-1, h, barColor, false)
+	}
+	ebitenutil.DebugPrintAt(screen, fmt.Sprintf("speed 0-%.1f", g.settings.maxSpeed), int(left), int(bottom)-speedHistogramHeight-18)
+}
+
+// gridLabelMinCell is the smallest cell size, in pixels, that still has room
+// for an occupancy count.
+const gridLabelMinCell = 24
+
+// drawGrid outlines every occupied cell of the collision grid from the last
+// step and, when cells are large enough, prints how many particles each holds.
+func (g *Game) drawGrid(screen *ebiten.Image) {
+	h := &g.collider
+	size := h.cellSize
+	outline := color.RGBA{R: 80, G: 255, B: 120, A: 160}
+	for _, key := range h.usedKeys {
+		// Cells emptied by move stay listed until the next Clear.
+		if len(h.buckets[key]) == 0 {
+			continue
+		}
+		ix, iy := keyCell(key)
+		x, y := float32(ix)*size, float32(iy)*size
+		vector.StrokeRect(screen, x, y, size, size, 1, outline, false)
+		if size >= gridLabelMinCell {
+			ebitenutil.DebugPrintAt(screen, fmt.Sprintf("%d", len(h.buckets[key])), int(x)+2, int(y)+1)
+		}
+	}
+}
+
+const (
+	velocityLineScale = float32(4)  // Pixels drawn per unit of speed
+	velocityLineMax   = float32(40) // Longest line drawn, however fast the particle
+)
+
+// drawVelocities draws a line from each moving particle's center along its
+// velocity, with length proportional to speed up to velocityLineMax.
+func (g *Game) drawVelocities(screen *ebiten.Image) {
+	lineColor := color.RGBA{R: 255, G: 220, B: 60, A: 200}
+	for i := range g.balls {
+		b := &g.balls[i]
+		if b.material == MaterialStatic || b.mass == 0 {
+			continue
+		}
+		speed := b.speed()
+		if speed == 0 {
+			continue
+		}
+		length := min(speed*velocityLineScale, velocityLineMax)
+		x2 := b.pos.x + b.velocity.vx/speed*length
+		y2 := b.pos.y + b.velocity.vy/speed*length
+		vector.StrokeLine(screen, b.pos.x, b.pos.y, x2, y2, 1, lineColor, false)
+	}
+}
+
+// drawParticles draws every particle onto target with positions and radii
+// multiplied by scale.
+func (g *Game) drawParticles(target *ebiten.Image, scale float32) {
+	// The temperature view needs per-particle colors, so it keeps circles.
+	fluid := g.metaballs && !g.showTemperature
+	if fluid {
+		g.drawMetaballs(target, scale)
+	}
+	var shade []float32
+	if g.gasShading && !g.showTemperature {
+		shade = g.gasShadeByBall()
+	}
+	for i := range g.balls {
+		if fluid && g.balls[i].material == MaterialWater {
+			continue
+		}
+		pos := g.renderPos(i)
+		if g.offScreen(pos, g.balls[i].radius) {
+			continue
+		}
+		var col color.Color
+		switch g.balls[i].material {
+		case MaterialWater:
+			col = waterColor(g.balls[i].speed(), g.settings.foamThreshold, g.settings.maxSpeed)
+		case MaterialGas:
+			col = color.RGBA{R: 220, G: 220, B: 255, A: 140}
+			if shade != nil {
+				col = gasDensityColor(shade[i])
+			}
+		case MaterialStatic:
+			col = color.RGBA{R: 180, G: 180, B: 195, A: 240}
+		case MaterialSand:
+			col = color.RGBA{R: 210, G: 180, B: 120, A: 255}
+		default:
+			speed := g.balls[i].speed()
+			col = velocityToColor(speed, g.settings.maxSpeed, g.settings.colorRamp)
+		}
+		if g.showTemperature {
+			col = temperatureColor(g.balls[i].temperature)
+		}
+		if g.balls[i].material == MaterialGas && g.settings.gasLifetime > 0 {
+			col = fadeColor(col, gasFade(g.balls[i].age, g.settings.gasLifetime))
+		}
+		drawShape(target, g.balls[i].shape, pos.x*scale, pos.y*scale, g.balls[i].radius*scale, col, g.settings.antialias)
+		if g.balls[i].frozen {
+			vector.StrokeCircle(target, pos.x*scale, pos.y*scale, g.balls[i].radius*scale, 1, frozenOutline, g.settings.antialias)
+		}
+	}
+}
+
+// offScreen reports whether a shape of radius r centred on pos lies wholly
+// outside the window Layout last reported, so drawing it would change nothing.
+// Culled particles are still simulated.
+func (g *Game) offScreen(pos Pos, r float32) bool {
+	r *= 1.2 // A triangle's top vertex reaches past its radius
+	return pos.x+r < 0 || pos.y+r < 0 || pos.x-r > g.width || pos.y-r > g.height
+}
+
+// renderSample is a particle's position before the last step, tagged with
+// its seq so it is only used for the same particle.
+type renderSample struct {
+	seq uint64
+	pos Pos
+}
+
+// renderPos is where particle i is drawn: between its position before the
+// last step and now, by renderAlpha, so motion stays smooth when a tick runs
+// no step or several. It falls back to the exact position when interpolation
+// is off or index i held a different particle (or none) when renderPrev was
+// taken, as after spawning or a swap-remove.
+func (g *Game) renderPos(i int) Pos {
+	cur := g.balls[i].pos
+	if g.exactPositions || i >= len(g.renderPrev) || g.renderPrev[i].seq != g.balls[i].seq {
+		return cur
+	}
+	prev := g.renderPrev[i].pos
+	return Pos{x: prev.x + (cur.x-prev.x)*g.renderAlpha, y: prev.y + (cur.y-prev.y)*g.renderAlpha}
+}
+
+const (
+	metaballCell  = 4   // Field resolution in target pixels per sample
+	metaballReach = 2.5 // How far a water particle's field reaches, in radii
+)
+
+// metaballThreshold is the field value at one radius from a lone particle, so
+// an isolated drop draws at its real size.
+var metaballThreshold = float32((1 - 1/(metaballReach*metaballReach)) * (1 - 1/(metaballReach*metaballReach)))
+
+// drawMetaballs draws water as one connected surface. Each water particle adds
+// a smooth falloff to a coarse scalar field, and samples above
+// metaballThreshold are filled, with a short alpha ramp around it so edges
+// stay soft once the field is stretched over the target.
+func (g *Game) drawMetaballs(target *ebiten.Image, scale float32) {
+	w := target.Bounds().Dx()/metaballCell + 1
+	h := target.Bounds().Dy()/metaballCell + 1
+	if cap(g.metaField) < w*h {
+		g.metaField = make([]float32, w*h)
+	}
+	g.metaField = g.metaField[:w*h]
+	clear(g.metaField)
+
+	cell := metaballCell / scale // world units per sample
+	found := false
+	for i := range g.balls {
+		b := &g.balls[i]
+		if b.material != MaterialWater {
+			continue
+		}
+		found = true
+		pos := g.renderPos(i)
+		reach := b.radius * metaballReach
+		reach2 := reach * reach
+		x0 := max(0, int((pos.x-reach)/cell))
+		x1 := min(w-1, int((pos.x+reach)/cell))
+		y0 := max(0, int((pos.y-reach)/cell))
+		y1 := min(h-1, int((pos.y+reach)/cell))
+		for y := y0; y <= y1; y++ {
+			dy := (float32(y)+0.5)*cell - pos.y
+			row := g.metaField[y*w : (y+1)*w]
+			for x := x0; x <= x1; x++ {
+				dx := (float32(x)+0.5)*cell - pos.x
+				if d2 := dx*dx + dy*dy; d2 < reach2 {
+					f := 1 - d2/reach2
+					row[x] += f * f
+				}
+			}
+		}
+	}
+	if !found {
+		return
+	}
+
+	if len(g.metaPixels) != 4*w*h {
+		g.metaPixels = make([]byte, 4*w*h)
+	}
+	c := waterCalmColor
+	lo, hi := metaballThreshold*0.8, metaballThreshold*1.2
+	for i, v := range g.metaField {
+		t := min(1, max(0, (v-lo)/(hi-lo)))
+		a := float32(c.A) * t
+		// WritePixels takes premultiplied alpha.
+		g.metaPixels[4*i] = uint8(float32(c.R) * a / 255)
+		g.metaPixels[4*i+1] = uint8(float32(c.G) * a / 255)
+		g.metaPixels[4*i+2] = uint8(float32(c.B) * a / 255)
+		g.metaPixels[4*i+3] = uint8(a)
+	}
+	if g.metaImage == nil || g.metaImage.Bounds().Dx() != w || g.metaImage.Bounds().Dy() != h {
+		if g.metaImage != nil {
+			g.metaImage.Deallocate()
+		}
+		g.metaImage = ebiten.NewImage(w, h)
+	}
+	g.metaImage.WritePixels(g.metaPixels)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(metaballCell, metaballCell)
+	op.Filter = ebiten.FilterLinear
+	target.DrawImage(g.metaImage, op)
+}
+
+// gasDenseWeight is the gas density drawn fully opaque. Density sums
+// (1 - d/gasInteraction)^2 over neighbors, so six neighbors at the rest
+// distance come to about 0.67.
+const gasDenseWeight = 0.6
+
+// gasShadeByBall spreads the gas densities from the last step over ball
+// indices, leaving 0 for gas spawned since then.
+func (g *Game) gasShadeByBall() []float32 {
+	if cap(g.gasShade) < len(g.balls) {
+		g.gasShade = make([]float32, len(g.balls))
+	}
+	g.gasShade = g.gasShade[:len(g.balls)]
+	clear(g.gasShade)
+	for slot, i := range g.gasIndices {
+		if slot < len(g.gasDensity) && i < len(g.balls) {
+			g.gasShade[i] = g.gasDensity[slot]
+		}
+	}
+	return g.gasShade
+}
+
+// gasDensityColor draws sparse gas as a faint blue haze and dense gas as
+// nearly opaque white smoke.
+func gasDensityColor(density float32) color.RGBA {
+	t := max(0, min(1, density/gasDenseWeight))
+	return color.RGBA{R: uint8(190 + 60*t), G: uint8(190 + 60*t), B: 255, A: uint8(30 + 210*t)}
+}
+
+// gasFadeShare is the last part of a gas particle's life over which it fades.
+const gasFadeShare = 0.25
+
+// gasFade is the opacity, from 1 down to 0, of gas aged age steps out of
+// lifetime.
+func gasFade(age, lifetime int) float32 {
+	left := float32(lifetime-age) / (gasFadeShare * float32(lifetime))
+	return max(0, min(1, left))
+}
+
+// fadeColor scales every channel of col by f, which fades a premultiplied
+// color toward transparent.
+func fadeColor(col color.Color, f float32) color.Color {
+	r, g, b, a := col.RGBA()
+	return color.RGBA64{R: uint16(float32(r) * f), G: uint16(float32(g) * f), B: uint16(float32(b) * f), A: uint16(float32(a) * f)}
+}
+
+var frozenOutline = color.RGBA{R: 170, G: 230, B: 255, A: 255}
+
+var (
+	brushPreviewColor = color.RGBA{R: 255, G: 255, B: 255, A: 160}
+	toolReachColor    = color.RGBA{R: 255, G: 255, B: 255, A: 50}
+)
+
+// drawBrushPreview outlines the size the next spawn will have at the cursor
+// and, while the right button is held, how far the push, pull or swirl reaches.
+func (g *Game) drawBrushPreview(screen *ebiten.Image) {
+	x, y := cursorPosition()
+	cx, cy := float32(x), float32(y)
+	r := g.settings.spawnRadius(currentShape, ballsize)
+	vector.StrokeCircle(screen, cx, cy, r, 1, brushPreviewColor, g.settings.antialias)
+	if mousePressed(ebiten.MouseButtonRight) {
+		reach := g.settings.moveAwayDistance
+		if keyPressed(ebiten.KeyShift) || keyPressed(ebiten.KeyControl) || keyPressed(ebiten.KeyMeta) {
+			reach = float32(moveAttractDistance)
+		}
+		vector.StrokeCircle(screen, cx, cy, reach, 1, toolReachColor, g.settings.antialias)
+	}
+}
+
+// gifCaptureInterval is how many ticks pass between captured frames to get
+// close to fps.
+func gifCaptureInterval(fps int) int {
+	return max(1, int(math.Round(float64(ebiten.TPS())/float64(fps))))
+}
+
+// gifFrameDelay is the per-frame delay in 1/100 s that plays frames taken
+// every interval ticks back at real-time speed.
+func gifFrameDelay(interval int) int {
+	return max(2, int(math.Round(100*float64(interval)/float64(ebiten.TPS()))))
+}
+
+// captureGIFFrame renders the particles into a downscaled offscreen image,
+// quantizes it to a fixed palette and appends it to the capture. Once the
+// frame limit is reached the capture is finished and encoded.
+func (g *Game) captureGIFFrame() {
+	factor := g.settings.gifDownscale
+	w := int(g.width) / factor
+	h := int(g.height) / factor
+	if w < 1 || h < 1 {
+		g.gifRecording = false
+		return
+	}
+	if g.gifImage == nil || g.gifImage.Bounds().Dx() != w || g.gifImage.Bounds().Dy() != h {
+		if g.gifImage != nil {
+			g.gifImage.Deallocate()
+		}
+		g.gifImage = ebiten.NewImage(w, h)
+	}
+	g.gifImage.Fill(g.settings.background)
+	g.drawParticles(g.gifImage, 1/float32(factor))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	g.gifImage.ReadPixels(rgba.Pix)
+	frame := image.NewPaletted(rgba.Bounds(), palette.Plan9)
+	draw.Draw(frame, frame.Bounds(), rgba, image.Point{}, draw.Src)
+	g.gifFrames = append(g.gifFrames, frame)
+
+	if len(g.gifFrames) >= g.settings.gifFrameCount {
+		g.finishGIF()
+	}
+}
+
+// finishGIF stops the capture and encodes the frames taken so far in the
+// background. The encoder reports back through fileMessages, since only the
+// game goroutine may touch g.
+func (g *Game) finishGIF() {
+	g.gifRecording = false
+	frames := g.gifFrames
+	g.gifFrames = nil
+	if len(frames) == 0 {
+		return
+	}
+	delay := gifFrameDelay(gifCaptureInterval(g.settings.gifFPS))
+	g.updateMessage = "Encoding GIF..."
+	go func() {
+		filename := fmt.Sprintf("phixgo-capture-%s.gif", time.Now().Format("20060102-150405"))
+		if err := writeGIF(filename, frames, delay); err != nil {
+			g.fileMessages <- fmt.Sprintf("GIF failed: %v", err)
+			return
+		}
+		g.fileMessages <- fmt.Sprintf("Saved GIF: %s", filename)
+	}()
+}
+
+// saveScreenshot writes a timestamped PNG. Unless screenshotFull is set it
+// renders only the obstacles and particles to an offscreen image, leaving out
+// the HUD, menu and update button; otherwise it copies the finished screen.
+// The PNG is written in the background and reported through fileMessages.
+func (g *Game) saveScreenshot(screen *ebiten.Image) {
+	src := screen
+	if !g.settings.screenshotFull {
+		src = ebiten.NewImage(screen.Bounds().Dx(), screen.Bounds().Dy())
+		defer src.Deallocate()
+		src.Fill(g.settings.background)
+		g.drawPolygons(src)
+		g.drawWalls(src)
+		g.drawParticles(src, 1)
+	}
+	rgba := image.NewRGBA(src.Bounds())
+	src.ReadPixels(rgba.Pix)
+	go func() {
+		filename := fmt.Sprintf("phixgo-screenshot-%s.png", time.Now().Format("20060102-150405"))
+		if err := writePNG(filename, rgba); err != nil {
+			g.fileMessages <- fmt.Sprintf("Screenshot failed: %v", err)
+			return
+		}
+		g.fileMessages <- fmt.Sprintf("Saved screenshot: %s", filename)
+	}()
+}
+
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create PNG file: %w", err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return nil
+}
+
+func writeGIF(filename string, frames []*image.Paletted, delay int) error {
+	anim := &gif.GIF{
+		Image: frames,
+		Delay: make([]int, len(frames)),
+	}
+	for i := range anim.Delay {
+		anim.Delay[i] = delay
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create GIF file: %w", err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, anim); err != nil {
+		return fmt.Errorf("failed to encode GIF: %w", err)
+	}
+	return nil
+}
+
+// forceArrowScale converts a per-frame velocity change into an arrow length in pixels.
+const forceArrowScale = float32(60)
 
-			// Message background
-			vector.DrawFilledRect(screen, msgX, msgY, msgWidth, msgHeight, color.RGBA{40, 40, 50, 220}, false)
-			ebitenutil.DebugPrintAt(screen, g.updateMessage, int(msgX+5), int(msgY+10))
+func (g *Game) drawInspector(screen *ebiten.Image) {
+	b := &g.balls[g.selected]
+	materialLabel := materialTitle(b.material)
+	vector.StrokeCircle(screen, b.pos.x, b.pos.y, b.radius+3, 1, color.RGBA{255, 255, 0, 255}, false)
+
+	forcesLabel := "off"
+	if g.showForces {
+		forcesLabel = "on"
+	}
+	info := fmt.Sprintf("Inspector #%d | %s | r: %.1f | pos: %.1f, %.1f | vel: %.2f, %.2f | speed: %.2f | forces (I): %s",
+		g.selected, materialLabel, b.radius, b.pos.x, b.pos.y, b.velocity.vx, b.velocity.vy, b.speed(), forcesLabel)
+	ebitenutil.DebugPrintAt(screen, info, 10, int(g.height)-20)
+
+	if !g.showForces {
+		return
+	}
+	arrows := []struct {
+		label string
+		v     Velocity
+		col   color.RGBA
+	}{
+		{"gravity", g.forces.gravity, color.RGBA{255, 255, 255, 255}},
+		{"buoyancy", g.forces.buoyancy, color.RGBA{200, 200, 255, 255}},
+		{"pressure", g.forces.pressure, color.RGBA{255, 80, 80, 255}},
+		{"viscosity", g.forces.viscosity, color.RGBA{80, 255, 80, 255}},
+		{"boundary", g.forces.boundary, color.RGBA{255, 160, 40, 255}},
+		{"tool", g.forces.tool, color.RGBA{255, 80, 255, 255}},
+		{"contact", g.forces.contact, color.RGBA{80, 220, 255, 255}},
+		{"wind", g.forces.wind, color.RGBA{180, 255, 200, 255}},
+		{"tension", g.forces.tension, color.RGBA{120, 170, 255, 255}},
+	}
+	for _, a := range arrows {
+		if a.v.vx == 0 && a.v.vy == 0 {
+			continue
 		}
+		drawArrow(screen, b.pos.x, b.pos.y, a.v.vx*forceArrowScale, a.v.vy*forceArrowScale, a.col)
+		nx, ny, length := normalize(a.v.vx, a.v.vy)
+		length = float32(math.Min(float64(length*forceArrowScale), 150))
+		ebitenutil.DebugPrintAt(screen, a.label, int(b.pos.x+nx*(length+6)), int(b.pos.y+ny*(length+6)))
 	}
 }
 
-func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+// drawArrow draws a line from (x, y) along (dx, dy) with a small head, capping the length.
+func drawArrow(screen *ebiten.Image, x, y, dx, dy float32, col color.Color) {
+	nx, ny, length := normalize(dx, dy)
+	if length > 150 {
+		length = 150
+	}
+	ex := x + nx*length
+	ey := y + ny*length
+	vector.StrokeLine(screen, x, y, ex, ey, 2, col, false)
+	head := float32(6)
+	vector.StrokeLine(screen, ex, ey, ex-nx*head-ny*head*0.6, ey-ny*head+nx*head*0.6, 2, col, false)
+	vector.StrokeLine(screen, ex, ey, ex-nx*head+ny*head*0.6, ey-ny*head-nx*head*0.6, 2, col, false)
+}
+
+// Layout follows the window size: overlays are drawn against the new screen
+// size and the simulation walls move with the window edges.
+func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	if outsideWidth > 0 && outsideHeight > 0 {
+		g.SetBounds(float32(outsideWidth), float32(outsideHeight))
+	}
 	return outsideWidth, outsideHeight
 }
 
 // GitHubRelease represents a GitHub release
 type GitHubRelease struct {
-	TagName string `json:"tag_name"`
-	Assets  []struct {
+	TagName    string `json:"tag_name"`
+	Prerelease bool   `json:"prerelease"`
+	Draft      bool   `json:"draft"`
+	Assets     []struct {
 		Name               string `json:"name"`
 		BrowserDownloadURL string `json:"browser_download_url"`
 	} `json:"assets"`
 }
 
-// checkForUpdates checks if a newer version is available on GitHub
-func checkForUpdates() (*GitHubRelease, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases/latest", githubOwner, githubRepo)
-	resp, err := http.Get(url)
+const (
+	updateCheckTimeout    = 15 * time.Second
+	updateDownloadTimeout = 5 * time.Minute
+)
+
+// updateClient makes every updater request. Its timeout bounds a whole
+// request including the body, so it is sized for the download; the release
+// check is given a shorter deadline through its context.
+var updateClient = &http.Client{Timeout: updateDownloadTimeout}
+
+// Update channels. Stable only sees full releases; beta also sees
+// pre-releases.
+const (
+	channelStable = "stable"
+	channelBeta   = "beta"
+)
+
+// channelName maps a stored channel to its name, defaulting to stable.
+func channelName(channel string) string {
+	if channel == channelBeta {
+		return channelBeta
+	}
+	return channelStable
+}
+
+// checkForUpdates checks if a newer version is available on GitHub. The
+// stable channel asks for the latest full release; beta lists recent
+// releases and takes the newest one, pre-releases included.
+func checkForUpdates(ctx context.Context, channel string) (*GitHubRelease, error) {
+	base := fmt.Sprintf("https://api.github.com/repos/%s/%s/releases", githubOwner, githubRepo)
+	var release *GitHubRelease
+	if channelName(channel) == channelBeta {
+		var releases []GitHubRelease
+		if err := getGitHubJSON(ctx, base+"?per_page=30", &releases); err != nil {
+			return nil, err
+		}
+		var newest semVersion
+		for i := range releases {
+			v, err := parseSemver(releases[i].TagName)
+			if err != nil || releases[i].Draft {
+				continue
+			}
+			if release == nil || compareSemver(v, newest) > 0 {
+				release, newest = &releases[i], v
+			}
+		}
+		if release == nil {
+			return nil, nil
+		}
+	} else {
+		release = &GitHubRelease{}
+		if err := getGitHubJSON(ctx, base+"/latest", release); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only a strictly newer release counts; a tag that doesn't parse is
+	// treated as no update rather than offered blindly.
+	latest, err := parseSemver(release.TagName)
+	if err != nil {
+		return nil, nil
+	}
+	current, err := parseSemver(version)
+	if err != nil || compareSemver(latest, current) <= 0 {
+		return nil, nil // No update available
+	}
+
+	return release, nil
+}
+
+// getGitHubJSON fetches url from the GitHub API and decodes the response into v.
+func getGitHubJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to check for updates: %w", err)
+	}
+	resp, err := updateClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check for updates: %w", err)
+		return fmt.Errorf("failed to check for updates: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+		return fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
 	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse release info: %w", err)
+	}
+	return nil
+}
+
+// semVersion is a parsed semantic version. Build metadata is dropped since it
+// does not affect precedence.
+type semVersion struct {
+	major, minor, patch int
+	pre                 []string
+}
 
-	var release GitHubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return nil, fmt.Errorf("failed to parse release info: %w", err)
+// parseSemver parses MAJOR.MINOR.PATCH with an optional leading v, a
+// -prerelease suffix and +build metadata. Missing minor or patch numbers count
+// as 0, so a v2 tag is read as v2.0.0.
+func parseSemver(s string) (semVersion, error) {
+	var v semVersion
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre := s[i+1:]
+		s = s[:i]
+		if pre == "" {
+			return v, fmt.Errorf("empty pre-release suffix")
+		}
+		v.pre = strings.Split(pre, ".")
+		for _, id := range v.pre {
+			if id == "" {
+				return v, fmt.Errorf("empty pre-release identifier in %q", pre)
+			}
+		}
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("too many version numbers in %q", s)
+	}
+	nums := [3]int{}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part[0] == '+' {
+			return v, fmt.Errorf("invalid version number %q", part)
+		}
+		nums[i] = n
 	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, nil
+}
 
-	if release.TagName == version {
-		return nil, nil // No update available
+// compareSemver returns -1, 0 or 1 as a is older than, the same as or newer
+// than b, following semver precedence: a pre-release sorts before its
+// release, numeric identifiers compare numerically and sort before text ones.
+func compareSemver(a, b semVersion) int {
+	for _, d := range [3]int{a.major - b.major, a.minor - b.minor, a.patch - b.patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		an, aErr := strconv.Atoi(a.pre[i])
+		bn, bErr := strconv.Atoi(b.pre[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return sign(an - bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a.pre[i], b.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return sign(len(a.pre) - len(b.pre))
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// updateCheckResult is what the update check goroutine hands back. Update
+// applies it, so the game state is only touched on the game goroutine.
+type updateCheckResult struct {
+	release *GitHubRelease
+	err     error
+}
+
+// finishUpdateCheck reports a finished update check on the button and plays
+// the update sound if it is enabled.
+func (g *Game) finishUpdateCheck(res updateCheckResult) {
+	g.updateChecking = false
+	g.updateResult = nil
+	if res.err != nil {
+		switch {
+		case errors.Is(res.err, context.DeadlineExceeded):
+			g.updateMessage = fmt.Sprintf("Error: no answer after %s", updateCheckTimeout)
+		case errors.Is(res.err, context.Canceled):
+			g.updateMessage = "Update check cancelled"
+		default:
+			g.updateMessage = fmt.Sprintf("Error: %v", res.err)
+		}
+		return
+	}
+	if res.release == nil {
+		g.updateMessage = fmt.Sprintf("Up to date! (%s)", version)
+		g.updateAvailable = false
+	} else {
+		g.updateMessage = fmt.Sprintf("New version: %s", res.release.TagName)
+		if assetURL(res.release, checksumsAssetName) == "" {
+			g.updateMessage += " (no checksums, --update will refuse it)"
+		}
+		g.updateAvailable = true
+	}
+	if g.settings.updateSound {
+		playUpdateSound(res.release != nil)
+	}
+}
+
+// Update check sounds go through ebiten/audio, which owns the one audio
+// context a process may have. The context is created on first use, so
+// players who never turn the sound on never open an audio device. A device
+// that fails to open ends RunGame; see gameLoopFailed.
+const beepSampleRate = 44100
+
+var beepPlayer *audio.Player
+
+// toneSamples renders each frequency for durationMs as 16-bit stereo PCM,
+// the format ebiten/audio plays, with short fades at both ends so the tones
+// don't click.
+func toneSamples(durationMs int, freqs ...float64) []byte {
+	perTone := beepSampleRate * durationMs / 1000
+	fade := perTone / 10
+	buf := make([]byte, 0, perTone*len(freqs)*4)
+	for _, freq := range freqs {
+		for i := 0; i < perTone; i++ {
+			amp := 0.3
+			if i < fade {
+				amp *= float64(i) / float64(fade)
+			} else if i > perTone-fade {
+				amp *= float64(perTone-i) / float64(fade)
+			}
+			v := int16(amp * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/beepSampleRate))
+			buf = append(buf, byte(v), byte(v>>8), byte(v), byte(v>>8))
+		}
+	}
+	return buf
+}
+
+// playUpdateSound plays a single tone when up to date and a rising pair when
+// an update is available. It must run on the game goroutine.
+func playUpdateSound(available bool) {
+	ctx := audio.CurrentContext()
+	if ctx == nil {
+		ctx = audio.NewContext(beepSampleRate)
+	}
+	pcm := toneSamples(120, 660)
+	if available {
+		pcm = toneSamples(90, 660, 990)
 	}
+	// Kept so the player isn't collected while it plays.
+	beepPlayer = audio.NewPlayerFromBytes(ctx, pcm)
+	beepPlayer.Play()
+}
 
-	return &release, nil
+// gameLoopFailed handles an error that ended RunGame. ebiten/audio reports a
+// failed device init through the game loop, so on a machine with no audio
+// device the first update sound ends the game. RunGame can't be restarted, so
+// the sound is turned off instead, and saving the config keeps the next run
+// from failing the same way.
+func (g *Game) gameLoopFailed(err error, audioOpened bool) {
+	if audioOpened && g.settings.updateSound {
+		g.settings.updateSound = false
+		fmt.Fprintf(os.Stderr, "Audio failed, Update Sound turned off: %v\n", err)
+	}
 }
 
 // downloadFile downloads a file from a URL
-func downloadFile(url, filepath string) error {
-	resp, err := http.Get(url)
+func downloadFile(ctx context.Context, url, filepath string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := updateClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
 
 	out, err := os.Create(filepath)
 	if err != nil {
@@ -1553,6 +6641,68 @@ func downloadFile(url, filepath string) error {
 	return err
 }
 
+// checksumsAssetName is the release asset listing the SHA-256 of every zip,
+// one "<hex>  <file name>" line each as written by sha256sum.
+const checksumsAssetName = "checksums.txt"
+
+// assetURL returns the download URL of the release asset called name, or ""
+// if the release has none.
+func assetURL(release *GitHubRelease, name string) string {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			return asset.BrowserDownloadURL
+		}
+	}
+	return ""
+}
+
+// verifyChecksum downloads the checksums file and checks that the SHA-256 of
+// the file at path matches the entry for name.
+func verifyChecksum(ctx context.Context, path, name, checksumsURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checksumsURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", checksumsAssetName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s download returned status %d", checksumsAssetName, resp.StatusCode)
+	}
+	list, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", checksumsAssetName, err)
+	}
+
+	var want string
+	for _, line := range strings.Split(string(list), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			want = strings.ToLower(fields[0])
+			break
+		}
+	}
+	if want == "" {
+		return fmt.Errorf("%s has no entry for %s", checksumsAssetName, name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+	return nil
+}
+
 // extractZip extracts a zip file to a destination directory
 func extractZip(src, dest string) error {
 	r, err := zip.OpenReader(src)
@@ -1561,8 +6711,20 @@ func extractZip(src, dest string) error {
 	}
 	defer r.Close()
 
+	root, err := filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
 	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
+		// Refuse entries that would land outside dest (Zip Slip) and links,
+		// which could point a later entry outside it.
+		fpath := filepath.Join(root, f.Name)
+		if fpath != root && !strings.HasPrefix(fpath, root+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal file path in archive: %s", f.Name)
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("symlink in archive: %s", f.Name)
+		}
 
 		if f.FileInfo().IsDir() {
 			os.MkdirAll(fpath, os.ModePerm)
@@ -1595,10 +6757,14 @@ func extractZip(src, dest string) error {
 	return nil
 }
 
-// selfUpdate downloads and installs the latest version
-func selfUpdate() error {
-	fmt.Println("Checking for updates...")
-	release, err := checkForUpdates()
+// selfUpdate downloads and installs the latest version on channel. With
+// restart set it then launches the new executable with the same arguments;
+// the caller exits.
+func selfUpdate(restart bool, channel string) error {
+	fmt.Printf("Checking for updates (%s channel)...\n", channelName(channel))
+	checkCtx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+	release, err := checkForUpdates(checkCtx, channel)
+	cancel()
 	if err != nil {
 		return err
 	}
@@ -1627,27 +6793,29 @@ func selfUpdate() error {
 	}
 
 	// Find the asset
-	var downloadURL string
-	for _, asset := range release.Assets {
-		if asset.Name == assetName {
-			downloadURL = asset.BrowserDownloadURL
-			break
-		}
-	}
-
+	downloadURL := assetURL(release, assetName)
 	if downloadURL == "" {
 		return fmt.Errorf("no compatible release found for %s-%s", osName, arch)
 	}
+	checksumsURL := assetURL(release, checksumsAssetName)
+	if checksumsURL == "" {
+		return fmt.Errorf("release %s has no %s, refusing to install an unverified download", release.TagName, checksumsAssetName)
+	}
 
 	fmt.Printf("Downloading %s...\n", assetName)
 
 	// Download to temporary file
 	tmpFile := filepath.Join(os.TempDir(), assetName)
-	if err := downloadFile(downloadURL, tmpFile); err != nil {
+	if err := downloadFile(context.Background(), downloadURL, tmpFile); err != nil {
 		return fmt.Errorf("failed to download update: %w", err)
 	}
 	defer os.Remove(tmpFile)
 
+	fmt.Println("Verifying checksum...")
+	if err := verifyChecksum(context.Background(), tmpFile, assetName, checksumsURL); err != nil {
+		return fmt.Errorf("update rejected: %w", err)
+	}
+
 	fmt.Println("Extracting update...")
 
 	// Extract to temporary directory
@@ -1693,8 +6861,12 @@ func selfUpdate() error {
 		return fmt.Errorf("failed to get current executable path: %w", err)
 	}
 
-	// Backup current executable
+	// Backup current executable, replacing any older backup so only the
+	// most recent version is kept for --rollback.
 	backupPath := currentExe + ".old"
+	if err := os.Remove(backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove previous backup: %w", err)
+	}
 	if err := os.Rename(currentExe, backupPath); err != nil {
 		return fmt.Errorf("failed to backup current executable: %w", err)
 	}
@@ -1719,20 +6891,229 @@ func selfUpdate() error {
 		return fmt.Errorf("failed to copy new executable: %w", err)
 	}
 
-	// Remove backup on success
-	os.Remove(backupPath)
-
 	fmt.Printf("Successfully updated to version %s!\n", release.TagName)
+	fmt.Printf("The previous version is kept at %s; run with --rollback to restore it.\n", backupPath)
+	if !restart {
+		fmt.Println("Please restart the application.")
+		return nil
+	}
+	// Windows won't start an executable that is still open for writing.
+	currentExeFile.Close()
+	return relaunch(currentExe)
+}
+
+// rollback swaps the current executable with the .old backup left by the
+// last update. The replaced version becomes the new backup, so running
+// --rollback again undoes it.
+func rollback() error {
+	currentExe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get current executable path: %w", err)
+	}
+	backupPath := currentExe + ".old"
+	if _, err := os.Stat(backupPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no backup found at %s", backupPath)
+		}
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	fmt.Printf("Restoring %s...\n", backupPath)
+	swapPath := currentExe + ".swap"
+	if err := os.Rename(currentExe, swapPath); err != nil {
+		return fmt.Errorf("failed to move current executable aside: %w", err)
+	}
+	if err := os.Rename(backupPath, currentExe); err != nil {
+		os.Rename(swapPath, currentExe) // Put the current version back
+		return fmt.Errorf("failed to restore backup: %w", err)
+	}
+	if err := os.Rename(swapPath, backupPath); err != nil {
+		return fmt.Errorf("restored the backup, but failed to keep the replaced version as %s: %w", backupPath, err)
+	}
+
+	fmt.Println("Rollback complete. The replaced version is now the backup.")
 	fmt.Println("Please restart the application.")
 	return nil
 }
 
+// relaunch starts path with this process's arguments, minus the update
+// flags, sharing its console. The running executable has already been
+// renamed to .old, so the new binary is free to take its place.
+func relaunch(path string) error {
+	args := []string{path}
+	for _, arg := range os.Args[1:] {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && (name == "update" || name == "restart") {
+			continue
+		}
+		args = append(args, arg)
+	}
+	fmt.Println("Restarting...")
+	proc, err := os.StartProcess(path, args, &os.ProcAttr{Files: []*os.File{os.Stdin, os.Stdout, os.Stderr}})
+	if err != nil {
+		return fmt.Errorf("updated, but failed to restart: %w", err)
+	}
+	return proc.Release()
+}
+
+// settingsFlags seed Settings from the command line. Only flags that were
+// actually given are applied, so they override the config file and workspace
+// without resetting anything else.
+type settingsFlags struct {
+	gravity     *float64
+	maxSpeed    *float64
+	restitution *float64
+	airDrag     *float64
+	topBarrier  *bool
+	set         map[string]bool // names of the flags given on the command line
+}
+
+// validate checks the given values against the ranges the menu allows.
+func (f settingsFlags) validate() error {
+	switch {
+	case f.set["gravity"] && !(*f.gravity >= 0):
+		return fmt.Errorf("invalid --gravity %v: must be 0 or more", *f.gravity)
+	case f.set["max-speed"] && !(*f.maxSpeed >= 0.1):
+		return fmt.Errorf("invalid --max-speed %v: must be at least 0.1", *f.maxSpeed)
+	case f.set["collision-restitution"] && !(*f.restitution >= 0 && *f.restitution <= 1):
+		return fmt.Errorf("invalid --collision-restitution %v: must be between 0 and 1", *f.restitution)
+	case f.set["air-drag"] && !(*f.airDrag >= 0 && *f.airDrag <= 1):
+		return fmt.Errorf("invalid --air-drag %v: must be between 0 and 1", *f.airDrag)
+	}
+	return nil
+}
+
+// setSetting sets one of the settings that also has a command-line flag,
+// named like the flag and checked against the same ranges.
+func setSetting(s *Settings, name, value string) error {
+	f := settingsFlags{set: map[string]bool{name: true}}
+	switch name {
+	case "top-barrier":
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: want true or false", name, value)
+		}
+		f.topBarrier = &on
+	case "gravity", "max-speed", "collision-restitution", "air-drag":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: want a number", name, value)
+		}
+		f.gravity, f.maxSpeed, f.restitution, f.airDrag = &v, &v, &v, &v
+	default:
+		return fmt.Errorf("unknown setting %q (want gravity, max-speed, collision-restitution, air-drag or top-barrier)", name)
+	}
+	if err := f.validate(); err != nil {
+		return err
+	}
+	f.apply(s)
+	return nil
+}
+
+func (f settingsFlags) apply(s *Settings) {
+	if f.set["gravity"] {
+		s.setGravity(float32(*f.gravity), s.gravityAngle())
+	}
+	if f.set["max-speed"] {
+		s.maxSpeed = float32(*f.maxSpeed)
+	}
+	if f.set["collision-restitution"] {
+		s.collisionRestitution = float32(*f.restitution)
+	}
+	if f.set["air-drag"] {
+		s.airDrag = float32(*f.airDrag)
+	}
+	if f.set["top-barrier"] {
+		s.hasTopBarrier = *f.topBarrier
+	}
+}
+
+// restore puts back into s the values from the settings that the flags in f
+// replaced, undoing apply.
+func (f settingsFlags) restore(s *Settings, from Settings) {
+	if f.set["gravity"] {
+		s.setGravity(from.gravityMagnitude(), s.gravityAngle())
+	}
+	if f.set["max-speed"] {
+		s.maxSpeed = from.maxSpeed
+	}
+	if f.set["collision-restitution"] {
+		s.collisionRestitution = from.collisionRestitution
+	}
+	if f.set["air-drag"] {
+		s.airDrag = from.airDrag
+	}
+	if f.set["top-barrier"] {
+		s.hasTopBarrier = from.hasTopBarrier
+	}
+}
+
 func main() {
 	updateFlag := flag.Bool("update", false, "Check for updates and install the latest version")
+	rollbackFlag := flag.Bool("rollback", false, "Restore the executable backed up by the last --update")
+	restartFlag := flag.Bool("restart", false, "With --update, start the new version after installing it")
+	seedFlag := flag.Int64("seed", 0, "Seed for the random number generator (0 picks one from the clock)")
+	profileFlag := flag.String("profile", "", "Performance profile to start with: low, default or high")
+	benchFlag := flag.Int("bench", 0, "Run N physics steps without a window, print timings and exit")
+	benchCountFlag := flag.Int("bench-count", 2000, "Number of particles for --bench")
+	replayFlag := flag.String("replay", "", "Replay input recorded with F7 from the given .jsonl file")
+	benchMaterialFlag := flag.String("bench-material", "water", "Material for --bench: solid, water, gas, static or sand")
+	csvOutFlag := flag.String("csv-out", "", "Append frame, index, material, x, y, vx, vy of every particle to this CSV file")
+	csvEveryFlag := flag.Int("csv-every", 1, "With --csv-out, write every Nth step only")
+	stdinFlag := flag.Bool("stdin-commands", false, "Read commands such as \"spawn water 400 300 8\" from standard input")
+	apiPortFlag := flag.Int("api-port", 0, "Serve the HTTP control API on this port (0 is off)")
+	wsPortFlag := flag.Int("ws-port", 0, "Stream particle snapshots to WebSocket clients on this port (0 is off)")
+	sceneFlag := flag.String("scene", "", "Start with a preset scene: "+presetNames())
+	channelFlag := flag.String("channel", "", "Update channel, remembered for later runs: stable or beta (includes pre-releases)")
+	defaults := defaultSettings()
+	overrides := settingsFlags{
+		gravity:     flag.Float64("gravity", float64(defaults.gravityMagnitude()), "Initial gravity strength (0 or more)"),
+		maxSpeed:    flag.Float64("max-speed", float64(defaults.maxSpeed), "Initial maximum particle speed (at least 0.1)"),
+		restitution: flag.Float64("collision-restitution", float64(defaults.collisionRestitution), "Initial bounciness of collisions (0 to 1)"),
+		airDrag:     flag.Float64("air-drag", float64(defaults.airDrag), "Initial air drag (0 to 1)"),
+		topBarrier:  flag.Bool("top-barrier", defaults.hasTopBarrier, "Start with the barrier along the top of the screen"),
+	}
 	flag.Parse()
+	overrides.set = map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { overrides.set[f.Name] = true })
+	if err := overrides.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	state, stateErr := loadAppState()
+	if *channelFlag != "" {
+		if *channelFlag != channelStable && *channelFlag != channelBeta {
+			fmt.Fprintf(os.Stderr, "Unknown channel %q (want stable or beta)\n", *channelFlag)
+			os.Exit(2)
+		}
+		if state.Channel != *channelFlag {
+			state.Channel = *channelFlag
+			if err := saveAppState(state); err != nil {
+				fmt.Fprintf(os.Stderr, "Channel not saved: %v\n", err)
+			}
+		}
+	}
+
+	if *benchFlag > 0 {
+		if err := runBenchmark(*benchFlag, *benchCountFlag, *benchMaterialFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Benchmark failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *rollbackFlag {
+		if err := rollback(); err != nil {
+			fmt.Fprintf(os.Stderr, "Rollback failed: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
 	if *updateFlag {
-		if err := selfUpdate(); err != nil {
+		if err := selfUpdate(*restartFlag, state.Channel); err != nil {
 			fmt.Fprintf(os.Stderr, "Update failed: %v\n", err)
 			os.Exit(1)
 		}
@@ -1747,7 +7128,111 @@ func main() {
 	emptyImage.Fill(color.White)
 
 	fmt.Println(screenHeight, screenWidth)
-	if err := ebiten.RunGame(NewGame()); err != nil {
-		log.Fatal(err)
+	game := NewGame()
+	if *seedFlag != 0 {
+		game.rng = rand.New(rand.NewSource(*seedFlag))
+	}
+	if (stateErr == nil || errors.Is(stateErr, os.ErrNotExist)) && !state.TutorialSeen {
+		game.tutorialStep = 0
+	}
+	game.restoreWorkspace = state.RestoreWorkspace
+	game.updateChannel = state.Channel
+	if err := loadConfig(game); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Config not loaded, using defaults: %v\n", err)
+	}
+	if game.restoreWorkspace {
+		if err := loadWorkspace(workspaceFileName, game); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Workspace not restored: %v\n", err)
+		}
+	}
+	if *sceneFlag != "" {
+		if err := game.loadPreset(*sceneFlag); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if *profileFlag != "" {
+		i, ok := profileFlagNames[*profileFlag]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown profile %q (want low, default or high)\n", *profileFlag)
+			os.Exit(2)
+		}
+		game.settings.applyProfile(profiles[i])
+	}
+	game.flagSettings, game.configSettings = overrides, game.settings
+	overrides.apply(&game.settings)
+	if *replayFlag != "" {
+		replay, header, err := openInputReplay(*replayFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+			os.Exit(1)
+		}
+		if err := loadSceneFromFile(header.Scene, game); err != nil {
+			fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+			os.Exit(1)
+		}
+		if header.Width != screenWidth || header.Height != screenHeight {
+			fmt.Fprintf(os.Stderr, "Recorded at %dx%d but the screen is %dx%d; the replay may diverge\n", header.Width, header.Height, screenWidth, screenHeight)
+		}
+		game.rng = rand.New(rand.NewSource(header.Seed))
+		game.tutorialStep = -1
+		game.replay = replay
+	}
+	if *wsPortFlag > 0 {
+		stream, err := startStream(*wsPortFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WebSocket stream not started: %v\n", err)
+		} else {
+			game.stream = stream
+		}
+	}
+	if *csvOutFlag != "" {
+		export, err := createCSVExport(*csvOutFlag, *csvEveryFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "CSV export not started: %v\n", err)
+			os.Exit(1)
+		}
+		game.csv = export
+	}
+	if *stdinFlag {
+		game.commands = startCommandReader(os.Stdin)
+	}
+	if *apiPortFlag > 0 {
+		api, err := startAPI(*apiPortFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "HTTP API not started: %v\n", err)
+		} else {
+			game.api = api
+		}
+	}
+	runErr := ebiten.RunGame(game)
+	if runErr != nil {
+		game.gameLoopFailed(runErr, audio.CurrentContext() != nil)
+	}
+	game.shutdown()
+	if runErr != nil {
+		log.Print(runErr)
+		os.Exit(1)
+	}
+}
+
+// shutdown closes the recorder and CSV exporter and saves the config and
+// workspace. It runs after the game loop ends, whether or not it failed.
+func (g *Game) shutdown() {
+	if g.recorder != nil {
+		g.recorder.Close()
+	}
+	if g.csv != nil {
+		if err := g.csv.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "CSV export not finished: %v\n", err)
+		}
+	}
+	if err := saveConfig(g); err != nil {
+		fmt.Fprintf(os.Stderr, "Config not saved: %v\n", err)
+	}
+	if g.restoreWorkspace {
+		if err := saveWorkspace(workspaceFileName, g); err != nil {
+			fmt.Fprintf(os.Stderr, "Workspace not saved: %v\n", err)
+		}
 	}
 }
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSceneSaveLoadRoundTrip(t *testing.T) {
+	g := NewGame()
+	g.AddParticle(createBall(createPos(100, 200), 10, ShapeCircle))
+	g.AddParticle(createParticle(ShapeWater, createPos(300, 400), 5))
+	path := filepath.Join(t.TempDir(), "scene.json")
+	if err := saveSceneToFile(path, g); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := raw["balls"]; !ok {
+		t.Fatalf("scene file has no \"balls\" key, so older builds can't read it")
+	}
+
+	loaded := NewGame()
+	if err := loadSceneFromFile(path, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.balls) != len(g.balls) {
+		t.Fatalf("loaded %d particles, saved %d", len(loaded.balls), len(g.balls))
+	}
+	for i := range g.balls {
+		want, got := g.balls[i], loaded.balls[i]
+		if got.pos != want.pos || got.radius != want.radius || got.material != want.material {
+			t.Errorf("particle %d loaded as %+v, saved %+v", i, got, want)
+		}
+	}
+}
+
+func TestApplySceneRejectsNonPositiveMass(t *testing.T) {
+	g := NewGame()
+	scene := g.presetScene(defaultSettings())
+	for _, mass := range []float32{0, -2, 3} {
+		scene.Balls = append(scene.Balls, sceneBallDTO{X: 100, Y: 100, Radius: 10, Shape: ShapeCircle, Material: MaterialSolid, Mass: &mass})
+	}
+	if err := applyScene(g, scene); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []float32{defaultMass(MaterialSolid), defaultMass(MaterialSolid), 3}
+	if len(g.balls) != len(want) {
+		t.Fatalf("loaded %d balls, want %d", len(g.balls), len(want))
+	}
+	for i, m := range want {
+		if g.balls[i].mass != m {
+			t.Errorf("ball %d has mass %v, want %v", i, g.balls[i].mass, m)
+		}
+	}
+}
+
+// zipEntry is one file for writeTestZip; mode may mark it a symlink, whose
+// body is then the link target.
+type zipEntry struct {
+	name, body string
+	mode       os.FileMode
+}
+
+func writeTestZip(t *testing.T, path string, entries []zipEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetMode(e.mode | 0o644)
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []zipEntry
+		wantErr bool
+	}{
+		{"plain files", []zipEntry{{name: "phix.exe", body: "exe"}, {name: "assets/a.txt", body: "a"}}, false},
+		{"parent traversal", []zipEntry{{name: "../evil", body: "evil"}}, true},
+		{"nested traversal", []zipEntry{{name: "assets/../../evil", body: "evil"}}, true},
+		{"symlink", []zipEntry{{name: "link", body: "../evil", mode: os.ModeSymlink}, {name: "link/payload", body: "evil"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			archive := filepath.Join(root, "update.zip")
+			dest := filepath.Join(root, "dest")
+			writeTestZip(t, archive, tt.entries)
+
+			err := extractZip(archive, dest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractZip error = %v, want error %v", err, tt.wantErr)
+			}
+
+			// Only the archive and dest may exist beside each other.
+			outside, err := os.ReadDir(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range outside {
+				if e.Name() != "update.zip" && e.Name() != "dest" {
+					t.Errorf("extraction wrote %s outside dest", e.Name())
+				}
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "link")); err == nil {
+				t.Errorf("extraction created the symlink entry")
+			}
+			if !tt.wantErr {
+				for _, e := range tt.entries {
+					got, err := os.ReadFile(filepath.Join(dest, e.name))
+					if err != nil || string(got) != e.body {
+						t.Errorf("%s extracted as %q, %v; want %q", e.name, got, err, e.body)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaveConfigKeepsFlagOverridesOut(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("AppData", t.TempDir())
+
+	g := NewGame()
+	g.settings.maxSpeed = 7
+	g.settings.airDrag = 0.05
+	speed := 25.0
+	flags := settingsFlags{maxSpeed: &speed, set: map[string]bool{"max-speed": true}}
+	g.flagSettings, g.configSettings = flags, g.settings
+	flags.apply(&g.settings)
+	g.settings.airDrag = 0.1 // changed in the menu during the run
+	if err := saveConfig(g); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewGame()
+	if err := loadConfig(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.settings.maxSpeed != 7 {
+		t.Errorf("saved max speed %v, want the config's 7 rather than the --max-speed value", loaded.settings.maxSpeed)
+	}
+	if loaded.settings.airDrag != 0.1 {
+		t.Errorf("saved air drag %v, want the menu's 0.1", loaded.settings.airDrag)
+	}
+	if g.settings.maxSpeed != 25 {
+		t.Errorf("saving changed the running max speed to %v", g.settings.maxSpeed)
+	}
+}
+
+func TestHashKeyDistinctCells(t *testing.T) {
+	edges := []int{math.MinInt32, math.MinInt32 + 1, -2, -1, 0, 1, 2, math.MaxInt32 - 1, math.MaxInt32}
+	seen := map[int64]cellCoord{}
+	for _, x := range edges {
+		for _, y := range edges {
+			key := hashKey(x, y)
+			if other, ok := seen[key]; ok {
+				t.Fatalf("cells (%d, %d) and (%d, %d) share key %#x", x, y, other.x, other.y, key)
+			}
+			seen[key] = cellCoord{x, y}
+			if gx, gy := keyCell(key); gx != x || gy != y {
+				t.Errorf("keyCell(hashKey(%d, %d)) = (%d, %d)", x, y, gx, gy)
+			}
+		}
+	}
+}
+
+func TestSpatialHashCoord(t *testing.T) {
+	h := newSpatialHash(10)
+	tests := []struct {
+		value float32
+		want  int
+	}{
+		{0, 0},
+		{9.99, 0},
+		{10, 1},
+		{-0.01, -1},
+		{-9.99, -1},
+		{-10.5, -2},
+		{1e20, math.MaxInt32},
+		{-1e20, math.MinInt32},
+		{float32(math.Inf(1)), math.MaxInt32},
+		{float32(math.Inf(-1)), math.MinInt32},
+		{float32(math.NaN()), math.MinInt32},
+	}
+	for _, tt := range tests {
+		if got := h.coord(tt.value); got != tt.want {
+			t.Errorf("coord(%v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMakeRoomEvictsOldestAfterSwapRemove(t *testing.T) {
+	g := NewGame()
+	g.settings.maxParticles = 5
+	g.settings.evictOldest = true
+	for i := range 5 {
+		g.addParticle(createBall(createPos(100+float32(i)*30, 100), 10, ShapeCircle))
+	}
+	oldest := g.balls[1].seq
+	// Erasing the oldest particle moves the newest one into index 0.
+	g.removeParticle(0)
+	g.addParticle(createBall(createPos(400, 100), 10, ShapeCircle))
+
+	if n := g.makeRoom(1); n != 1 {
+		t.Fatalf("makeRoom(1) = %d, want 1", n)
+	}
+	if len(g.balls) != 4 {
+		t.Fatalf("%d particles left, want 4", len(g.balls))
+	}
+	for i := range g.balls {
+		if g.balls[i].seq == oldest {
+			t.Fatalf("particle %d is the oldest one (seq %d), but makeRoom kept it", i, oldest)
+		}
+	}
+	if g.balls[0].seq != 5 {
+		t.Errorf("index 0 holds seq %d, want the newest-but-one (5) moved there by the erase", g.balls[0].seq)
+	}
+}
+
+func TestApplySceneKeepsRadiiOutsideSpawnRange(t *testing.T) {
+	g := NewGame()
+	g.settings.solidRadiusMin, g.settings.solidRadiusMax = 8, 12
+	scene := g.presetScene(defaultSettings())
+	scene.Settings = settingsToDTO(g.settings)
+	for _, r := range []float32{minSpawnRadius, 4, 40, maxSpawnRadius, maxSpawnRadius + 1, 0} {
+		scene.Balls = append(scene.Balls, sceneBallDTO{X: 200, Y: 200, Radius: r, Shape: ShapeCircle, Material: MaterialSolid})
+	}
+	if err := applyScene(g, scene); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float32
+	for i := range g.balls {
+		got = append(got, g.balls[i].radius)
+	}
+	want := []float32{minSpawnRadius, 4, 40, maxSpawnRadius}
+	if !slices.Equal(got, want) {
+		t.Fatalf("loaded radii %v, want %v", got, want)
+	}
+}
+
+func TestRenderPosOnlyInterpolatesTheSameParticle(t *testing.T) {
+	g := NewGame()
+	for i := range 3 {
+		g.AddParticle(createBall(createPos(100+float32(i)*100, 100), 10, ShapeCircle))
+	}
+	g.renderPrev = g.renderPrev[:0]
+	for i := range g.balls {
+		g.renderPrev = append(g.renderPrev, renderSample{seq: g.balls[i].seq, pos: g.balls[i].pos})
+	}
+	g.renderAlpha = 0.5
+	g.balls[1].pos.y += 20
+
+	if got, want := g.renderPos(1), createPos(200, 110); got != want {
+		t.Errorf("moved particle drawn at %v, want halfway at %v", got, want)
+	}
+
+	// Erasing particle 0 moves particle 2 into its index; a new particle then
+	// restores the count, so only the tags tell the entries apart.
+	g.removeParticle(0)
+	g.AddParticle(createBall(createPos(600, 600), 10, ShapeCircle))
+	for i := range g.balls {
+		if i == 1 {
+			continue
+		}
+		if got := g.renderPos(i); got != g.balls[i].pos {
+			t.Errorf("particle %d drawn at %v, want its exact position %v", i, got, g.balls[i].pos)
+		}
+	}
+	if got, want := g.renderPos(1), createPos(200, 110); got != want {
+		t.Errorf("untouched particle drawn at %v, want %v", got, want)
+	}
+}
+
+// With no audio device, ebiten/audio fails the frame after the first update
+// sound and RunGame returns that error; the sound must end up off in the
+// saved config instead of failing again on the next run.
+func TestGameLoopFailedTurnsOffUpdateSound(t *testing.T) {
+	noDevice := errors.New("oto: ALSA error: no such device")
+	for _, tt := range []struct {
+		name        string
+		audioOpened bool
+		want        bool
+	}{
+		{"audio opened", true, false},
+		{"audio never opened", false, true},
+	} {
+		g := NewGame()
+		g.settings.updateSound = true
+		g.gameLoopFailed(noDevice, tt.audioOpened)
+		if g.settings.updateSound != tt.want {
+			t.Errorf("%s: update sound %v, want %v", tt.name, g.settings.updateSound, tt.want)
+		}
+	}
+}
+
+func TestFinishGIFReportsThroughChannel(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	g := NewGame()
+	g.gifRecording = true
+	for range 3 {
+		g.gifFrames = append(g.gifFrames, image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9))
+	}
+	g.finishGIF()
+	if g.gifRecording || g.gifFrames != nil {
+		t.Fatal("finishGIF left the capture running")
+	}
+
+	var msg string
+	select {
+	case msg = <-g.fileMessages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result from the GIF encoder")
+	}
+	name, ok := strings.CutPrefix(msg, "Saved GIF: ")
+	if !ok {
+		t.Fatalf("encoder reported %q", msg)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 3 {
+		t.Errorf("GIF has %d frames, want 3", len(anim.Image))
+	}
+}
+
+func TestLayoutKeepsWindowSizeOnGame(t *testing.T) {
+	startW, startH := screenWidth, screenHeight
+	g := NewGame()
+	g.Layout(800, 600)
+
+	if g.width != 800 || g.height != 600 {
+		t.Fatalf("world is %vx%v after Layout(800, 600)", g.width, g.height)
+	}
+	if screenWidth != startW || screenHeight != startH {
+		t.Errorf("Layout changed the startup size to %dx%d", screenWidth, screenHeight)
+	}
+	if !g.offScreen(createPos(820, 300), 10) || g.offScreen(createPos(790, 300), 10) {
+		t.Error("offScreen doesn't cull against the 800-wide window")
+	}
+}
+
+func TestMaterialTitle(t *testing.T) {
+	for m := range MaterialType(materialCount) {
+		if got := materialTitle(m); got == "Unknown" {
+			t.Errorf("material %d has no name in materialNames", m)
+		}
+	}
+	if got := materialTitle(MaterialSand); got != "Sand" {
+		t.Errorf("materialTitle(MaterialSand) = %q, want Sand", got)
+	}
+	if got := materialTitle(materialCount); got != "Unknown" {
+		t.Errorf("materialTitle(materialCount) = %q, want Unknown", got)
+	}
+}
+
+func TestScaleSceneIgnoresSpawnRanges(t *testing.T) {
+	g := NewGame()
+	g.settings.solidRadiusMin, g.settings.solidRadiusMax = 8, 12
+	g.addParticle(createStaticSolid(createPos(300, 300), 3, ShapeStatic))
+	g.addParticle(createBall(createPos(400, 300), 40, ShapeCircle))
+	g.addParticle(createBall(createPos(500, 300), maxSpawnRadius, ShapeCircle))
+
+	g.scaleScene(sceneScaleStep)
+
+	want := []float32{3 * sceneScaleStep, 40 * sceneScaleStep, maxSpawnRadius}
+	for i, r := range want {
+		if math.Abs(float64(g.balls[i].radius-r)) > 1e-4 {
+			t.Errorf("particle %d scaled to radius %v, want %v", i, g.balls[i].radius, r)
+		}
+	}
+}
+
+func TestShutdownFlushesCSVAfterFailedLoop(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("AppData", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	export, err := createCSVExport(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := NewGame()
+	g.csv = export
+	g.addParticle(Ball{pos: Pos{x: 10, y: 20}, radius: 4, material: MaterialWater})
+	if err := g.csv.write(0, g.balls); err != nil {
+		t.Fatal(err)
+	}
+	g.gameLoopFailed(errors.New("no display"), false)
+	g.shutdown()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 {
+		t.Fatalf("CSV has %d lines after shutdown, want header and one row:\n%s", len(lines), data)
+	}
+}
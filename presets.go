@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// scenePresets are the ready-made scenes offered by --scene and the menu's
+// Load Preset row. build returns a whole scene sized to the world, so loading
+// one replaces everything that is there.
+var scenePresets = []struct {
+	name  string
+	label string
+	build func(g *Game) sceneDTO
+}{
+	{"fountain", "Fountain", fountainScene},
+}
+
+const (
+	fountainBasinWidth = float32(520)
+	fountainWallHeight = float32(110)
+	fountainWallRadius = float32(6)
+	fountainJetRadius  = float32(5)
+	fountainJetRate    = float32(90)
+	fountainJetSpeed   = float32(10) // The default max speed; more is clamped anyway
+	fountainCap        = 3000
+)
+
+// presetNames lists the preset names for messages, e.g. "fountain".
+func presetNames() string {
+	names := make([]string, len(scenePresets))
+	for i, p := range scenePresets {
+		names[i] = p.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// loadPreset replaces the scene with the preset called name.
+func (g *Game) loadPreset(name string) error {
+	for _, p := range scenePresets {
+		if p.name == name {
+			return applyScene(g, p.build(g))
+		}
+	}
+	return fmt.Errorf("unknown scene preset %q (want %s)", name, presetNames())
+}
+
+// presetScene is an empty scene with settings s that keeps the current brush.
+func (g *Game) presetScene(s Settings) sceneDTO {
+	return sceneDTO{
+		SceneVersion:        1,
+		AppVersion:          version,
+		Settings:            settingsToDTO(s),
+		BallSize:            ballsize,
+		MoveAttractDistance: moveAttractDistance,
+		SpawnClusterCount:   g.spawnClusterCount,
+		CurrentShape:        currentShape,
+		SpawnScatter:        g.spawnScatter,
+		ScatterRadius:       g.scatterRadius,
+	}
+}
+
+// fountainScene is a basin of static walls on the floor with a water jet in
+// the middle shooting against gravity. The oldest water is evicted at the
+// particle cap so the fountain keeps running.
+func fountainScene(g *Game) sceneDTO {
+	s := defaultSettings()
+	s.maxParticles = fountainCap
+	s.evictOldest = true
+	scene := g.presetScene(s)
+
+	floor := g.height - screenPadding
+	cx := g.width / 2
+	half := min(fountainBasinWidth, g.width*0.8) / 2
+	for y := floor - fountainWallRadius; y > floor-fountainWallHeight; y -= fountainWallRadius {
+		for _, x := range [...]float32{cx - half, cx + half} {
+			scene.Balls = append(scene.Balls, sceneBallDTO{X: x, Y: y, Radius: fountainWallRadius, Shape: ShapeStatic, Material: MaterialStatic})
+		}
+	}
+	scene.Emitters = []sceneEmitterDTO{{
+		X:      cx,
+		Y:      floor - fountainJetRadius*2,
+		Shape:  ShapeWater,
+		Radius: fountainJetRadius,
+		Rate:   fountainJetRate,
+		VY:     -fountainJetSpeed,
+	}}
+	return scene
+}
@@ -0,0 +1,791 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// simulationTickRate is the step rate the solver is tuned for. Velocities are
+// in pixels per tick at this rate.
+const simulationTickRate = 60
+
+// maxIntegrationSubsteps caps how finely one fast particle's move is split.
+const maxIntegrationSubsteps = 16
+
+// Resting solids and sand fall asleep after sleepSteps consecutive steps
+// slower than sleepSpeed (pixels per tick). Asleep particles skip integration
+// and act as immovable until a faster neighbor touches them or nothing within
+// sleepContactGap holds them up. sleepSpeed sits above the jitter the default
+// gravity leaves on a particle lying still.
+const (
+	sleepSpeed      = float32(0.25)
+	sleepSteps      = 30
+	sleepContactGap = float32(1)
+)
+
+// Simulation owns the particles and everything needed to advance them:
+// settings, the spatial hashes and their per-material caches, gravity zones
+// and polygon obstacles. It makes no Ebiten calls, so it can be stepped
+// without a window.
+type Simulation struct {
+	balls    []Ball
+	settings Settings
+	zones    []GravityZone
+	polygons []Polygon
+	walls    []Wall
+	wells    []GravityWell
+
+	// width and height bound the world; the floor sits screenPadding above the bottom.
+	width, height float32
+
+	collider         spatialHash
+	cellCache        []cellCoord
+	waterCollider    spatialHash
+	waterCellCache   []cellCoord
+	waterIndices     []int
+	waterDensity     []float32
+	waterNearDensity []float32
+	waterIndexMap    map[int]int
+	solidCollider    spatialHash
+	solidIndices     []int
+	gasCollider      spatialHash
+	gasCellCache     []cellCoord
+	gasIndices       []int
+	gasDensity       []float32
+	gasIndexMap      map[int]int
+
+	// nextSeq is the spawn sequence number the next added particle gets.
+	nextSeq uint64
+	// expire removes particle i when its gas lifetime runs out. Game points
+	// it at removeParticle so its despawn hooks run; nil swap-removes.
+	expire func(i int)
+
+	// queryHash indexes particle centers for QueryRadius. It is rebuilt
+	// lazily after anything moves, adds or removes particles.
+	queryHash  spatialHash
+	queryDirty bool
+
+	// resting marks the sleepers found touching something during this step;
+	// one left unmarked has lost its support and wakes.
+	resting     []bool
+	lastGravity Velocity // wakes every sleeper when the gravity setting changes
+
+	// prevPos and impact hold each particle's position before integration and
+	// its earliest time of impact, for the optional swept collision pass.
+	prevPos []Pos
+	impact  []float32
+
+	// probed is the particle whose velocity changes are recorded in forces, or -1.
+	probed int
+	forces forceProbe
+}
+
+func NewSimulation(width, height float32) *Simulation {
+	return &Simulation{
+		settings:      defaultSettings(),
+		width:         width,
+		height:        height,
+		collider:      newSpatialHash(maxSpawnRadius * 2),
+		waterCollider: newSpatialHash(waterRestDistance * 2),
+		waterIndexMap: make(map[int]int),
+		solidCollider: newSpatialHash(maxSpawnRadius * 2),
+		gasCollider:   newSpatialHash(gasRestDistance * 2),
+		gasIndexMap:   make(map[int]int),
+		queryDirty:    true,
+		probed:        -1,
+	}
+}
+
+// AddParticle appends b and returns its index. Indices stay valid until a
+// particle before them is removed. b is stamped with the next spawn sequence
+// number, so it counts as the newest particle.
+func (s *Simulation) AddParticle(b Ball) int {
+	s.nextSeq++
+	b.seq = s.nextSeq
+	s.balls = append(s.balls, b)
+	s.queryDirty = true
+	return len(s.balls) - 1
+}
+
+// RemoveParticle deletes the particle at id, keeping the order of the rest.
+// Later indices shift down by one, and the cached per-material index lists are
+// patched the same way so they stay consistent until the next Step.
+func (s *Simulation) RemoveParticle(id int) {
+	if id < 0 || id >= len(s.balls) {
+		return
+	}
+	s.balls = append(s.balls[:id], s.balls[id+1:]...)
+	s.queryDirty = true
+
+	if id < len(s.cellCache) {
+		s.cellCache = append(s.cellCache[:id], s.cellCache[id+1:]...)
+	}
+	var slot int
+	if s.waterIndices, slot = dropIndex(s.waterIndices, id); slot >= 0 {
+		s.waterCellCache = dropSlot(s.waterCellCache, slot)
+		s.waterDensity = dropSlot(s.waterDensity, slot)
+		s.waterNearDensity = dropSlot(s.waterNearDensity, slot)
+	}
+	clear(s.waterIndexMap)
+	for slot, idx := range s.waterIndices {
+		s.waterIndexMap[idx] = slot
+	}
+	if s.gasIndices, slot = dropIndex(s.gasIndices, id); slot >= 0 {
+		s.gasCellCache = dropSlot(s.gasCellCache, slot)
+		s.gasDensity = dropSlot(s.gasDensity, slot)
+	}
+	clear(s.gasIndexMap)
+	for slot, idx := range s.gasIndices {
+		s.gasIndexMap[idx] = slot
+	}
+	s.solidIndices, _ = dropIndex(s.solidIndices, id)
+
+	if id == s.probed {
+		s.probed = -1
+	} else if id < s.probed {
+		s.probed--
+	}
+}
+
+// SwapRemoveParticle deletes the particle at id by moving the last particle
+// into its place, so nothing else shifts. Only the last index changes (it
+// becomes id). The water and gas lists are patched the same way in constant
+// time through their slot maps; solidIndices is only read after Step rebuilds
+// it, so it is dropped instead.
+func (s *Simulation) SwapRemoveParticle(id int) {
+	last := len(s.balls) - 1
+	if id < 0 || id > last {
+		return
+	}
+	s.balls[id] = s.balls[last]
+	s.balls = s.balls[:last]
+	s.queryDirty = true
+
+	if last < len(s.cellCache) {
+		s.cellCache[id] = s.cellCache[last]
+	}
+	var slot, end int
+	if s.waterIndices, slot, end = swapRemoveSlot(s.waterIndices, s.waterIndexMap, id, last); slot >= 0 {
+		s.waterCellCache = swapSlot(s.waterCellCache, slot, end)
+		s.waterDensity = swapSlot(s.waterDensity, slot, end)
+		s.waterNearDensity = swapSlot(s.waterNearDensity, slot, end)
+	}
+	if s.gasIndices, slot, end = swapRemoveSlot(s.gasIndices, s.gasIndexMap, id, last); slot >= 0 {
+		s.gasCellCache = swapSlot(s.gasCellCache, slot, end)
+		s.gasDensity = swapSlot(s.gasDensity, slot, end)
+	}
+	s.solidIndices = s.solidIndices[:0]
+
+	if id == s.probed {
+		s.probed = -1
+	} else if last == s.probed {
+		s.probed = id
+	}
+}
+
+// ClearParticles removes every particle and drops the per-particle caches and
+// hash contents with them. The slices are released rather than truncated, so
+// a large scene's capacity isn't kept alive; they regrow on demand in Step.
+func (s *Simulation) ClearParticles() {
+	s.balls = nil
+	s.cellCache = nil
+	s.waterCellCache = nil
+	s.waterIndices = nil
+	s.waterDensity = nil
+	s.waterNearDensity = nil
+	clear(s.waterIndexMap)
+	s.solidIndices = nil
+	s.gasCellCache = nil
+	s.gasIndices = nil
+	s.gasDensity = nil
+	clear(s.gasIndexMap)
+	s.collider.Clear()
+	s.waterCollider.Clear()
+	s.solidCollider.Clear()
+	s.gasCollider.Clear()
+	s.queryHash.Clear()
+	s.queryDirty = true
+	s.probed = -1
+}
+
+// dropIndex removes id from a cached index list and shifts larger indices
+// down by one. It returns the slot id occupied, or -1 if it was not listed.
+func dropIndex(indices []int, id int) ([]int, int) {
+	slot := -1
+	out := indices[:0]
+	for i, idx := range indices {
+		switch {
+		case idx == id:
+			slot = i
+		case idx > id:
+			out = append(out, idx-1)
+		default:
+			out = append(out, idx)
+		}
+	}
+	return out, slot
+}
+
+// swapRemoveSlot removes id from a cached index list and relabels last as id,
+// matching a swap-remove, with slots mapping each listed index to its slot.
+// The list's end slot moves into the one id occupied. It returns that slot,
+// or -1 if id was not listed, and the end slot it was refilled from.
+func swapRemoveSlot(indices []int, slots map[int]int, id, last int) ([]int, int, int) {
+	slot, ok := slots[id]
+	if !ok {
+		slot = -1
+	}
+	end := len(indices) - 1
+	if ok {
+		moved := indices[end]
+		indices[slot] = moved
+		indices = indices[:end]
+		delete(slots, id)
+		if moved != id {
+			slots[moved] = slot
+		}
+	}
+	if at, ok := slots[last]; ok && last != id {
+		indices[at] = id
+		delete(slots, last)
+		slots[id] = at
+	}
+	return indices, slot, end
+}
+
+// swapSlot fills slot of a per-slot cache from end and drops end, matching
+// swapRemoveSlot. A cache too short to hold end is left alone.
+func swapSlot[T any](list []T, slot, end int) []T {
+	if end >= len(list) {
+		return list
+	}
+	list[slot] = list[end]
+	return list[:end]
+}
+
+func dropSlot[T any](list []T, slot int) []T {
+	if slot >= len(list) {
+		return list
+	}
+	return append(list[:slot], list[slot+1:]...)
+}
+
+// QueryRadius returns the indices of particles whose centers lie within r of p.
+// Positions are indexed as of the last Step, AddParticle or RemoveParticle;
+// code that moves particles directly should set queryDirty.
+func (s *Simulation) QueryRadius(p Pos, r float32) []int {
+	if s.queryDirty || s.queryHash.buckets == nil {
+		size := 2 * maxRadius(s.balls)
+		if size < 2*minSpawnRadius {
+			size = 2 * minSpawnRadius
+		}
+		if s.queryHash.buckets == nil || s.queryHash.cellSize != size {
+			s.queryHash = newSpatialHash(size)
+		}
+		s.queryHash.Clear()
+		for i := range s.balls {
+			s.queryHash.insert(i, s.queryHash.coord(s.balls[i].pos.x), s.queryHash.coord(s.balls[i].pos.y))
+		}
+		s.queryDirty = false
+	}
+
+	var found []int
+	rSq := r * r
+	minX, maxX := s.queryHash.coord(p.x-r), s.queryHash.coord(p.x+r)
+	minY, maxY := s.queryHash.coord(p.y-r), s.queryHash.coord(p.y+r)
+	for cy := minY; cy <= maxY; cy++ {
+		for cx := minX; cx <= maxX; cx++ {
+			for _, i := range s.queryHash.cell(cx, cy) {
+				dx := s.balls[i].pos.x - p.x
+				dy := s.balls[i].pos.y - p.y
+				if dx*dx+dy*dy <= rSq {
+					found = append(found, i)
+				}
+			}
+		}
+	}
+	return found
+}
+
+// SetBounds resizes the world. When it shrinks, moving particles left outside
+// are put back against the new walls with their outward velocity removed, so
+// they don't reappear with a burst of speed on the next Step.
+func (s *Simulation) SetBounds(width, height float32) {
+	if width == s.width && height == s.height {
+		return
+	}
+	shrunk := width < s.width || height < s.height
+	s.width, s.height = width, height
+	if !shrunk {
+		return
+	}
+	bottomLimit := height - screenPadding
+	for i := range s.balls {
+		b := &s.balls[i]
+		if b.material == MaterialStatic || b.body != 0 {
+			continue
+		}
+		if b.pos.x+b.radius > width {
+			b.pos.x = max(b.radius, width-b.radius)
+			b.velocity.vx = min(b.velocity.vx, 0)
+		}
+		if b.pos.y+b.radius > bottomLimit {
+			b.pos.y = max(b.radius, bottomLimit-b.radius)
+			b.velocity.vy = min(b.velocity.vy, 0)
+		}
+	}
+	s.queryDirty = true
+}
+
+// Step advances the simulation by dt seconds. It ages gas, changes phase and
+// applies the fluid forces (fluidIterations density passes for water), then
+// integrates every particle with Euler or Verlet, splitting fast movers into
+// up to maxIntegrationSubsteps sub-steps against the bounds. Contacts are then
+// solved collisionSolves times over the spatial hash. Forces and motion scale
+// with dt, but the iteration counts don't, so steps far from
+// 1/simulationTickRate trade accuracy for speed.
+func (s *Simulation) Step(dt float32) {
+	s.queryDirty = true
+	s.ageGas()
+	s.phaseTransition(dt * simulationTickRate)
+	s.applyWaterForces()
+	s.applyGasForces()
+
+	ticks := dt * simulationTickRate
+	s.applyBuoyancy(ticks)
+	dragFactor := float32(math.Pow(float64(1-s.settings.airDrag), float64(ticks)))
+	bottomLimit := s.height - screenPadding
+	rightLimit := s.width
+
+	if g := (Velocity{vx: s.settings.gravityX, vy: s.settings.gravityY}); g != s.lastGravity {
+		s.lastGravity = g
+		s.wakeAll()
+	}
+	s.applyWells(ticks)
+	if s.settings.sweptCollisions {
+		s.prevPos = s.prevPos[:0]
+		for i := range s.balls {
+			s.prevPos = append(s.prevPos, s.balls[i].pos)
+		}
+	}
+	for i := range s.balls {
+		if s.balls[i].material == MaterialStatic || s.balls[i].frozen || s.balls[i].asleep() {
+			continue
+		}
+		verlet := s.settings.integration == IntegrationVerlet
+		if verlet {
+			s.balls[i].velocity.vx += s.balls[i].accel.vx * ticks / 2
+			s.balls[i].velocity.vy += s.balls[i].accel.vy * ticks / 2
+		} else {
+			gx, gy := s.gravityFor(i)
+			gx *= ticks
+			gy *= ticks
+			s.balls[i].velocity.vx += gx
+			s.balls[i].velocity.vy += gy
+			s.probe(&s.forces.gravity, i, gx, gy)
+		}
+		if wx, wy := s.settings.windFor(s.balls[i].material); wx != 0 || wy != 0 {
+			s.balls[i].velocity.vx += wx * ticks
+			s.balls[i].velocity.vy += wy * ticks
+			s.probe(&s.forces.wind, i, wx*ticks, wy*ticks)
+		}
+		s.balls[i].velocity.vx *= dragFactor
+		s.balls[i].velocity.vy *= dragFactor
+
+		speedSq := s.balls[i].speedSquared()
+		if speedSq > s.settings.maxSpeed*s.settings.maxSpeed {
+			speed := float32(math.Sqrt(float64(speedSq)))
+			scale := s.settings.maxSpeed / speed
+			s.balls[i].velocity.vx *= scale
+			s.balls[i].velocity.vy *= scale
+		}
+
+		// A particle moving more than its radius in one step could skip past a
+		// wall, so fast ones move in sub-steps with the bounds checked after each.
+		substeps := 1
+		if travel := s.balls[i].speed() * ticks; travel > s.balls[i].radius && s.balls[i].radius > 0 {
+			substeps = min(maxIntegrationSubsteps, int(math.Ceil(float64(travel/s.balls[i].radius))))
+		}
+		sub := ticks / float32(substeps)
+		for n := 0; n < substeps; n++ {
+			s.balls[i].pos.x += s.balls[i].velocity.vx * sub
+			s.balls[i].pos.y += s.balls[i].velocity.vy * sub
+
+			// Top barrier (optional)
+			if s.settings.hasTopBarrier {
+				topLimit := screenPadding
+				if s.balls[i].pos.y-s.balls[i].radius < topLimit {
+					s.balls[i].pos.y = topLimit + s.balls[i].radius
+					s.balls[i].velocity.vy *= -s.settings.groundRestitution
+				}
+			}
+
+			if s.balls[i].pos.y+s.balls[i].radius > bottomLimit {
+				s.balls[i].pos.y = bottomLimit - s.balls[i].radius
+				s.balls[i].velocity.vy *= -s.settings.groundRestitution
+				s.balls[i].velocity.vx *= s.settings.groundFriction
+			}
+
+			if s.balls[i].pos.x-s.balls[i].radius < 0 {
+				s.balls[i].pos.x = s.balls[i].radius
+				s.balls[i].velocity.vx *= -s.settings.groundRestitution
+			}
+
+			ballRightLimit := rightLimit - s.balls[i].radius
+			if s.balls[i].pos.x > ballRightLimit {
+				s.balls[i].pos.x = ballRightLimit
+				s.balls[i].velocity.vx *= -s.settings.groundRestitution
+			}
+		}
+
+		if verlet {
+			ax, ay := s.gravityFor(i)
+			s.balls[i].velocity.vx += ax * ticks / 2
+			s.balls[i].velocity.vy += ay * ticks / 2
+			prev := s.balls[i].accel
+			s.probe(&s.forces.gravity, i, (prev.vx+ax)*ticks/2, (prev.vy+ay)*ticks/2)
+			s.balls[i].accel = Velocity{vx: ax, vy: ay}
+		}
+	}
+	if s.settings.sweptCollisions {
+		s.sweepFastPairs()
+	}
+	s.resolveObstacleContacts()
+
+	var preContact Velocity
+	if s.probed >= 0 {
+		preContact = s.balls[s.probed].velocity
+	}
+	s.resting = slices.Grow(s.resting[:0], len(s.balls))[:len(s.balls)]
+	clear(s.resting)
+	if len(s.balls) > 1 {
+		// A calibrated cell may be too small for particles spawned since then.
+		if need := 2 * maxRadius(s.balls); need > s.collider.cellSize {
+			s.collider = newSpatialHash(need)
+		}
+		// The grid is built once per step. Contacts only nudge particles, so
+		// later iterations re-file just the few that crossed into another cell.
+		for iteration := 0; iteration < s.settings.collisionSolves; iteration++ {
+			if iteration == 0 {
+				s.collider.Clear()
+				if len(s.cellCache) < len(s.balls) {
+					s.cellCache = make([]cellCoord, len(s.balls))
+				}
+			}
+			for i := range s.balls {
+				cell := cellCoord{x: s.collider.coord(s.balls[i].pos.x), y: s.collider.coord(s.balls[i].pos.y)}
+				switch {
+				case iteration == 0:
+					s.collider.insert(i, cell.x, cell.y)
+				case cell != s.cellCache[i]:
+					s.collider.move(i, s.cellCache[i], cell)
+				default:
+					continue
+				}
+				s.cellCache[i] = cell
+			}
+
+			anyResolved := false
+			for i := range s.balls {
+				coord := s.cellCache[i]
+				for _, offset := range neighborOffsets {
+					neighbors := s.collider.cell(coord.x+offset.dx, coord.y+offset.dy)
+					for _, j := range neighbors {
+						if j <= i {
+							continue
+						}
+						a := &s.balls[i]
+						b := &s.balls[j]
+						if !layersMeet(a, b) {
+							continue
+						}
+						if (a.asleep() || b.asleep()) && s.settlePair(iteration, i, j) {
+							continue
+						}
+						ma := a.material
+						mb := b.material
+						if (a.permeable && isFluid(mb)) || (b.permeable && isFluid(ma)) {
+							continue
+						}
+						rule := s.settings.contacts[ma][mb]
+						if rule.skip {
+							continue
+						}
+						if resolveShapes(a, b, rule.bounce(s.settings.collisionRestitution), rule.friction) {
+							anyResolved = true
+							if iteration == 0 && s.settings.bubbleLift > 0 {
+								switch {
+								case ma == MaterialGas && mb == MaterialWater:
+									s.liftBubble(i, j)
+								case ma == MaterialWater && mb == MaterialGas:
+									s.liftBubble(j, i)
+								}
+							}
+						}
+						if iteration == 0 && ma == MaterialSand && mb == MaterialSand {
+							holdSandPair(a, b)
+						}
+					}
+				}
+			}
+			if !anyResolved {
+				break
+			}
+		}
+		if s.settings.collisionSolves > 0 {
+			s.conductHeat(ticks)
+		}
+	}
+	s.resolveObstacleContacts()
+	s.updateSleep()
+	if s.probed >= 0 {
+		v := s.balls[s.probed].velocity
+		s.probe(&s.forces.contact, s.probed, v.vx-preContact.vx, v.vy-preContact.vy)
+	}
+}
+
+// sweepFastPairs catches moving solids that passed through each other during
+// this step's integration. For every pair whose relative travel exceeds the
+// smaller radius it solves for the time of impact along both straight paths
+// and moves the two back to it, leaving the contact solver to bounce them.
+// Fluids are skipped since they may overlap anyway.
+func (s *Simulation) sweepFastPairs() {
+	var maxTravel, maxR float32
+	for i := range s.balls {
+		dx := s.balls[i].pos.x - s.prevPos[i].x
+		dy := s.balls[i].pos.y - s.prevPos[i].y
+		maxTravel = max(maxTravel, float32(math.Sqrt(float64(dx*dx+dy*dy))))
+		maxR = max(maxR, s.balls[i].radius)
+	}
+	if maxTravel == 0 {
+		return
+	}
+	s.impact = slices.Grow(s.impact[:0], len(s.balls))[:len(s.balls)]
+	for i := range s.impact {
+		s.impact[i] = 1
+	}
+	for i := range s.balls {
+		a := &s.balls[i]
+		ax := a.pos.x - s.prevPos[i].x
+		ay := a.pos.y - s.prevPos[i].y
+		travel := float32(math.Sqrt(float64(ax*ax + ay*ay)))
+		if travel <= a.radius || !sweepable(a) {
+			continue
+		}
+		mid := Pos{x: s.prevPos[i].x + ax/2, y: s.prevPos[i].y + ay/2}
+		for _, j := range s.QueryRadius(mid, travel/2+a.radius+maxR+maxTravel) {
+			b := &s.balls[j]
+			if j == i || !sweepable(b) || !layersMeet(a, b) {
+				continue
+			}
+			// b's motion as seen from a: start offset (ox, oy), travel (vx, vy).
+			ox := s.prevPos[j].x - s.prevPos[i].x
+			oy := s.prevPos[j].y - s.prevPos[i].y
+			vx := b.pos.x - s.prevPos[j].x - ax
+			vy := b.pos.y - s.prevPos[j].y - ay
+			reach := a.radius + b.radius
+			qa := vx*vx + vy*vy
+			if qa <= min(a.radius, b.radius)*min(a.radius, b.radius) {
+				continue
+			}
+			c := ox*ox + oy*oy - reach*reach
+			if c <= 0 {
+				continue // touching at the start already; the solver handles it
+			}
+			qb := 2 * (ox*vx + oy*vy)
+			disc := qb*qb - 4*qa*c
+			if qb >= 0 || disc < 0 {
+				continue // moving apart, or the paths never come within reach
+			}
+			t := (-qb - float32(math.Sqrt(float64(disc)))) / (2 * qa)
+			s.impact[i] = min(s.impact[i], t)
+			s.impact[j] = min(s.impact[j], t)
+		}
+	}
+	for i, t := range s.impact {
+		if t < 1 {
+			p := s.prevPos[i]
+			s.balls[i].pos = Pos{x: p.x + (s.balls[i].pos.x-p.x)*t, y: p.y + (s.balls[i].pos.y-p.y)*t}
+			s.queryDirty = true
+		}
+	}
+}
+
+// sweepable reports whether b takes part in swept collisions: moving,
+// non-fluid particles.
+func sweepable(b *Ball) bool {
+	return inverseMass(b) > 0 && !isFluid(b.material) && !b.permeable
+}
+
+// ageGas counts up the age of gas particles and, with a gas lifetime set,
+// removes those that reached it. Swap-removing while walking backward keeps
+// gasIndices and the probed index consistent.
+func (s *Simulation) ageGas() {
+	for i := len(s.balls) - 1; i >= 0; i-- {
+		if s.balls[i].material != MaterialGas {
+			continue
+		}
+		s.balls[i].age++
+		if s.settings.gasLifetime > 0 && s.balls[i].age >= s.settings.gasLifetime {
+			if s.expire != nil {
+				s.expire(i)
+			} else {
+				s.SwapRemoveParticle(i)
+			}
+		}
+	}
+}
+
+// canSleep reports whether b may fall asleep: free solids and sand only.
+func canSleep(b *Ball) bool {
+	return (b.material == MaterialSolid || b.material == MaterialSand) && !b.frozen && b.body == 0
+}
+
+// settlePair handles a contact candidate where i or j is asleep. A sleeper
+// touched by a bucket wall or by an awake partner faster than sleepSpeed wakes
+// up; otherwise both are marked as resting. It reports whether the pair can be
+// skipped because both are still asleep.
+func (s *Simulation) settlePair(iteration, i, j int) bool {
+	a, b := &s.balls[i], &s.balls[j]
+	dx := b.pos.x - a.pos.x
+	dy := b.pos.y - a.pos.y
+	reach := a.radius + b.radius + sleepContactGap
+	if dx*dx+dy*dy <= reach*reach {
+		wakeByContact(a, b)
+		wakeByContact(b, a)
+		if iteration == 0 {
+			s.resting[i], s.resting[j] = true, true
+		}
+	}
+	return a.asleep() && b.asleep()
+}
+
+func wakeByContact(sleeper, other *Ball) {
+	if sleeper.asleep() && !other.asleep() && (other.body != 0 || other.speedSquared() > sleepSpeed*sleepSpeed) {
+		sleeper.stillSteps = 0
+	}
+}
+
+// updateSleep counts quiet steps for particles that can sleep, zeroes the
+// velocity of those asleep, and wakes sleepers that were pushed faster than
+// sleepSpeed (by a tool or a well, say) or no longer rest on anything.
+func (s *Simulation) updateSleep() {
+	floor := s.height - screenPadding - sleepContactGap
+	for i := range s.balls {
+		b := &s.balls[i]
+		switch {
+		case !canSleep(b), b.speedSquared() > sleepSpeed*sleepSpeed:
+			b.stillSteps = 0
+		case b.asleep() && !s.resting[i] && b.pos.y+b.radius < floor:
+			b.stillSteps = 0
+		case b.asleep():
+			b.velocity = Velocity{}
+		default:
+			b.stillSteps++
+		}
+	}
+}
+
+// wakeAll wakes every sleeping particle.
+func (s *Simulation) wakeAll() {
+	for i := range s.balls {
+		s.balls[i].stillSteps = 0
+	}
+}
+
+// parallelMinItems is the smallest job parallelRange splits; below it the
+// goroutine overhead costs more than it saves.
+const parallelMinItems = 512
+
+// phaseTransition boils water above boilTemperature into gas and condenses
+// gas below condenseTemperature into water. A particle has to stay past its
+// threshold for 1/phaseRate ticks before it changes. It runs before the
+// fluid passes, which rebuild the per-material index lists from the new
+// materials.
+func (s *Simulation) phaseTransition(ticks float32) {
+	for i := range s.balls {
+		b := &s.balls[i]
+		var to ShapeType
+		switch {
+		case b.material == MaterialWater && b.temperature > s.settings.boilTemperature:
+			to = ShapeGas
+		case b.material == MaterialGas && b.temperature < s.settings.condenseTemperature:
+			to = ShapeWater
+		default:
+			b.phase = 0
+			continue
+		}
+		b.phase += s.settings.phaseRate * ticks
+		if b.phase < 1 {
+			continue
+		}
+		changed := createParticle(to, b.pos, s.settings.spawnRadius(to, float64(b.radius)))
+		changed.velocity = b.velocity
+		changed.temperature = b.temperature
+		changed.layer = b.layer
+		changed.seq = b.seq
+		*b = changed
+	}
+}
+
+// conductHeat moves heat between touching particles, closing a share of each
+// pair's temperature gap proportional to heatConductivity. It reuses the
+// collider and cell cache left by the last collision iteration.
+func (s *Simulation) conductHeat(ticks float32) {
+	rate := min(heatConductivity*ticks, 0.5)
+	for i := range s.balls {
+		coord := s.cellCache[i]
+		for _, offset := range neighborOffsets {
+			for _, j := range s.collider.cell(coord.x+offset.dx, coord.y+offset.dy) {
+				if j <= i {
+					continue
+				}
+				a := &s.balls[i]
+				b := &s.balls[j]
+				if a.temperature == b.temperature || !layersMeet(a, b) {
+					continue
+				}
+				dx := b.pos.x - a.pos.x
+				dy := b.pos.y - a.pos.y
+				reach := (a.radius + b.radius) * heatContactMargin
+				if dx*dx+dy*dy >= reach*reach {
+					continue
+				}
+				flow := (b.temperature - a.temperature) * rate / 2
+				a.temperature += flow
+				b.temperature -= flow
+			}
+		}
+	}
+}
+
+// gravityFor returns the per-tick gravity acting on particle i.
+func (s *Simulation) gravityFor(i int) (float32, float32) {
+	if len(s.zones) > 0 {
+		return s.gravityAt(s.balls[i].pos)
+	}
+	return s.settings.gravityX, s.settings.gravityY
+}
+
+// parallelRange calls fn over [0, n) split into one contiguous chunk per CPU
+// and waits for all of them.
+func parallelRange(n int, fn func(lo, hi int)) {
+	workers := runtime.NumCPU()
+	if n < parallelMinItems || workers < 2 {
+		fn(0, n)
+		return
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += chunk {
+		hi := min(lo+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
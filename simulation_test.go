@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// testStep is one step at the rate the solver is tuned for.
+const testStep = float32(1) / simulationTickRate
+
+// newTestSimulation is a headless 1280x720 world with gravity off, so tests
+// only see the forces they set up.
+func newTestSimulation() *Simulation {
+	s := NewSimulation(1280, 720)
+	s.settings.gravityX, s.settings.gravityY = 0, 0
+	return s
+}
+
+func distance(a, b Pos) float32 {
+	return float32(math.Hypot(float64(a.x-b.x), float64(a.y-b.y)))
+}
+
+func TestStepSeparatesOverlappingSolids(t *testing.T) {
+	s := newTestSimulation()
+	a := s.AddParticle(createBall(createPos(400, 300), 10, ShapeCircle))
+	b := s.AddParticle(createBall(createPos(405, 300), 10, ShapeCircle))
+
+	s.Step(testStep)
+
+	if d := distance(s.balls[a].pos, s.balls[b].pos); d < 20-1e-3 {
+		t.Fatalf("centers %.3f apart after one step, want at least the radius sum 20", d)
+	}
+}
+
+func TestQueryRadiusMatchesLinearScan(t *testing.T) {
+	s := newTestSimulation()
+	rng := rand.New(rand.NewSource(1))
+	for range 500 {
+		pos := createPos(rng.Float32()*s.width, rng.Float32()*s.height)
+		s.AddParticle(createBall(pos, minSpawnRadius+rng.Float32()*(maxSpawnRadius-minSpawnRadius), ShapeCircle))
+	}
+
+	for q := range 50 {
+		p := createPos(rng.Float32()*s.width, rng.Float32()*s.height)
+		r := rng.Float32() * 150
+		if q == 0 {
+			r = 0
+		}
+		var want []int
+		for i := range s.balls {
+			dx, dy := s.balls[i].pos.x-p.x, s.balls[i].pos.y-p.y
+			if dx*dx+dy*dy <= r*r {
+				want = append(want, i)
+			}
+		}
+		got := s.QueryRadius(p, r)
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Fatalf("QueryRadius(%v, %v) = %v, linear scan found %v", p, r, got, want)
+		}
+	}
+}
+
+// checkWaterIndex fails unless waterIndices lists exactly the water particles,
+// waterIndexMap maps each of them back to its slot, and the per-slot caches
+// are as long as the list.
+func checkWaterIndex(t *testing.T, s *Simulation) {
+	t.Helper()
+	var water int
+	for i := range s.balls {
+		if s.balls[i].material == MaterialWater {
+			water++
+		}
+	}
+	if len(s.waterIndices) != water || len(s.waterIndexMap) != water {
+		t.Fatalf("%d water particles, but waterIndices has %d and waterIndexMap %d", water, len(s.waterIndices), len(s.waterIndexMap))
+	}
+	for slot, idx := range s.waterIndices {
+		if idx < 0 || idx >= len(s.balls) || s.balls[idx].material != MaterialWater {
+			t.Fatalf("waterIndices[%d] = %d is not a water particle", slot, idx)
+		}
+		if got, ok := s.waterIndexMap[idx]; !ok || got != slot {
+			t.Fatalf("waterIndexMap[%d] = %d, %v; want slot %d", idx, got, ok, slot)
+		}
+	}
+	if len(s.waterDensity) != water || len(s.waterNearDensity) != water || len(s.waterCellCache) != water {
+		t.Fatalf("water caches have %d/%d/%d slots for %d particles", len(s.waterDensity), len(s.waterNearDensity), len(s.waterCellCache), water)
+	}
+}
+
+// checkGasIndex fails unless gasIndices lists exactly the gas particles and
+// gasIndexMap maps each of them back to its slot.
+func checkGasIndex(t *testing.T, s *Simulation) {
+	t.Helper()
+	var gas int
+	for i := range s.balls {
+		if s.balls[i].material == MaterialGas {
+			gas++
+		}
+	}
+	if len(s.gasIndices) != gas || len(s.gasIndexMap) != gas {
+		t.Fatalf("%d gas particles, but gasIndices has %d and gasIndexMap %d", gas, len(s.gasIndices), len(s.gasIndexMap))
+	}
+	for slot, idx := range s.gasIndices {
+		if idx < 0 || idx >= len(s.balls) || s.balls[idx].material != MaterialGas {
+			t.Fatalf("gasIndices[%d] = %d is not a gas particle", slot, idx)
+		}
+		if got, ok := s.gasIndexMap[idx]; !ok || got != slot {
+			t.Fatalf("gasIndexMap[%d] = %d, %v; want slot %d", idx, got, ok, slot)
+		}
+	}
+}
+
+// newMixedSimulation holds a grid of 40 solids, water and gas, stepped once
+// so the per-material lists are filled.
+func newMixedSimulation() *Simulation {
+	s := newTestSimulation()
+	shapes := [...]ShapeType{ShapeCircle, ShapeWater, ShapeWater, ShapeGas}
+	for i := range 40 {
+		pos := createPos(100+float32(i%10)*30, 100+float32(i/10)*30)
+		s.AddParticle(createParticle(shapes[i%len(shapes)], pos, 5))
+	}
+	s.Step(testStep)
+	return s
+}
+
+func TestSwapRemoveParticleKeepsIndexMaps(t *testing.T) {
+	s := newMixedSimulation()
+	checkWaterIndex(t, s)
+	checkGasIndex(t, s)
+
+	// Hit water and gas both at the end (no rename) and before it, so the
+	// last particle is renamed into the freed index.
+	for _, id := range []int{len(s.balls) - 1, 1, 3, 0, len(s.balls) - 2, 2, 10} {
+		s.SwapRemoveParticle(id)
+		checkWaterIndex(t, s)
+		checkGasIndex(t, s)
+	}
+	s.Step(testStep)
+	checkWaterIndex(t, s)
+	checkGasIndex(t, s)
+}
+
+func TestRemoveParticleKeepsWaterIndex(t *testing.T) {
+	s := newTestSimulation()
+	for i := range 40 {
+		pos := createPos(100+float32(i%10)*30, 100+float32(i/10)*30)
+		if i%3 == 0 {
+			s.AddParticle(createBall(pos, 8, ShapeCircle))
+		} else {
+			s.AddParticle(createParticle(ShapeWater, pos, 5))
+		}
+	}
+	s.Step(testStep)
+	checkWaterIndex(t, s)
+
+	// Remove from the front, the middle and the end, hitting both water
+	// particles and the solids between them.
+	for _, id := range []int{0, 1, 17, 20, len(s.balls) - 1, 5} {
+		s.RemoveParticle(id)
+		checkWaterIndex(t, s)
+	}
+	s.Step(testStep)
+	checkWaterIndex(t, s)
+}
+
+func TestHeavyBallBarelyDeflects(t *testing.T) {
+	heavy := createBall(createPos(100, 100), 10, ShapeCircle)
+	light := createBall(createPos(119, 100), 10, ShapeCircle)
+	heavy.mass, light.mass = 10, 1
+	heavy.velocity.vx = 5
+
+	if !resolveCollisionCustom(&heavy, &light, 1, 0) {
+		t.Fatal("touching balls reported no collision")
+	}
+
+	// An elastic head-on hit leaves the heavy ball (10-1)/(10+1) of its speed
+	// and sends the light one off at about 2*10/(10+1) of it.
+	if heavy.velocity.vx < 0.75*5 {
+		t.Errorf("mass-10 ball slowed to %.3f from 5, want it to keep most of its speed", heavy.velocity.vx)
+	}
+	if light.velocity.vx <= heavy.velocity.vx {
+		t.Errorf("mass-1 ball moves at %.3f, want faster than the heavy ball's %.3f", light.velocity.vx, heavy.velocity.vx)
+	}
+	if math.Abs(float64(heavy.velocity.vy)) > 1e-3 {
+		t.Errorf("head-on hit turned the heavy ball: vy %.3f", heavy.velocity.vy)
+	}
+}
+
+func TestFastBallStaysAboveFloor(t *testing.T) {
+	s := newTestSimulation()
+	s.settings.maxSpeed = 50
+	floor := s.height - screenPadding
+	b := createBall(createPos(400, floor-10), 4, ShapeCircle)
+	b.velocity.vy = 50
+	id := s.AddParticle(b)
+
+	for step := range 10 {
+		s.Step(testStep)
+		if got := s.balls[id].pos.y + s.balls[id].radius; got > floor+1e-3 {
+			t.Fatalf("step %d: ball bottom at %.2f, below the floor at %.2f", step, got, floor)
+		}
+	}
+}
+
+// bounceEnergyDrift drops one ball onto a perfectly elastic floor with no
+// drag and returns the largest change in its total energy per unit mass,
+// kinetic plus gravitational, over 600 steps.
+func bounceEnergyDrift(integration IntegrationMethod) float64 {
+	s := NewSimulation(1280, 720)
+	s.settings.integration = integration
+	s.settings.gravityX = 0
+	s.settings.airDrag = 0
+	s.settings.groundRestitution = 1
+	s.settings.maxSpeed = 1000
+	id := s.AddParticle(createBall(createPos(400, 200), 10, ShapeCircle))
+
+	g := float64(s.settings.gravityY)
+	energy := func() float64 {
+		b := &s.balls[id]
+		vx, vy := float64(b.velocity.vx), float64(b.velocity.vy)
+		return 0.5*(vx*vx+vy*vy) - g*float64(b.pos.y)
+	}
+	start, drift := energy(), 0.0
+	for range 600 {
+		s.Step(testStep)
+		drift = max(drift, math.Abs(energy()-start))
+	}
+	return drift
+}
+
+func TestVerletDriftsLessThanEuler(t *testing.T) {
+	euler := bounceEnergyDrift(IntegrationEuler)
+	verlet := bounceEnergyDrift(IntegrationVerlet)
+	t.Logf("energy drift over 600 steps: Euler %.4f, Verlet %.4f", euler, verlet)
+	if verlet >= euler {
+		t.Fatalf("Verlet drifted %.4f, no better than Euler's %.4f", verlet, euler)
+	}
+}
+
+func TestPhaseTransition(t *testing.T) {
+	settings := defaultSettings()
+	tests := []struct {
+		name        string
+		from        ShapeType
+		temperature float32
+		want        MaterialType
+	}{
+		{"water above boiling becomes gas", ShapeWater, settings.boilTemperature + 10, MaterialGas},
+		{"water below boiling stays water", ShapeWater, settings.boilTemperature - 10, MaterialWater},
+		{"gas below condensing becomes water", ShapeGas, settings.condenseTemperature - 5, MaterialWater},
+		{"gas above condensing stays gas", ShapeGas, settings.condenseTemperature + 5, MaterialGas},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSimulation()
+			id := s.AddParticle(createParticle(tt.from, createPos(400, 300), 5))
+
+			// Hold the temperature for long enough that phase reaches 1.
+			steps := int(math.Ceil(float64(1/s.settings.phaseRate))) + 1
+			for range steps {
+				s.balls[id].temperature = tt.temperature
+				s.phaseTransition(1)
+			}
+			if got := s.balls[id].material; got != tt.want {
+				t.Fatalf("material after %d ticks at %v degrees is %v, want %v", steps, tt.temperature, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackedSquaresRestFlat(t *testing.T) {
+	for _, offset := range []float32{0, 3} {
+		t.Run(fmt.Sprintf("offset %v", offset), func(t *testing.T) {
+			s := NewSimulation(1280, 720)
+			const r = 10
+			floor := s.height - screenPadding
+			var ids [3]int
+			for i := range ids {
+				// Each square starts a little above the last, shifted by offset.
+				pos := createPos(400+float32(i)*offset, floor-r-float32(i)*(2*r+1))
+				ids[i] = s.AddParticle(createBall(pos, r, ShapeSquare))
+			}
+
+			for range 600 {
+				s.Step(testStep)
+			}
+
+			for i, id := range ids {
+				b := &s.balls[id]
+				if want := 400 + float32(i)*offset; math.Abs(float64(b.pos.x-want)) > 0.5 {
+					t.Errorf("square %d drifted to x %.2f from %.2f", i, b.pos.x, want)
+				}
+				if want := floor - r - float32(i)*2*r; math.Abs(float64(b.pos.y-want)) > 1 {
+					t.Errorf("square %d rests at y %.2f, want %.2f flat on the one below", i, b.pos.y, want)
+				}
+				if speed := b.speed(); speed > 0.05 {
+					t.Errorf("square %d still moving at %.3f", i, speed)
+				}
+			}
+		})
+	}
+}
+
+func TestLiftBubbleIgnoresBuoyancyDensities(t *testing.T) {
+	s := newTestSimulation()
+	water := s.AddParticle(createParticle(ShapeWater, createPos(400, 300), 5))
+	gas := s.AddParticle(createParticle(ShapeGas, createPos(400, 308), 5))
+
+	s.liftBubble(gas, water)
+
+	if want := -s.settings.bubbleLift * 0.9; math.Abs(float64(s.balls[gas].velocity.vy-want)) > 1e-6 {
+		t.Errorf("bubble lift gave vy %v, want %v", s.balls[gas].velocity.vy, want)
+	}
+	if got := s.balls[water].velocity.vy; math.Abs(float64(got+s.balls[gas].velocity.vy)) > 1e-6 {
+		t.Errorf("water pushed down by %v, want the opposite of the gas's %v", got, s.balls[gas].velocity.vy)
+	}
+}
+
+// checkIDs fails unless every particle's seq maps back to its index.
+func checkIDs(t *testing.T, g *Game) {
+	t.Helper()
+	if len(g.ids) != len(g.balls) {
+		t.Fatalf("id map has %d entries for %d particles", len(g.ids), len(g.balls))
+	}
+	for i := range g.balls {
+		if got, ok := g.particleIndex(g.balls[i].seq); !ok || got != i {
+			t.Fatalf("particle %d (seq %d) maps to %d, %v", i, g.balls[i].seq, got, ok)
+		}
+	}
+}
+
+func TestMixedAddRemoveKeepsIDsAndIndices(t *testing.T) {
+	g := NewGame()
+	g.settings.gravityX, g.settings.gravityY = 0, 0
+	g.settings.gasLifetime = 3
+	var spawned, despawned, removed int
+	g.onSpawn(func(g *Game, i int) { spawned++ })
+	g.onDespawn(func(g *Game, i int) {
+		if _, ok := g.particleIndex(g.balls[i].seq); !ok {
+			t.Errorf("despawn hook saw particle %d without an id", i)
+		}
+		despawned++
+	})
+
+	rng := rand.New(rand.NewSource(3))
+	shapes := [...]ShapeType{ShapeCircle, ShapeWater, ShapeGas, ShapeSand, ShapeStatic}
+	for round := range 20 {
+		for range 15 {
+			pos := createPos(100+rng.Float32()*1000, 100+rng.Float32()*500)
+			g.addParticle(createParticle(shapes[rng.Intn(len(shapes))], pos, 5))
+		}
+		checkIDs(t, g)
+		// Expired gas is removed inside Step through the same helpers.
+		g.Step(testStep)
+		checkIDs(t, g)
+		checkWaterIndex(t, &g.Simulation)
+		checkGasIndex(t, &g.Simulation)
+
+		for range 6 {
+			if len(g.balls) == 0 {
+				break
+			}
+			if i := rng.Intn(len(g.balls)); round%2 == 0 {
+				g.removeParticle(i)
+			} else {
+				g.removeParticleOrdered(i)
+			}
+			removed++
+			checkIDs(t, g)
+			checkWaterIndex(t, &g.Simulation)
+			checkGasIndex(t, &g.Simulation)
+		}
+		if spawned-despawned != len(g.balls) {
+			t.Fatalf("round %d: %d spawned, %d despawned, but %d particles", round, spawned, despawned, len(g.balls))
+		}
+	}
+
+	if despawned <= removed {
+		t.Errorf("despawn hooks ran %d times for %d direct removals; expired gas never reached them", despawned, removed)
+	}
+	g.clearParticles()
+	checkIDs(t, g)
+	if spawned != despawned {
+		t.Errorf("after clearing, %d spawned but %d despawned", spawned, despawned)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamInterval throttles --ws-port snapshots to about 30 per second,
+// whatever the tick rate.
+const streamInterval = time.Second / 30
+
+// websocketGUID is the fixed key suffix from RFC 6455 used in the handshake.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// streamServer broadcasts particle snapshots to WebSocket clients. Snapshots
+// are encoded on the game goroutine between steps; clients only ever see the
+// finished bytes, so the physics state is never read concurrently.
+type streamServer struct {
+	mu       sync.Mutex
+	clients  map[*streamClient]struct{}
+	lastSent time.Time
+	buf      []byte
+}
+
+type streamClient struct {
+	conn net.Conn
+	// frames holds at most one pending snapshot; a slow client skips frames
+	// rather than holding up the simulation.
+	frames chan []byte
+}
+
+// startStream listens on port and serves the WebSocket stream on every path.
+func startStream(port int) (*streamServer, error) {
+	s := &streamServer{clients: make(map[*streamClient]struct{})}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(ln, http.HandlerFunc(s.accept))
+	return s, nil
+}
+
+// accept upgrades an HTTP request to a WebSocket connection.
+func (s *streamServer) accept(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "phixgo streams particles over WebSocket only", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	c := &streamClient{conn: conn, frames: make(chan []byte, 1)}
+	s.mu.Lock()
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+	go c.write()
+	go func() {
+		c.read(rw.Reader)
+		s.mu.Lock()
+		delete(s.clients, c)
+		s.mu.Unlock()
+		close(c.frames)
+	}()
+}
+
+// write sends queued snapshots as text frames until the client goes away.
+func (c *streamClient) write() {
+	defer c.conn.Close()
+	var header [10]byte
+	for frame := range c.frames {
+		header[0] = 0x81 // FIN, text
+		n := 2
+		switch {
+		case len(frame) < 126:
+			header[1] = byte(len(frame))
+		case len(frame) <= 0xFFFF:
+			header[1] = 126
+			binary.BigEndian.PutUint16(header[2:], uint16(len(frame)))
+			n = 4
+		default:
+			header[1] = 127
+			binary.BigEndian.PutUint64(header[2:], uint64(len(frame)))
+			n = 10
+		}
+		if _, err := c.conn.Write(header[:n]); err != nil {
+			return
+		}
+		if _, err := c.conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// read discards whatever the client sends and returns on a close frame or a
+// broken connection.
+func (c *streamClient) read(r *bufio.Reader) {
+	var header [2]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		if header[0]&0x0F == 0x8 {
+			return
+		}
+		length := uint64(header[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if header[1]&0x80 != 0 {
+			length += 4 // masking key
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(length)); err != nil {
+			return
+		}
+	}
+}
+
+// publish encodes the particles as JSON and hands the snapshot to every
+// client, at most once per streamInterval. Each particle is
+// [x, y, vx, vy, radius, material]:
+//
+//	{"tick":120,"width":1280,"height":720,"particles":[[640.0,300.5,0.00,1.25,10.0,0],...]}
+func (s *streamServer) publish(tick int, width, height float32, balls []Ball) {
+	now := time.Now()
+	if now.Sub(s.lastSent) < streamInterval {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return
+	}
+	s.lastSent = now
+
+	b := s.buf[:0]
+	b = append(b, `{"tick":`...)
+	b = strconv.AppendInt(b, int64(tick), 10)
+	b = append(b, `,"width":`...)
+	b = strconv.AppendFloat(b, float64(width), 'f', 0, 32)
+	b = append(b, `,"height":`...)
+	b = strconv.AppendFloat(b, float64(height), 'f', 0, 32)
+	b = append(b, `,"particles":[`...)
+	for i := range balls {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		p := &balls[i]
+		b = append(b, '[')
+		b = strconv.AppendFloat(b, float64(p.pos.x), 'f', 1, 32)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, float64(p.pos.y), 'f', 1, 32)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, float64(p.velocity.vx), 'f', 2, 32)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, float64(p.velocity.vy), 'f', 2, 32)
+		b = append(b, ',')
+		b = strconv.AppendFloat(b, float64(p.radius), 'f', 1, 32)
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(p.material), 10)
+		b = append(b, ']')
+	}
+	b = append(b, "]}"...)
+	s.buf = b
+
+	// Clients keep the bytes after this returns, so each snapshot gets its
+	// own copy; buf is only scratch space for building it.
+	frame := append([]byte(nil), b...)
+	for c := range s.clients {
+		select {
+		case c.frames <- frame:
+		default:
+		}
+	}
+}